@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"      // Import the fmt package for formatted I/O (printing messages, etc.)
 	"log"      // Import the log package for logging errors
+	"net"      // for BaseContext
 	"net/http" // listen and serve
 	"os"       // Import the os package to access environment variables and other OS functions
+	"os/signal"
+	"syscall"
+	"time"
 
 	"frappuccino/internal/routes" // for mux
 
@@ -32,9 +38,35 @@ func main() {
 	// 	log.Fatal(err)
 	// }
 
-	routes := router.Allrouter(db)
+	// Ctrl+C or docker stop: фондық жұмыстар тоқтайды, сервер ашық сұраныстарды бітіреді
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Fatal(http.ListenAndServe(":8080", routes))
+	routes, start := router.Allrouter(db, dsn)
+	if err = start(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: routes,
+		// requests end with ctx too, else GET /orders/stream would hold Shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("shutdown:", err)
+		}
+	}()
+	if err = server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-closed // ListenAndServe returns at once, Shutdown waits for the requests
 }
 
 // fmt.Fprintln(os.Stderr, "ERROR: invalid app host port")
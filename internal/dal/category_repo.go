@@ -0,0 +1,101 @@
+package dal
+
+import (
+	"database/sql"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type dalCategory struct {
+	db *sqlx.DB
+}
+
+type CategoryDalInter interface {
+	SelectAllCategories() ([]models.Category, error)
+	SelectCategory(uint64) (*models.Category, error)
+	InsertCategory(*models.Category) error
+	UpdateCategory(*models.Category) error
+	DeleteCategory(uint64) error
+}
+
+func ReturnDalCategory(db *sqlx.DB) CategoryDalInter {
+	return &dalCategory{db: db}
+}
+
+func (core *dalCategory) SelectAllCategories() ([]models.Category, error) {
+	categories := []models.Category{}
+	err := core.db.Select(&categories, `SELECT * FROM categories ORDER BY position, id`)
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (core *dalCategory) SelectCategory(id uint64) (*models.Category, error) {
+	var category models.Category
+	err := core.db.Get(&category, `SELECT * FROM categories WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return &category, err
+}
+
+func (core *dalCategory) InsertCategory(category *models.Category) error {
+	err := core.db.QueryRow(`
+	INSERT INTO categories (name, description, position)
+	VALUES ($1, $2, $3)
+	RETURNING id`, category.Name, category.Description, category.Position).Scan(&category.ID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { // unique
+		return models.ErrConflict
+	}
+	return err
+}
+
+func (core *dalCategory) UpdateCategory(category *models.Category) error {
+	result, err := core.db.NamedExec(`
+	UPDATE categories
+		SET name = :name, description = :description, position = :position
+		WHERE id = :id`, category)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return models.ErrConflict
+		}
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteCategory: a category with menu items is not deleted, they are moved first
+func (core *dalCategory) DeleteCategory(id uint64) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.Get(&exists, `SELECT TRUE FROM categories WHERE id = $1 FOR UPDATE`, id); err == sql.ErrNoRows {
+		return models.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	var items uint64
+	if err = tx.Get(&items, `SELECT COUNT(*) FROM menu_items WHERE category_id = $1`, id); err != nil {
+		return err
+	}
+	if items != 0 {
+		return models.ErrConflict
+	}
+	if _, err = tx.Exec(`DELETE FROM categories WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package dal
+
+import (
+	"database/sql"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type dalCustomer struct {
+	db *sqlx.DB
+}
+
+type CustomerDalInter interface {
+	SelectAllCustomers() ([]models.Customer, error)
+	SelectCustomer(uint64) (*models.Customer, error)
+	InsertCustomer(*models.Customer) error
+	UpdateCustomer(*models.Customer) error
+	DeleteCustomer(uint64) error
+}
+
+func ReturnDalCustomer(db *sqlx.DB) CustomerDalInter {
+	return &dalCustomer{db: db}
+}
+
+func (core *dalCustomer) SelectAllCustomers() ([]models.Customer, error) {
+	customers := []models.Customer{}
+	err := core.db.Select(&customers, `SELECT * FROM customers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return customers, nil
+}
+
+func (core *dalCustomer) SelectCustomer(id uint64) (*models.Customer, error) {
+	var customer models.Customer
+	err := core.db.Get(&customer, `SELECT * FROM customers WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return &customer, err
+}
+
+func (core *dalCustomer) InsertCustomer(customer *models.Customer) error {
+	err := core.db.QueryRowx(`
+	INSERT INTO customers (name, phone, email, allergens, notes)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, updated_at`,
+		customer.Name, customer.Phone, customer.Email, customer.Allergens, customer.Notes).
+		Scan(&customer.ID, &customer.CreatedAt, &customer.UpdatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { // phone or email
+		return models.ErrConflict
+	}
+	return err
+}
+
+func (core *dalCustomer) UpdateCustomer(customer *models.Customer) error {
+	err := core.db.QueryRowx(`
+	UPDATE customers
+		SET name = $2, phone = $3, email = $4, allergens = $5, notes = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	RETURNING created_at, updated_at`,
+		customer.ID, customer.Name, customer.Phone, customer.Email, customer.Allergens, customer.Notes).
+		Scan(&customer.CreatedAt, &customer.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.ErrNotFound
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return models.ErrConflict
+	}
+	return err
+}
+
+// DeleteCustomer: a customer with orders is kept (23503), the orders are their history
+func (core *dalCustomer) DeleteCustomer(id uint64) error {
+	result, err := core.db.Exec(`DELETE FROM customers WHERE id = $1`, id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return models.ErrConflict
+		}
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
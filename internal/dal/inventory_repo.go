@@ -2,6 +2,7 @@ package dal
 
 import (
 	"database/sql"
+	"fmt"
 
 	"frappuccino/models"
 
@@ -26,6 +27,8 @@ type InventoryDataAccess interface {
 	DeleteInventory(uint64) (*models.InventoryDepend, error)
 	SelectAllInventoryTransaction() ([]models.InventoryTransaction, error)
 	SelectReorder() ([]models.Inventory, error)
+	UpdatePrepRecipe(id uint64, recipe *[]models.PrepIngredient) error
+	ProducePrep(*models.Production) error
 }
 
 func ReturnDalInvCore(db *sqlx.DB) InventoryDataAccess {
@@ -141,7 +144,25 @@ func (core *dalInv) InsertInventoryV6(inv *models.Inventory) error {
 
 func (core *dalInv) SelectAllInventories() ([]models.Inventory, error) {
 	var invts []models.Inventory
-	return invts, core.db.Select(&invts, "SELECT * FROM inventory")
+	err := core.db.Select(&invts, "SELECT * FROM inventory")
+	if err != nil {
+		return nil, err
+	}
+	var recipes []models.PrepIngredient
+	err = core.db.Select(&recipes, "SELECT * FROM prep_ingredients ORDER BY prep_id")
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[uint64]int, len(invts))
+	for i, inv := range invts {
+		index[inv.ID] = i
+	}
+	for _, ing := range recipes {
+		if i, ok := index[ing.PrepID]; ok {
+			invts[i].Recipe = append(invts[i].Recipe, ing)
+		}
+	}
+	return invts, nil
 }
 
 func (core *dalInv) SelectInventory(id uint64) (*models.Inventory, error) {
@@ -149,8 +170,10 @@ func (core *dalInv) SelectInventory(id uint64) (*models.Inventory, error) {
 	err := core.db.Get(&inv, "SELECT * FROM inventory WHERE id = $1", id)
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNotFound
+	} else if err != nil {
+		return nil, err
 	}
-	return &inv, err
+	return &inv, core.db.Select(&inv.Recipe, "SELECT * FROM prep_ingredients WHERE prep_id = $1", id)
 }
 
 func (core *dalInv) UpdateInventory(inv *models.Inventory) error {
@@ -206,14 +229,30 @@ func (core *dalInv) DeleteInventory(id uint64) (*models.InventoryDepend, error)
 	const menusNames string = `SELECT id, name
 		FROM menu_items
 		JOIN menu_item_ingredients ON id=product_id
-		WHERE inventory_id=$1`
+		WHERE inventory_id=$1
+	UNION
+	SELECT m.id, m.name
+		FROM menu_items AS m
+		JOIN modifier_groups AS g ON g.product_id = m.id
+		JOIN modifier_options AS o ON o.group_id = g.id
+		JOIN modifier_option_ingredients AS oi ON oi.option_id = o.id
+		WHERE oi.inventory_id=$1`
 
 	err = tx.Select(&menuDepend.Menus, menusNames, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(menuDepend.Menus) != 0 {
+	// prep items made of it
+	err = tx.Select(&menuDepend.Preps, `SELECT id, name
+		FROM inventory
+		JOIN prep_ingredients ON id=prep_id
+		WHERE inventory_id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(menuDepend.Menus) != 0 || len(menuDepend.Preps) != 0 {
 		return &menuDepend, nil
 	}
 	res, err := tx.Exec(`DELETE FROM inventory WHERE id = $1`, id)
@@ -245,3 +284,370 @@ func (core *dalInv) SelectReorder() ([]models.Inventory, error) {
 	var invs []models.Inventory
 	return invs, core.db.Select(&invs, `SELECT * FROM inventory WHERE quantity <= reorder_level`)
 }
+
+// recipeGraphLock is the advisory lock of prep_ingredients: recipes are changed one by one,
+// so 2 changes at once can not make a cycle which neither of them sees
+const recipeGraphLock int64 = 0x7265636970 // "recip"
+
+// UpdatePrepRecipe makes the inventory row a prep item (or a raw one with empty recipe).
+// Not found ingredients are returned in recipe, a recipe which leads back to the item is rejected
+func (core *dalInv) UpdatePrepRecipe(id uint64, recipe *[]models.PrepIngredient) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, recipeGraphLock); err != nil {
+		return err
+	}
+
+	var exists bool
+	if err = tx.Get(&exists, `SELECT TRUE FROM inventory WHERE id = $1 FOR UPDATE`, id); err == sql.ErrNoRows {
+		return models.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	ids := make(pq.Int64Array, len(*recipe))
+	var notFound []models.PrepIngredient
+	for i, ing := range *recipe {
+		ids[i] = int64(ing.InventoryID)
+		err = tx.Get(&exists, `SELECT TRUE FROM inventory WHERE id = $1`, ing.InventoryID)
+		if err == sql.ErrNoRows {
+			ing.Status = "not found"
+			notFound = append(notFound, ing)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(notFound) != 0 {
+		*recipe = notFound
+		return models.ErrNotFoundItems
+	}
+
+	// the item must not be anywhere down the tree of its new ingredients
+	var cycle bool
+	err = tx.Get(&cycle, `
+	WITH RECURSIVE tree AS (
+		SELECT unnest($2::int[]) AS id
+		UNION
+		SELECT p.inventory_id FROM prep_ingredients AS p JOIN tree ON p.prep_id = tree.id
+	)
+	SELECT EXISTS (SELECT 1 FROM tree WHERE id = $1)`, id, ids)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return fmt.Errorf("%w : recipe of %d leads back to itself", models.ErrBadInput, id)
+	}
+
+	if _, err = tx.Exec(`DELETE FROM prep_ingredients WHERE prep_id = $1`, id); err != nil {
+		return err
+	}
+	for _, ing := range *recipe {
+		_, err = tx.Exec(`
+		INSERT INTO prep_ingredients (prep_id, inventory_id, quantity)
+		VALUES ($1, $2, $3)`, id, ing.InventoryID, ing.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ProducePrep makes a batch of a prep item: its recipe is taken from inventory
+// (missing sub-prep items are made on the way) and the prep stock grows.
+// A deadlock with an order is run again, as orders do
+func (core *dalInv) ProducePrep(prod *models.Production) error {
+	return retrySerial(func() error {
+		return core.produceOnce(prod)
+	})
+}
+
+func (core *dalInv) produceOnce(prod *models.Production) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var recipe []models.PrepIngredient
+	if err = tx.Select(&recipe, `SELECT * FROM prep_ingredients WHERE prep_id = $1`, prod.PrepID); err != nil {
+		return err
+	}
+	if len(recipe) == 0 {
+		var exists bool
+		if err = tx.Get(&exists, `SELECT TRUE FROM inventory WHERE id = $1`, prod.PrepID); err == sql.ErrNoRows {
+			return models.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w : %d is not a prep item", models.ErrBadInput, prod.PrepID)
+	}
+
+	// the whole tree is locked by id before it is read: two batches wait for each other
+	// in one order, and the stock checked below is what the UPDATEs change, not a CHECK failure
+	_, err = tx.Exec(`
+	WITH RECURSIVE parts (id, depth) AS (
+		SELECT $1::int, 0
+		UNION
+		SELECT p.inventory_id, parts.depth + 1
+			FROM prep_ingredients AS p
+			JOIN parts ON parts.id = p.prep_id
+			WHERE parts.depth < $2
+	)
+	SELECT id
+		FROM inventory
+		WHERE id IN (SELECT id FROM parts)
+		ORDER BY id
+		FOR UPDATE`, prod.PrepID, maxRecipeDepth)
+	if err != nil {
+		return err
+	}
+
+	demands := make([]models.InventoryUpdate, len(recipe))
+	for i, ing := range recipe {
+		demands[i] = models.InventoryUpdate{InventoryID: ing.InventoryID, QuantityUsed: ing.Quantity * prod.Quantity}
+	}
+	tree, err := newRecipeTree(tx)
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
+	if prod.Used, prod.NotEnough, err = tree.take(demands); err != nil {
+		return err
+	}
+	if len(prod.NotEnough) != 0 {
+		prod.Used = nil
+		return models.ErrOrderNotEnoughItems
+	}
+
+	for _, used := range prod.Used {
+		_, err = tx.Exec(`UPDATE inventory SET quantity = quantity - $1 WHERE id = $2`, used.QuantityUsed, used.InventoryID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO inventory_transactions (inventory_id, quantity_change, reason)
+		VALUES ($1, $2, 'production')`, used.InventoryID, -used.QuantityUsed) // out of stock is -
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`UPDATE inventory SET quantity = quantity + $1 WHERE id = $2`, prod.Quantity, prod.PrepID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO inventory_transactions (inventory_id, quantity_change, reason)
+	VALUES ($1, $2, 'production')`, prod.PrepID, prod.Quantity)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// maxRecipeDepth stops the walk down the recipe tree, cycles are rejected on save anyway
+const maxRecipeDepth = 8
+
+// recipeTree walks prep items down to what they are made of. It reads the rows with
+// prepared statements, or from a snapshot of the whole inventory (loadRecipeTree)
+type recipeTree struct {
+	stock  *sqlx.Stmt
+	recipe *sqlx.Stmt
+
+	shelves map[uint64]models.Inventory
+	recipes map[uint64][]models.PrepIngredient
+}
+
+func newRecipeTree(tx *sqlx.Tx) (*recipeTree, error) {
+	stock, err := tx.Preparex(`SELECT id, name, quantity FROM inventory WHERE id = $1`)
+	if err != nil {
+		return nil, err
+	}
+	recipe, err := tx.Preparex(`SELECT inventory_id, quantity FROM prep_ingredients WHERE prep_id = $1`)
+	if err != nil {
+		stock.Close()
+		return nil, err
+	}
+	return &recipeTree{stock: stock, recipe: recipe}, nil
+}
+
+// loadRecipeTree reads all inventory and prep recipes at once, for many read-only walks
+func loadRecipeTree(tx *sqlx.Tx) (*recipeTree, error) {
+	var shelves []models.Inventory
+	err := tx.Select(&shelves, `SELECT id, name, quantity FROM inventory`)
+	if err != nil {
+		return nil, err
+	}
+	var recipes []models.PrepIngredient
+	err = tx.Select(&recipes, `SELECT prep_id, inventory_id, quantity FROM prep_ingredients`)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &recipeTree{
+		shelves: make(map[uint64]models.Inventory, len(shelves)),
+		recipes: make(map[uint64][]models.PrepIngredient),
+	}
+	for _, shelf := range shelves {
+		tree.shelves[shelf.ID] = shelf
+	}
+	for _, part := range recipes {
+		tree.recipes[part.PrepID] = append(tree.recipes[part.PrepID], part)
+	}
+	return tree, nil
+}
+
+func (tree *recipeTree) Close() {
+	if tree.stock != nil {
+		tree.stock.Close()
+		tree.recipe.Close()
+	}
+}
+
+func (tree *recipeTree) shelf(id uint64) (inv models.Inventory, err error) {
+	if tree.stock == nil {
+		if inv, ok := tree.shelves[id]; ok {
+			return inv, nil
+		}
+		return inv, sql.ErrNoRows
+	}
+	return inv, tree.stock.Get(&inv, id)
+}
+
+func (tree *recipeTree) recipeOf(id uint64) (recipe []models.PrepIngredient, err error) {
+	if tree.stock == nil {
+		return tree.recipes[id], nil
+	}
+	return recipe, tree.recipe.Select(&recipe, id)
+}
+
+// take plans taking demands (InventoryID and QuantityUsed) from the shelves. What is missing
+// of a prep item is made on the spot from its recipe, down the whole tree. It returns what
+// each inventory row gives (Remaining is what stays on it) and what is missing at the leaves.
+// Nothing is written, the caller takes the stock
+func (tree *recipeTree) take(demands []models.InventoryUpdate) ([]models.InventoryUpdate, []models.NotEnoughIng, error) {
+	var shelves []models.Inventory // in the order of the first touch
+	index := map[uint64]int{}
+	taken, missing := map[uint64]float64{}, map[uint64]float64{}
+
+	var take func(id uint64, quantity float64, depth int) error
+	take = func(id uint64, quantity float64, depth int) error {
+		i, ok := index[id]
+		if !ok {
+			inv, err := tree.shelf(id)
+			if err != nil {
+				return err
+			}
+			i = len(shelves)
+			index[id] = i
+			shelves = append(shelves, inv)
+		}
+		if give := min(quantity, shelves[i].Quantity-taken[id]); give > 0 {
+			taken[id] += give
+			quantity -= give
+		}
+		if quantity <= 0 {
+			return nil
+		}
+
+		var recipe []models.PrepIngredient
+		if depth < maxRecipeDepth {
+			var err error
+			if recipe, err = tree.recipeOf(id); err != nil {
+				return err
+			}
+		}
+		if len(recipe) == 0 {
+			missing[id] += quantity
+			return nil
+		}
+		for _, part := range recipe {
+			if err := take(part.InventoryID, part.Quantity*quantity, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, demand := range demands {
+		if err := take(demand.InventoryID, demand.QuantityUsed, 0); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var used []models.InventoryUpdate
+	var notEnough []models.NotEnoughIng
+	for _, shelf := range shelves {
+		if taken[shelf.ID] > 0 {
+			used = append(used, models.InventoryUpdate{
+				InventoryID:  shelf.ID,
+				Name:         shelf.Name,
+				QuantityUsed: taken[shelf.ID],
+				Remaining:    shelf.Quantity - taken[shelf.ID],
+			})
+		}
+		if missing[shelf.ID] > 0 {
+			notEnough = append(notEnough, models.NotEnoughIng{
+				Inventory_id:   shelf.ID,
+				Inventory_name: shelf.Name,
+				NotEnough:      missing[shelf.ID],
+			})
+		}
+	}
+	return used, notEnough, nil
+}
+
+// maxAvailable caps the search of available, a recipe of tiny quantities would go on forever
+const maxAvailable uint64 = 1 << 30
+
+// available is how many times demands can be taken from the shelves, prep items made on the
+// spot as take does. limit is the first ingredient missing for one more
+func (tree *recipeTree) available(demands []models.InventoryUpdate) (uint64, *models.NotEnoughIng, error) {
+	// fits: n times demands have nothing missing (a float residue is not missing)
+	fits := func(n uint64) (bool, *models.NotEnoughIng, error) {
+		times := make([]models.InventoryUpdate, len(demands))
+		for i, demand := range demands {
+			times[i] = models.InventoryUpdate{InventoryID: demand.InventoryID, QuantityUsed: demand.QuantityUsed * float64(n)}
+		}
+		_, notEnough, err := tree.take(times)
+		if err != nil {
+			return false, nil, err
+		}
+		for i := range notEnough {
+			if notEnough[i].NotEnough > 1e-9 {
+				return false, &notEnough[i], nil
+			}
+		}
+		return true, nil, nil
+	}
+
+	// lo fits, hi does not: doubling, then halving between them
+	lo, hi := uint64(0), uint64(1)
+	for ; hi <= maxAvailable; lo, hi = hi, hi*2 {
+		ok, _, err := fits(hi)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !ok {
+			break
+		}
+	}
+	if hi > maxAvailable {
+		return lo, nil, nil
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, _, err := fits(mid)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	_, limit, err := fits(lo + 1)
+	return lo, limit, err
+}
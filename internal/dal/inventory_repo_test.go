@@ -0,0 +1,99 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"frappuccino/models"
+)
+
+// testTree is a recipeTree as loadRecipeTree makes it, without a database
+func testTree(shelves []models.Inventory, recipes []models.PrepIngredient) *recipeTree {
+	tree := &recipeTree{
+		shelves: make(map[uint64]models.Inventory, len(shelves)),
+		recipes: make(map[uint64][]models.PrepIngredient),
+	}
+	for _, shelf := range shelves {
+		tree.shelves[shelf.ID] = shelf
+	}
+	for _, part := range recipes {
+		tree.recipes[part.PrepID] = append(tree.recipes[part.PrepID], part)
+	}
+	return tree
+}
+
+func TestRecipeTreeAvailable(t *testing.T) {
+	const (
+		coffee = iota + 1
+		milk
+		sugar
+		water
+		syrup // sugar and water
+		cream // milk and syrup
+	)
+	tree := testTree(
+		[]models.Inventory{
+			{ID: coffee, Name: "Coffee", Quantity: 100},
+			{ID: milk, Name: "Milk", Quantity: 1000},
+			{ID: sugar, Name: "Sugar", Quantity: 100},
+			{ID: water, Name: "Water", Quantity: 1000},
+			{ID: syrup, Name: "Syrup", Quantity: 10},
+			{ID: cream, Name: "Cream", Quantity: 0},
+		},
+		[]models.PrepIngredient{
+			{PrepID: syrup, InventoryID: sugar, Quantity: 0.5},
+			{PrepID: syrup, InventoryID: water, Quantity: 0.5},
+			{PrepID: cream, InventoryID: milk, Quantity: 0.8},
+			{PrepID: cream, InventoryID: syrup, Quantity: 0.2},
+		},
+	)
+	need := func(pairs ...float64) []models.InventoryUpdate {
+		var demands []models.InventoryUpdate
+		for i := 0; i < len(pairs); i += 2 {
+			demands = append(demands, models.InventoryUpdate{InventoryID: uint64(pairs[i]), QuantityUsed: pairs[i+1]})
+		}
+		return demands
+	}
+
+	tests := []struct {
+		name    string
+		demands []models.InventoryUpdate
+		want    uint64
+		limit   uint64 // 0: no limit
+	}{
+		{"one ingredient", need(coffee, 18), 5, coffee},
+		{"exact fit", need(coffee, 20), 5, coffee},
+		{"scarcest of two", need(coffee, 10, milk, 200), 5, milk},
+		{"same ingredient twice", need(coffee, 10, coffee, 15), 4, coffee},
+		{"prep made on the spot", need(syrup, 20), 10, sugar},
+		{"two levels down", need(cream, 100), 10, sugar},
+		{"nothing on the shelf", need(coffee, 101), 0, coffee},
+		{"nothing needed", need(coffee, 0), maxAvailable, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, limit, err := tree.available(tt.demands)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("available = %d, want %d", got, tt.want)
+			}
+			switch {
+			case tt.limit == 0 && limit != nil:
+				t.Errorf("limited by %d, want no limit", limit.Inventory_id)
+			case tt.limit != 0 && limit == nil:
+				t.Errorf("no limit, want %d", tt.limit)
+			case tt.limit != 0 && limit.Inventory_id != tt.limit:
+				t.Errorf("limited by %d, want %d", limit.Inventory_id, tt.limit)
+			}
+		})
+	}
+
+	t.Run("unknown ingredient", func(t *testing.T) {
+		if _, _, err := tree.available(need(42, 1)); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("err = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
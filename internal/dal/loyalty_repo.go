@@ -0,0 +1,324 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type dalLoyalty struct {
+	db *sqlx.DB
+}
+
+type LoyaltyDalInter interface {
+	SelectLoyaltySettings() (*models.LoyaltySettings, error)
+	UpdatePointValue(float64) error
+	InsertLoyaltyRule(*models.LoyaltyRule) error
+	UpdateLoyaltyRule(*models.LoyaltyRule) error
+	DeleteLoyaltyRule(uint64) error
+	SelectLoyaltyAccount(customerID uint64) (*models.LoyaltyAccount, error)
+	InsertLoyaltyEntry(*models.LoyaltyEntry) error
+}
+
+func ReturnDalLoyalty(db *sqlx.DB) LoyaltyDalInter {
+	return &dalLoyalty{db: db}
+}
+
+// SelectLoyaltySettings: the point value and all the rules, the turned off ones too
+func (core *dalLoyalty) SelectLoyaltySettings() (*models.LoyaltySettings, error) {
+	var settings models.LoyaltySettings
+	err := core.db.Get(&settings, `SELECT point_value FROM loyalty_settings`)
+	if err != nil {
+		return nil, err
+	}
+	settings.Rules = []models.LoyaltyRule{}
+	err = core.db.Select(&settings.Rules, `
+	SELECT id, name, kind, points, per_amount, tags, active
+		FROM loyalty_rules
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdatePointValue is for the next redemptions, old orders keep their points_off
+func (core *dalLoyalty) UpdatePointValue(value float64) error {
+	_, err := core.db.Exec(`UPDATE loyalty_settings SET point_value = $1`, value)
+	return err
+}
+
+func (core *dalLoyalty) InsertLoyaltyRule(rule *models.LoyaltyRule) error {
+	err := core.db.QueryRow(`
+	INSERT INTO loyalty_rules (name, kind, points, per_amount, tags, active)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`, rule.Name, rule.Kind, rule.Points, rule.PerAmount, rule.Tags, *rule.Active).Scan(&rule.ID)
+	return core.pqErr(err)
+}
+
+// UpdateLoyaltyRule: points already earned stay as they are
+func (core *dalLoyalty) UpdateLoyaltyRule(rule *models.LoyaltyRule) error {
+	result, err := core.db.Exec(`
+	UPDATE loyalty_rules
+		SET name = $2, kind = $3, points = $4, per_amount = $5, tags = $6, active = $7
+		WHERE id = $1`, rule.ID, rule.Name, rule.Kind, rule.Points, rule.PerAmount, rule.Tags, *rule.Active)
+	if err != nil {
+		return core.pqErr(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (core *dalLoyalty) DeleteLoyaltyRule(id uint64) error {
+	result, err := core.db.Exec(`DELETE FROM loyalty_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// SelectLoyaltyAccount: the balance with the ledger, the newest entries first
+func (core *dalLoyalty) SelectLoyaltyAccount(customerID uint64) (*models.LoyaltyAccount, error) {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	if err != nil {
+		return nil, err
+	}
+
+	account := models.LoyaltyAccount{CustomerID: customerID, Entries: []models.LoyaltyEntry{}}
+	err = tx.Get(&account, `
+	SELECT c.id AS customer_id, c.points AS balance, c.points * s.point_value AS value
+		FROM customers AS c
+		CROSS JOIN loyalty_settings AS s
+		WHERE c.id = $1`, customerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	err = tx.Select(&account.Entries, `
+	SELECT id, customer_id, order_id, kind, points, note, created_at
+		FROM loyalty_ledger
+		WHERE customer_id = $1
+		ORDER BY id DESC`, customerID)
+	if err != nil {
+		return nil, err
+	}
+	return &account, tx.Commit()
+}
+
+// InsertLoyaltyEntry adds a manual entry, it can not take the balance below zero
+func (core *dalLoyalty) InsertLoyaltyEntry(entry *models.LoyaltyEntry) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if ok, err := addPoints(tx, entry, true); err != nil {
+		return err
+	} else if !ok {
+		balance, err := pointsBalance(tx, entry.CustomerID)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w : the balance is %d points", models.ErrLoyalty, balance)
+	}
+	return tx.Commit()
+}
+
+// pqErr: the same rule name is a conflict, a rule without per_amount or tags is bad input
+func (core *dalLoyalty) pqErr(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23505": // unique
+			return models.ErrConflict
+		case "23514": // check
+			return fmt.Errorf("%w : %s", models.ErrBadInput, pqErr.Message)
+		}
+	}
+	return err
+}
+
+// addPoints writes the entry and moves customers.points with it in 1 statement. With noDebt
+// the entry is written only if the balance stays not below zero: the UPDATE waits for the
+// entries written at the same time and checks the committed balance, so 2 orders at once
+// can not spend the same points (in a REPEATABLE READ order the later one fails with 40001
+// and is run again). false: not written, the balance is short or there is no such customer
+func addPoints(tx *sqlx.Tx, entry *models.LoyaltyEntry, noDebt bool) (bool, error) {
+	err := tx.QueryRow(`
+	WITH balance AS (
+		UPDATE customers SET points = points + $3
+			WHERE id = $1 AND (NOT $5 OR points + $3 >= 0)
+			RETURNING id
+	)
+	INSERT INTO loyalty_ledger (customer_id, order_id, kind, points, note)
+		SELECT id, $2, $4, $3, $6 FROM balance
+	RETURNING id, created_at`, entry.CustomerID, entry.OrderID, entry.Kind, entry.Points, noDebt, entry.Note).
+		Scan(&entry.ID, &entry.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// pointsBalance is for the message of a spending that was not written
+func pointsBalance(tx *sqlx.Tx, customerID uint64) (int64, error) {
+	var balance int64
+	err := tx.Get(&balance, `SELECT points FROM customers WHERE id = $1`, customerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, models.ErrNotFound
+	}
+	return balance, err
+}
+
+// applyPoints spends ord.RedeemPoints of its customer on what is left to pay after the promo code.
+// The value is shared by the lines like the promo discount and added to their discount
+func (db *dalOrder) applyPoints(tx *sqlx.Tx, ord *models.Order, left float64) (float64, error) {
+	if ord.RedeemPoints == 0 {
+		return 0, nil
+	}
+	if ord.CustomerID == nil {
+		return 0, fmt.Errorf("%w : redeem_points needs customer_id", models.ErrLoyalty)
+	}
+	var pointValue float64
+	if err := tx.Get(&pointValue, `SELECT point_value FROM loyalty_settings`); err != nil {
+		return 0, err
+	}
+	value := math.Round(float64(ord.RedeemPoints)*pointValue*100) / 100
+	if value > math.Round(left*100)/100 {
+		return 0, fmt.Errorf("%w : %d points are %.2f, only %.2f is left to pay", models.ErrLoyalty, ord.RedeemPoints, value, left)
+	}
+
+	var lines []struct {
+		ID   uint64  `db:"id"`
+		Left float64 `db:"left_to_pay"`
+	}
+	err := tx.Select(&lines, `
+	SELECT id, line_total - discount AS left_to_pay
+		FROM order_items
+		WHERE order_id = $1 AND line_total - discount > 0
+		ORDER BY id`, ord.ID)
+	if err != nil {
+		return 0, err
+	}
+	// value is not more than the lines have left, so no line pays less than 0
+	rests := make([]float64, len(lines))
+	var sum float64
+	for i, line := range lines {
+		rests[i] = line.Left
+		sum += line.Left
+	}
+	if value > math.Round(sum*100)/100 {
+		return 0, fmt.Errorf("%w : %d points are %.2f, only %.2f is left to pay", models.ErrLoyalty, ord.RedeemPoints, value, sum)
+	}
+
+	redeem := models.LoyaltyEntry{CustomerID: *ord.CustomerID, OrderID: &ord.ID, Kind: "redeem", Points: -int64(ord.RedeemPoints)}
+	if ok, err := addPoints(tx, &redeem, true); err != nil {
+		return 0, err
+	} else if !ok {
+		balance, err := pointsBalance(tx, *ord.CustomerID)
+		if err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w : %d points, the balance is %d", models.ErrLoyalty, ord.RedeemPoints, balance)
+	}
+	for i, share := range shareCents(value, rests) {
+		if share == 0 {
+			continue
+		}
+		if _, err = tx.Exec(`UPDATE order_items SET discount = discount + $1 WHERE id = $2`, share, lines[i].ID); err != nil {
+			return 0, err
+		}
+	}
+	return value, nil
+}
+
+// earnPoints gives the customer of the closed order the points of every active rule:
+// amount rules by its total, item rules by the units with their tags
+func (db *dalOrder) earnPoints(tx *sqlx.Tx, orderID uint64) error {
+	_, err := tx.Exec(`
+	WITH earned AS (
+		INSERT INTO loyalty_ledger (customer_id, order_id, kind, points)
+		SELECT o.customer_id, o.id, 'earn', e.points
+			FROM orders AS o
+			CROSS JOIN LATERAL (
+				SELECT COALESCE(SUM(CASE
+					WHEN r.kind = 'amount' THEN FLOOR(o.total / r.per_amount) * r.points
+					ELSE r.points * (
+						SELECT COALESCE(SUM(oi.quantity), 0)
+							FROM order_items AS oi
+							JOIN menu_items AS m ON m.id = oi.product_id
+							WHERE oi.order_id = o.id AND m.tags && r.tags)
+					END), 0)::INT AS points
+				FROM loyalty_rules AS r
+				WHERE r.active
+			) AS e
+			WHERE o.id = $1 AND o.customer_id IS NOT NULL AND e.points > 0
+		RETURNING customer_id, points
+	)
+	UPDATE customers AS c SET points = c.points + earned.points
+		FROM earned
+		WHERE c.id = earned.customer_id`, orderID)
+	return err
+}
+
+// reversePoints takes back the part of what the order earned and spent. With part 1 everything
+// that is left, so the entries of the order sum up to zero. The balance can go below zero
+// when the earned points are already spent
+func (db *dalOrder) reversePoints(tx *sqlx.Tx, orderID uint64, part float64, note string) error {
+	var rows []struct {
+		CustomerID uint64 `db:"customer_id"`
+		Base       int64  `db:"base"` // earned - redeemed now
+		Left       int64  `db:"left_points"`
+	}
+	err := tx.Select(&rows, `
+	SELECT l.customer_id,
+			COALESCE(SUM(l.points) FILTER (WHERE l.kind = 'earn'), 0) -
+				CASE WHEN l.customer_id = o.customer_id THEN o.redeem_points ELSE 0 END AS base,
+			SUM(l.points) AS left_points
+		FROM loyalty_ledger AS l
+		JOIN orders AS o ON o.id = l.order_id
+		WHERE l.order_id = $1
+		GROUP BY l.customer_id, o.customer_id, o.redeem_points`, orderID)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		back := -row.Left
+		if part < 1 {
+			back = -int64(math.Round(float64(row.Base) * part))
+			if back*row.Left > 0 { // would not take back, but add
+				back = 0
+			} else if math.Abs(float64(back)) > math.Abs(float64(row.Left)) {
+				back = -row.Left
+			}
+		}
+		if back == 0 {
+			continue
+		}
+		entry := models.LoyaltyEntry{CustomerID: row.CustomerID, OrderID: &orderID, Kind: "reverse", Points: back, Note: note}
+		if _, err = addPoints(tx, &entry, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
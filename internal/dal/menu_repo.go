@@ -2,6 +2,9 @@ package dal
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
 
 	"frappuccino/models"
 
@@ -15,11 +18,19 @@ type dalMenu struct {
 
 type MenuDalInter interface {
 	SelectAllMenus() ([]models.MenuItem, error)
+	SelectMenuSections() ([]models.Category, []models.MenuItem, error)
 	SelectMenu(uint64) (*models.MenuItem, error)
 	DeleteMenu(uint64) (*models.MenuDepend, error)
 	InsertMenu(*models.MenuItem) error
 	UpdateMenu(*models.MenuItem) error
 	SelectPriceHistory() ([]models.PriceHistory, error)
+	SelectMenuCost(uint64) (*models.MenuCost, error)
+	UpdateMenuAvailable(id uint64, available bool) error
+	UpdateMenuSchedules(id uint64, schedules []models.MenuSchedule) error
+	InsertScheduledPrice(*models.ScheduledPrice) error
+	SelectScheduledPrices(productID uint64) ([]models.ScheduledPrice, error)
+	DeleteScheduledPrice(productID, id uint64) error
+	ApplyScheduledPrices() (uint64, error)
 }
 
 func ReturnDalMenuCore(db *sqlx.DB) MenuDalInter {
@@ -27,16 +38,49 @@ func ReturnDalMenuCore(db *sqlx.DB) MenuDalInter {
 }
 
 func (core *dalMenu) SelectAllMenus() ([]models.MenuItem, error) {
-	var menus []models.MenuItem
 	tx, err := core.db.Beginx()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
+	menus, err := core.selectAllMenusTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return menus, tx.Commit()
+}
+
+// SelectMenuSections reads categories and menu items in one snapshot,
+// so every category_id of an item is in the categories
+func (core *dalMenu) SelectMenuSections() ([]models.Category, []models.MenuItem, error) {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	categories := []models.Category{}
+	err = tx.Select(&categories, `SELECT * FROM categories ORDER BY position, id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	menus, err := core.selectAllMenusTx(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return categories, menus, tx.Commit()
+}
+
+func (core *dalMenu) selectAllMenusTx(tx *sqlx.Tx) ([]models.MenuItem, error) {
+	var menus []models.MenuItem
 	// var resArray pgtype.Array[string]
 	// s := resArray.Elements
 
-	err = tx.Select(&menus, `SELECT * FROM menu_items`)
+	err := tx.Select(&menus, `SELECT * FROM menu_items ORDER BY id`)
 	if err != nil {
 		return nil, err
 	}
@@ -47,6 +91,11 @@ func (core *dalMenu) SelectAllMenus() ([]models.MenuItem, error) {
 	}
 	defer stmt.Close()
 
+	tree, err := loadRecipeTree(tx)
+	if err != nil {
+		return nil, err
+	}
+
 	for i, menu := range menus {
 		// menus[i] деп структураның өзін бере салдым, (ө)үйткені ол тек 1 ғана аргумент қабылдайды екен
 		// menus[i].ID деп query ға $1 қоя салуға келмеді
@@ -54,8 +103,32 @@ func (core *dalMenu) SelectAllMenus() ([]models.MenuItem, error) {
 		if err != nil {
 			return nil, err
 		}
+		err = core.selectComponents(tx, &menus[i])
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Select(&menus[i].Variants, `SELECT * FROM menu_item_variants WHERE product_id=$1 ORDER BY id`, menu.ID)
+		if err != nil {
+			return nil, err
+		}
+		err = core.selectModifiers(tx, &menus[i])
+		if err != nil {
+			return nil, err
+		}
+		err = core.selectSchedules(tx, &menus[i])
+		if err != nil {
+			return nil, err
+		}
+		err = core.selectMargin(tx, &menus[i])
+		if err != nil {
+			return nil, err
+		}
+		err = core.selectAvailability(tx, tree, &menus[i])
+		if err != nil {
+			return nil, err
+		}
 	}
-	return menus, tx.Commit()
+	return menus, nil
 }
 
 func (core *dalMenu) SelectMenu(id uint64) (*models.MenuItem, error) {
@@ -80,6 +153,34 @@ func (core *dalMenu) SelectMenu(id uint64) (*models.MenuItem, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = core.selectComponents(tx, &menu)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Select(&menu.Variants, `SELECT * FROM menu_item_variants WHERE product_id=$1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	err = core.selectModifiers(tx, &menu)
+	if err != nil {
+		return nil, err
+	}
+	err = core.selectSchedules(tx, &menu)
+	if err != nil {
+		return nil, err
+	}
+	err = core.selectMargin(tx, &menu)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadRecipeTree(tx)
+	if err != nil {
+		return nil, err
+	}
+	err = core.selectAvailability(tx, tree, &menu)
+	if err != nil {
+		return nil, err
+	}
 	return &menu, tx.Commit()
 }
 
@@ -91,10 +192,10 @@ func (core *dalMenu) DeleteMenu(id uint64) (*models.MenuDepend, error) {
 	defer tx.Rollback()
 
 	const query string = `
-	SELECT id, customer_name 
-		FROM order_items 
-		JOIN orders ON order_id=id 
-		WHERE status = 'processing' AND product_id=$1`
+	SELECT DISTINCT o.id, o.customer_name 
+		FROM order_items AS oi
+		JOIN orders AS o ON oi.order_id = o.id 
+		WHERE o.status = 'processing' AND oi.product_id=$1`
 
 	var menuDepend models.MenuDepend
 	err = tx.Select(&menuDepend.Orders, query, id)
@@ -134,10 +235,22 @@ func (core *dalMenu) InsertMenu(menuItems *models.MenuItem) error {
 	if err != nil {
 		return err
 	}
+	err = core.checkModifierIngs(tx, menuItems)
+	if err != nil {
+		return err
+	}
+	err = core.checkComponents(tx, menuItems)
+	if err != nil {
+		return err
+	}
+	err = core.checkCategory(tx, menuItems.CategoryID)
+	if err != nil {
+		return err
+	}
 
 	const insertMenuQ string = `
-		INSERT INTO menu_items (name, description, tags, allergens, price)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO menu_items (name, description, tags, allergens, price, kind, category_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING id`
 
 	err = tx.QueryRow(insertMenuQ,
@@ -145,7 +258,9 @@ func (core *dalMenu) InsertMenu(menuItems *models.MenuItem) error {
 		menuItems.Description,
 		menuItems.Tags,
 		menuItems.Allergens,
-		menuItems.Price).Scan(&menuItems.ID)
+		menuItems.Price,
+		menuItems.Kind,
+		menuItems.CategoryID).Scan(&menuItems.ID)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
 			if pqErr.Code == "23505" { // unique
@@ -159,6 +274,20 @@ func (core *dalMenu) InsertMenu(menuItems *models.MenuItem) error {
 	if err != nil {
 		return err
 	}
+	err = core.insertComponents(tx, menuItems.ID, menuItems.Components)
+	if err != nil {
+		return err
+	}
+	for i := range menuItems.Variants {
+		err = core.insertVariant(tx, menuItems.ID, &menuItems.Variants[i])
+		if err != nil {
+			return err
+		}
+	}
+	err = core.insertModifiers(tx, menuItems.ID, menuItems.Modifiers)
+	if err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -173,11 +302,24 @@ func (core *dalMenu) UpdateMenu(menuItems *models.MenuItem) error {
 	if err != nil {
 		return err
 	}
+	err = core.checkModifierIngs(tx, menuItems)
+	if err != nil {
+		return err
+	}
+	err = core.checkComponents(tx, menuItems)
+	if err != nil {
+		return err
+	}
+	err = core.checkCategory(tx, menuItems.CategoryID)
+	if err != nil {
+		return err
+	}
 
 	const updateMenuQ string = `
 	UPDATE menu_items 
 		SET name=:name, description = :description, 
-			tags = :tags, allergens = :allergens, price = :price
+			tags = :tags, allergens = :allergens, price = :price, kind = :kind,
+			category_id = :category_id
 		WHERE id = :id`
 
 	result, err := tx.NamedExec(updateMenuQ, menuItems)
@@ -205,6 +347,23 @@ func (core *dalMenu) UpdateMenu(menuItems *models.MenuItem) error {
 	if err != nil {
 		return err
 	}
+
+	err = core.updateComponents(tx, menuItems.ID, menuItems.Components)
+	if err != nil {
+		return err
+	}
+
+	err = core.updateVariants(tx, menuItems.ID, menuItems.Variants)
+	if err != nil {
+		return err
+	}
+
+	// модификаторы сохраняют свои id, как и размеры.
+	// старые заказы не страдают: у них своя копия в order_item_modifiers
+	err = core.updateModifiers(tx, menuItems.ID, menuItems.Modifiers)
+	if err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -217,6 +376,351 @@ func (core *dalMenu) SelectPriceHistory() ([]models.PriceHistory, error) {
 	return history, nil
 }
 
+// windowColumns are the columns of models.TimeWindow in the form they are given
+const windowColumns string = `weekdays,
+		to_char(start_time, 'HH24:MI') AS start_time, to_char(end_time, 'HH24:MI') AS end_time,
+		to_char(start_date, 'YYYY-MM-DD') AS start_date, to_char(end_date, 'YYYY-MM-DD') AS end_date`
+
+// marginColumns are cost, margin and percents of price against cost (sql expressions)
+func marginColumns(price, cost string) string {
+	return `ROUND((` + cost + `)::numeric, 2) AS cost,
+		ROUND((` + price + ` - (` + cost + `))::numeric, 2) AS margin,
+		ROUND(((` + price + ` - (` + cost + `)) / NULLIF(` + price + `, 0) * 100)::numeric, 2) AS margin_percent,
+		ROUND(((` + cost + `) / NULLIF(` + price + `, 0) * 100)::numeric, 2) AS food_cost_percent`
+}
+
+// SelectMenuCost prices the recipe of a menu item by inventory (see menu_item_costs view)
+func (core *dalMenu) SelectMenuCost(id uint64) (*models.MenuCost, error) {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var cost models.MenuCost
+	err = tx.Get(&cost, `
+	SELECT m.id AS product_id, m.name, m.price, `+marginColumns("m.price", "c.cost")+`
+		FROM menu_items AS m
+		JOIN menu_item_costs AS c ON c.product_id = m.id
+		WHERE m.id = $1`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrNotFound
+		}
+		return nil, err
+	}
+
+	err = tx.Select(&cost.Ingredients, `
+	SELECT i.id AS inventory_id, i.name, i.unit, mi.quantity,
+			ROUND(c.unit_cost::numeric, 4) AS unit_cost,
+			ROUND((mi.quantity * c.unit_cost)::numeric, 2) AS cost
+		FROM menu_item_ingredients AS mi
+		JOIN inventory AS i ON i.id = mi.inventory_id
+		JOIN inventory_unit_costs AS c ON c.id = mi.inventory_id
+		WHERE mi.product_id = $1
+		ORDER BY i.id`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Select(&cost.Components, `
+	SELECT bc.id AS component_id, bc.name, bc.quantity,
+			ROUND((bc.quantity * COALESCE(MAX(c.cost), 0))::numeric, 2) AS cost
+		FROM bundle_components AS bc
+		LEFT JOIN bundle_component_choices AS ch ON ch.component_id = bc.id
+		LEFT JOIN menu_item_costs AS c ON c.product_id = ch.product_id
+		WHERE bc.bundle_id = $1
+		GROUP BY bc.id
+		ORDER BY bc.id`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Select(&cost.Variants, `
+	SELECT v.product_id, v.id AS variant_id, v.name, v.price, `+marginColumns("v.price", "c.cost * v.scale")+`
+		FROM menu_item_variants AS v
+		JOIN menu_item_costs AS c ON c.product_id = v.product_id
+		WHERE v.product_id = $1
+		ORDER BY v.id`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &cost, tx.Commit()
+}
+
+func (core *dalMenu) selectMargin(tx *sqlx.Tx, menu *models.MenuItem) error {
+	menu.Margin = new(models.MenuMargin)
+	return tx.Get(menu.Margin, `
+	SELECT `+marginColumns("m.price", "c.cost")+`
+		FROM menu_items AS m
+		JOIN menu_item_costs AS c ON c.product_id = m.id
+		WHERE m.id = $1`, menu.ID)
+}
+
+// selectAvailability: how many of the menu item can be made by its recipe. A bundle takes
+// one choice of every component at once, so an ingredient in two components counts for both.
+// It is limited by its best combination of the choices on sale now (not 86'd, on schedule),
+// a component without such a choice sells the bundle out
+func (core *dalMenu) selectAvailability(tx *sqlx.Tx, tree *recipeTree, menu *models.MenuItem) error {
+	recipeOf := func(productID uint64, times uint64) ([]models.InventoryUpdate, error) {
+		var demands []models.InventoryUpdate
+		err := tx.Select(&demands, `
+		SELECT inventory_id AS id, quantity * $2 AS quantity_used
+			FROM menu_item_ingredients
+			WHERE product_id = $1`, productID, times)
+		return demands, err
+	}
+
+	stock := &models.MenuAvailability{}
+	if menu.Kind != "bundle" {
+		demands, err := recipeOf(menu.ID, 1)
+		if err != nil {
+			return err
+		}
+		if len(demands) != 0 {
+			n, limit, err := tree.available(demands)
+			if err != nil {
+				return err
+			}
+			stock.Available = &n
+			if limit != nil {
+				stock.LimitingID, stock.LimitingName = limit.Inventory_id, limit.Inventory_name
+			}
+		}
+	} else {
+		// recipes of the choices on sale, by component
+		options := make([][][]models.InventoryUpdate, len(menu.Components))
+		soldOut := false
+		for i, component := range menu.Components {
+			for _, choice := range component.Choices {
+				var onSale bool
+				err := tx.Get(&onSale, `
+				SELECT available AND menu_item_on_schedule(id, store_time(NOW()))
+					FROM menu_items
+					WHERE id = $1`, choice)
+				if err != nil {
+					return err
+				}
+				if !onSale {
+					continue
+				}
+				demands, err := recipeOf(uint64(choice), component.Quantity)
+				if err != nil {
+					return err
+				}
+				options[i] = append(options[i], demands)
+			}
+			soldOut = soldOut || len(options[i]) == 0
+		}
+		if soldOut {
+			stock.Available = new(uint64)
+		} else {
+			n, limit, err := bestCombination(tree, options)
+			if err != nil {
+				return err
+			}
+			stock.Available = n
+			if limit != nil {
+				stock.LimitingID, stock.LimitingName = limit.Inventory_id, limit.Inventory_name
+			}
+		}
+	}
+
+	stock.SoldOut = stock.Available != nil && *stock.Available == 0
+	err := tx.Get(&stock.OnSchedule, `SELECT menu_item_on_schedule($1, store_time(NOW()))`, menu.ID)
+	if err != nil {
+		return err
+	}
+	menu.Availability = stock
+	return nil
+}
+
+// maxBundleCombinations bounds the walk over the choices of a bundle, the best one found
+// by then is taken
+const maxBundleCombinations = 1024
+
+// bestCombination: how many bundles can be made of one option of every component, taken
+// together, with the best options. A nil count is no limit: some combination has no recipe.
+// limit is the ingredient missing for one more of the best combination
+func bestCombination(tree *recipeTree, options [][][]models.InventoryUpdate) (*uint64, *models.NotEnoughIng, error) {
+	var best *uint64
+	var bestLimit *models.NotEnoughIng
+	tried := 0
+
+	// walk returns true when there is nothing to look for anymore
+	var walk func(i int, demands []models.InventoryUpdate) (bool, error)
+	walk = func(i int, demands []models.InventoryUpdate) (bool, error) {
+		if i == len(options) {
+			tried++
+			if len(demands) == 0 {
+				best, bestLimit = nil, nil
+				return true, nil
+			}
+			n, limit, err := tree.available(demands)
+			if err != nil {
+				return true, err
+			}
+			if best == nil || n > *best {
+				best, bestLimit = &n, limit
+			}
+			return tried >= maxBundleCombinations, nil
+		}
+		for _, option := range options[i] {
+			// the full slice expression keeps the siblings from sharing the tail
+			done, err := walk(i+1, append(demands[:len(demands):len(demands)], option...))
+			if done || err != nil {
+				return done, err
+			}
+		}
+		return false, nil
+	}
+	if _, err := walk(0, nil); err != nil {
+		return nil, nil, err
+	}
+	return best, bestLimit, nil
+}
+
+// UpdateMenuAvailable takes the item off the menu (86) or puts it back
+func (core *dalMenu) UpdateMenuAvailable(id uint64, available bool) error {
+	res, err := core.db.Exec(`UPDATE menu_items SET available = $1 WHERE id = $2`, available, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// UpdateMenuSchedules replaces the windows when the item is sold, empty is always
+func (core *dalMenu) UpdateMenuSchedules(id uint64, schedules []models.MenuSchedule) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.Get(&exists, `SELECT TRUE FROM menu_items WHERE id = $1 FOR UPDATE`, id); err == sql.ErrNoRows {
+		return models.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM menu_item_schedules WHERE product_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	for i, s := range schedules {
+		err = tx.Get(&schedules[i].ID, `
+		INSERT INTO menu_item_schedules (product_id, weekdays, start_time, end_time, start_date, end_date)
+		VALUES ($1, $2, $3::time, $4::time, $5::date, $6::date)
+		RETURNING id`, id, s.Weekdays, s.StartTime, s.EndTime, s.StartDate, s.EndDate)
+		if err != nil {
+			return err
+		}
+		schedules[i].ProductID = id
+	}
+	return tx.Commit()
+}
+
+// InsertScheduledPrice plans a price of the item or of its variant
+func (core *dalMenu) InsertScheduledPrice(change *models.ScheduledPrice) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.Get(&exists, `SELECT TRUE FROM menu_items WHERE id = $1`, change.ProductID); err == sql.ErrNoRows {
+		return fmt.Errorf("%w : menu item %d", models.ErrNotFound, change.ProductID)
+	} else if err != nil {
+		return err
+	}
+	if change.VariantID != nil {
+		err = tx.Get(&exists, `SELECT TRUE FROM menu_item_variants WHERE id = $1 AND product_id = $2`, *change.VariantID, change.ProductID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w : variant %d of menu item %d", models.ErrNotFound, *change.VariantID, change.ProductID)
+		} else if err != nil {
+			return err
+		}
+	}
+	err = tx.Get(change, `
+	INSERT INTO scheduled_prices (product_id, variant_id, new_price, effective_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING *`, change.ProductID, change.VariantID, change.NewPrice, change.EffectiveAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (core *dalMenu) SelectScheduledPrices(productID uint64) ([]models.ScheduledPrice, error) {
+	changes := []models.ScheduledPrice{}
+	err := core.db.Select(&changes, `
+	SELECT * FROM scheduled_prices
+		WHERE product_id = $1
+		ORDER BY effective_at, id`, productID)
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// DeleteScheduledPrice cancels a change that is still waiting, an applied one is history
+func (core *dalMenu) DeleteScheduledPrice(productID, id uint64) error {
+	var applied bool
+	err := core.db.Get(&applied, `
+	SELECT applied_at IS NOT NULL FROM scheduled_prices
+		WHERE id = $1 AND product_id = $2`, id, productID)
+	if err == sql.ErrNoRows {
+		return models.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if applied {
+		return models.ErrConflict
+	}
+	res, err := core.db.Exec(`DELETE FROM scheduled_prices WHERE id = $1 AND applied_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 { // applied in between
+		return models.ErrConflict
+	}
+	return nil
+}
+
+// ApplyScheduledPrices applies the due changes, it returns how many
+func (core *dalMenu) ApplyScheduledPrices() (uint64, error) {
+	var applied uint64
+	return applied, core.db.Get(&applied, `SELECT apply_scheduled_prices()`)
+}
+
+func (core *dalMenu) selectSchedules(tx *sqlx.Tx, menu *models.MenuItem) error {
+	return tx.Select(&menu.Schedules, `
+	SELECT id, product_id, `+windowColumns+`
+		FROM menu_item_schedules
+		WHERE product_id = $1
+		ORDER BY id`, menu.ID)
+}
+
+func (core *dalMenu) checkCategory(tx *sqlx.Tx, categoryID *uint64) error {
+	if categoryID == nil {
+		return nil
+	}
+	var exists bool
+	err := tx.Get(&exists, `SELECT TRUE FROM categories WHERE id = $1 FOR SHARE`, *categoryID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w : category %d", models.ErrNotFound, *categoryID)
+	}
+	return err
+}
+
 func (core *dalMenu) checkIngs(tx *sqlx.Tx, ings *[]models.MenuIngredients) error {
 	stmt, err := tx.Prepare(`SELECT TRUE FROM inventory WHERE id = $1`)
 	if err != nil {
@@ -268,3 +772,373 @@ func (core *dalMenu) insertToMenuIngs(tx *sqlx.Tx, menuID uint64, ings []models.
 	}
 	return nil
 }
+
+// checkModifierIngs checks inventory of every modifier option.
+// Not found ones are returned in menu.Ingredients, as for the recipe itself
+func (core *dalMenu) checkModifierIngs(tx *sqlx.Tx, menu *models.MenuItem) error {
+	var notFound []models.MenuIngredients
+	for _, group := range menu.Modifiers {
+		for _, opt := range group.Options {
+			ings := slices.Clone(opt.Ingredients)
+			err := core.checkIngs(tx, &ings)
+			if errors.Is(err, models.ErrNotFoundItems) {
+				for _, ing := range ings {
+					ing.Status = "not found in modifier " + opt.Name
+					notFound = append(notFound, ing)
+				}
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	if len(notFound) != 0 {
+		menu.Ingredients = notFound
+		return models.ErrNotFoundItems
+	}
+	return nil
+}
+
+func (core *dalMenu) selectModifiers(tx *sqlx.Tx, menu *models.MenuItem) error {
+	err := tx.Select(&menu.Modifiers, `
+	SELECT id, product_id, name, min_select, max_select
+		FROM modifier_groups
+		WHERE product_id = $1
+		ORDER BY id`, menu.ID)
+	if err != nil {
+		return err
+	}
+	for i, group := range menu.Modifiers {
+		err = tx.Select(&menu.Modifiers[i].Options, `
+		SELECT id, group_id, name, price_delta, add_allergens, remove_allergens
+			FROM modifier_options
+			WHERE group_id = $1
+			ORDER BY id`, group.ID)
+		if err != nil {
+			return err
+		}
+		for j, opt := range menu.Modifiers[i].Options {
+			err = tx.Select(&menu.Modifiers[i].Options[j].Ingredients, `
+			SELECT inventory_id, quantity
+				FROM modifier_option_ingredients
+				WHERE option_id = $1`, opt.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (core *dalMenu) insertModifiers(tx *sqlx.Tx, menuID uint64, groups []models.ModifierGroup) error {
+	for i := range groups {
+		group := &groups[i]
+		group.ProductID = menuID
+		err := tx.QueryRow(`
+		INSERT INTO modifier_groups (product_id, name, min_select, max_select)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, menuID, group.Name, group.MinSelect, group.MaxSelect).Scan(&group.ID)
+		if err != nil {
+			return err
+		}
+		for j := range group.Options {
+			if err = core.insertOption(tx, group.ID, &group.Options[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (core *dalMenu) insertOption(tx *sqlx.Tx, groupID uint64, opt *models.ModifierOption) error {
+	opt.GroupID = groupID
+	err := tx.QueryRow(`
+	INSERT INTO modifier_options (group_id, name, price_delta, add_allergens, remove_allergens)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id`, groupID, opt.Name, opt.PriceDelta,
+		opt.AddAllergens, opt.RemoveAllergens).Scan(&opt.ID)
+	if err != nil {
+		return err
+	}
+	return core.insertOptionIngs(tx, opt)
+}
+
+func (core *dalMenu) insertOptionIngs(tx *sqlx.Tx, opt *models.ModifierOption) error {
+	for _, ing := range opt.Ingredients {
+		_, err := tx.Exec(`
+		INSERT INTO modifier_option_ingredients (option_id, inventory_id, quantity)
+		VALUES ($1, $2, $3)`, opt.ID, ing.InventoryID, ing.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateModifiers keeps the ids of groups and options, as updateVariants does for sizes:
+// with id they are updated, without id are added, the missing ones are deleted.
+// The recipe deltas of an option are replaced as a whole, like the recipe of the item
+func (core *dalMenu) updateModifiers(tx *sqlx.Tx, menuID uint64, groups []models.ModifierGroup) error {
+	keep := pq.Int64Array{} // not nil: NULL would keep everything
+	for _, group := range groups {
+		if group.ID != 0 {
+			keep = append(keep, int64(group.ID))
+		}
+	}
+	_, err := tx.Exec(`
+	DELETE FROM modifier_groups
+		WHERE product_id = $1 AND NOT (id = ANY($2))`, menuID, keep)
+	if err != nil {
+		return err
+	}
+
+	for i := range groups {
+		group := &groups[i]
+		if group.ID == 0 {
+			if err = core.insertModifiers(tx, menuID, groups[i:i+1]); err != nil {
+				return err
+			}
+			continue
+		}
+		group.ProductID = menuID
+		result, err := tx.NamedExec(`
+		UPDATE modifier_groups
+			SET name = :name, min_select = :min_select, max_select = :max_select
+			WHERE id = :id AND product_id = :product_id`, group)
+		if err != nil {
+			return err
+		}
+		affects, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affects == 0 {
+			return fmt.Errorf("%w : modifier group %d of menu item %d", models.ErrNotFound, group.ID, menuID)
+		}
+		if err = core.updateOptions(tx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (core *dalMenu) updateOptions(tx *sqlx.Tx, group *models.ModifierGroup) error {
+	keep := pq.Int64Array{}
+	for _, opt := range group.Options {
+		if opt.ID != 0 {
+			keep = append(keep, int64(opt.ID))
+		}
+	}
+	_, err := tx.Exec(`
+	DELETE FROM modifier_options
+		WHERE group_id = $1 AND NOT (id = ANY($2))`, group.ID, keep)
+	if err != nil {
+		return err
+	}
+
+	for i := range group.Options {
+		opt := &group.Options[i]
+		if opt.ID == 0 {
+			if err = core.insertOption(tx, group.ID, opt); err != nil {
+				return err
+			}
+			continue
+		}
+		opt.GroupID = group.ID
+		result, err := tx.Exec(`
+		UPDATE modifier_options
+			SET name = $1, price_delta = $2, add_allergens = $3, remove_allergens = $4
+			WHERE id = $5 AND group_id = $6`, opt.Name, opt.PriceDelta,
+			opt.AddAllergens, opt.RemoveAllergens, opt.ID, group.ID)
+		if err != nil {
+			return err
+		}
+		affects, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affects == 0 {
+			return fmt.Errorf("%w : option %d of modifier group %d", models.ErrNotFound, opt.ID, group.ID)
+		}
+		_, err = tx.Exec(`DELETE FROM modifier_option_ingredients WHERE option_id = $1`, opt.ID)
+		if err != nil {
+			return err
+		}
+		if err = core.insertOptionIngs(tx, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (core *dalMenu) insertVariant(tx *sqlx.Tx, menuID uint64, variant *models.MenuVariant) error {
+	variant.ProductID = menuID
+	return tx.QueryRow(`
+	INSERT INTO menu_item_variants (product_id, name, price, scale)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id`, menuID, variant.Name, variant.Price, variant.Scale).Scan(&variant.ID)
+}
+
+// updateVariants keeps the ids of variants, so their price changes go to the price history:
+// variants with id are updated, without id are added, the missing ones are deleted
+func (core *dalMenu) updateVariants(tx *sqlx.Tx, menuID uint64, variants []models.MenuVariant) error {
+	keep := pq.Int64Array{} // not nil: NULL would keep everything
+	for _, variant := range variants {
+		if variant.ID != 0 {
+			keep = append(keep, int64(variant.ID))
+		}
+	}
+	_, err := tx.Exec(`
+	DELETE FROM menu_item_variants
+		WHERE product_id = $1 AND NOT (id = ANY($2))`, menuID, keep)
+	if err != nil {
+		return err
+	}
+
+	for i := range variants {
+		variant := &variants[i]
+		if variant.ID == 0 {
+			if err = core.insertVariant(tx, menuID, variant); err != nil {
+				return err
+			}
+			continue
+		}
+		variant.ProductID = menuID
+		result, err := tx.NamedExec(`
+		UPDATE menu_item_variants
+			SET name = :name, price = :price, scale = :scale
+			WHERE id = :id AND product_id = :product_id`, variant)
+		if err != nil {
+			return err
+		}
+		affects, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affects == 0 {
+			return fmt.Errorf("%w : variant %d of menu item %d", models.ErrNotFound, variant.ID, menuID)
+		}
+	}
+	return nil
+}
+
+// checkComponents: choices of a bundle are existing items, not bundles.
+// A product which is a choice of some bundle can not become a bundle itself
+func (core *dalMenu) checkComponents(tx *sqlx.Tx, menu *models.MenuItem) error {
+	if menu.Kind == "bundle" && menu.ID != 0 {
+		var isPart bool
+		err := tx.Get(&isPart, `SELECT EXISTS (SELECT 1 FROM bundle_component_choices WHERE product_id = $1)`, menu.ID)
+		if err != nil {
+			return err
+		}
+		if isPart {
+			return fmt.Errorf("%w: menu item %d is a part of a bundle", models.ErrBadInput, menu.ID)
+		}
+	}
+
+	stmt, err := tx.Prepare(`SELECT kind FROM menu_items WHERE id = $1`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, comp := range menu.Components {
+		for _, product := range comp.Choices {
+			var kind string
+			err = stmt.QueryRow(product).Scan(&kind)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: choice %d of component %s", models.ErrNotFound, product, comp.Name)
+			} else if err != nil {
+				return err
+			}
+			if kind == "bundle" || uint64(product) == menu.ID {
+				return fmt.Errorf("%w: choice %d of component %s is a bundle", models.ErrBadInput, product, comp.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (core *dalMenu) selectComponents(tx *sqlx.Tx, menu *models.MenuItem) error {
+	return tx.Select(&menu.Components, `
+	SELECT c.id, c.bundle_id, c.name, c.quantity,
+			array_remove(array_agg(ch.product_id ORDER BY ch.product_id), NULL) AS choices
+		FROM bundle_components AS c
+		LEFT JOIN bundle_component_choices AS ch ON ch.component_id = c.id
+		WHERE c.bundle_id = $1
+		GROUP BY c.id
+		ORDER BY c.id`, menu.ID)
+}
+
+func (core *dalMenu) insertComponents(tx *sqlx.Tx, menuID uint64, components []models.BundleComponent) error {
+	for i := range components {
+		comp := &components[i]
+		comp.BundleID = menuID
+		err := tx.QueryRow(`
+		INSERT INTO bundle_components (bundle_id, name, quantity)
+		VALUES ($1, $2, $3)
+		RETURNING id`, menuID, comp.Name, comp.Quantity).Scan(&comp.ID)
+		if err != nil {
+			return err
+		}
+		if err = core.insertChoices(tx, comp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (core *dalMenu) insertChoices(tx *sqlx.Tx, comp *models.BundleComponent) error {
+	_, err := tx.Exec(`
+	INSERT INTO bundle_component_choices (component_id, product_id)
+		SELECT $1, unnest($2::int[])`, comp.ID, comp.Choices)
+	return err
+}
+
+// updateComponents keeps the ids of the components of a bundle, as updateVariants does
+// for sizes. The choices of a component are replaced as a whole
+func (core *dalMenu) updateComponents(tx *sqlx.Tx, menuID uint64, components []models.BundleComponent) error {
+	keep := pq.Int64Array{} // not nil: NULL would keep everything
+	for _, comp := range components {
+		if comp.ID != 0 {
+			keep = append(keep, int64(comp.ID))
+		}
+	}
+	_, err := tx.Exec(`
+	DELETE FROM bundle_components
+		WHERE bundle_id = $1 AND NOT (id = ANY($2))`, menuID, keep)
+	if err != nil {
+		return err
+	}
+
+	for i := range components {
+		comp := &components[i]
+		if comp.ID == 0 {
+			if err = core.insertComponents(tx, menuID, components[i:i+1]); err != nil {
+				return err
+			}
+			continue
+		}
+		comp.BundleID = menuID
+		result, err := tx.NamedExec(`
+		UPDATE bundle_components
+			SET name = :name, quantity = :quantity
+			WHERE id = :id AND bundle_id = :bundle_id`, comp)
+		if err != nil {
+			return err
+		}
+		affects, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affects == 0 {
+			return fmt.Errorf("%w : component %d of menu item %d", models.ErrNotFound, comp.ID, menuID)
+		}
+		_, err = tx.Exec(`DELETE FROM bundle_component_choices WHERE component_id = $1`, comp.ID)
+		if err != nil {
+			return err
+		}
+		if err = core.insertChoices(tx, comp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
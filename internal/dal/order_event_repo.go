@@ -0,0 +1,133 @@
+package dal
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// channel of pg_notify in notify_order_event()
+const orderEventsChannel string = "order_events"
+
+// ids of sent events the listener remembers to skip them in a catch-up
+const seenLimit = 4096
+
+type dalOrderEvent struct {
+	database *sqlx.DB
+	dsn      string // LISTEN needs its own connection, not one of the pool
+}
+
+type OrderEventDalInter interface {
+	Listen(ctx context.Context, events chan<- models.OrderEvent) error
+	SelectEventsAfter(id uint64, limit uint64) ([]models.OrderEvent, error)
+	DeleteEventsBefore(time.Time) (int64, error)
+}
+
+func ReturnDalOrderEvent(db *sqlx.DB, dsn string) OrderEventDalInter {
+	return &dalOrderEvent{database: db, dsn: dsn}
+}
+
+// Listen sends the events of committed transactions to the channel until ctx is done, then closes it.
+// After a lost connection the missed events are read from order_events, so none is lost
+func (db *dalOrderEvent) Listen(ctx context.Context, events chan<- models.OrderEvent) error {
+	listener := pq.NewListener(db.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("order events listener", "event", ev, "error", err)
+		}
+	})
+	if err := listener.Listen(orderEventsChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	var last uint64
+	if err := db.database.Get(&last, `SELECT COALESCE(MAX(id), 0) FROM order_events`); err != nil {
+		listener.Close()
+		return err
+	}
+	// a catch-up reads again some events which were sent, they are skipped by id
+	seen := map[uint64]struct{}{}
+	send := func(ev models.OrderEvent) {
+		if _, ok := seen[ev.ID]; ok {
+			return
+		}
+		seen[ev.ID] = struct{}{}
+		events <- ev
+		last = max(last, ev.ID)
+		if len(seen) > seenLimit {
+			for id := range seen {
+				if id+seenLimit/2 < last {
+					delete(seen, id)
+				}
+			}
+		}
+	}
+	go func() {
+		defer close(events)
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				if n == nil { // reconnected, notifications of the gap are lost
+					missed, err := db.SelectEventsAfter(last, 0)
+					if err != nil {
+						slog.Error("order events after reconnect", "error", err)
+						continue
+					}
+					for _, ev := range missed {
+						send(ev)
+					}
+					continue
+				}
+				var ev models.OrderEvent
+				if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+					slog.Error("order event payload", "payload", n.Extra, "error", err)
+					continue
+				}
+				send(ev)
+			case <-time.After(90 * time.Second):
+				if err := listener.Ping(); err != nil {
+					slog.Error("order events listener ping", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// SelectEventsAfter: events which the one who has seen the event "id" can have missed, in their order.
+// These are the later ids and the smaller ids of transactions which were still running when
+// the event "id" was written (xact_xmin), so some of them can be sent again. limit 0 is all of them
+func (db *dalOrderEvent) SelectEventsAfter(id uint64, limit uint64) ([]models.OrderEvent, error) {
+	events := []models.OrderEvent{}
+	query := `
+	SELECT e.id, e.order_id, e.kind, e.status, e.created_at
+		FROM order_events AS e
+		LEFT JOIN order_events AS seen ON seen.id = $1
+		WHERE e.id > $1
+			OR (e.id < $1 AND e.xact >= seen.xact_xmin AND e.xact <> seen.xact)
+		ORDER BY e.id`
+	args := []any{id}
+	if limit != 0 {
+		query += " LIMIT $2"
+		args = append(args, limit)
+	}
+	return events, db.database.Select(&events, query, args...)
+}
+
+// DeleteEventsBefore removes old events, a client can not resume from them any more
+func (db *dalOrderEvent) DeleteEventsBefore(before time.Time) (int64, error) {
+	res, err := db.database.Exec(`DELETE FROM order_events WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -1,9 +1,15 @@
 package dal
 
 import (
+	"cmp"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"frappuccino/models"
 
@@ -16,47 +22,202 @@ type dalOrder struct {
 }
 
 type OrderDalInter interface {
-	SelectAllOrders() ([]models.Order, error)
+	SelectAllOrders(*models.OrderFilter) ([]models.Order, error)
 	SelectOrder(uint64) (*models.Order, error)
 	DeleteOrder(uint64) error
 	InsertOrder(*models.Order, *[]models.InventoryUpdate) error
+	InsertJobOrder(*models.Order, *[]models.InventoryUpdate, JobProgress) error
+	InsertAllOrders(ords []*models.Order, invUpdates *[]models.InventoryUpdate, dryRun bool, progress JobProgress) ([]error, error)
 	UpdateOrder(*models.Order) error
 	CloseOrder(uint64) error
+	UpdateStatus(id uint64, from []string, to string) error
+	CancelOrder(id uint64, from []string, reason string) error
+	RefundOrder(ref *models.Refund, from []string) error
+	SelectRefunds(orderID uint64) ([]models.Refund, error)
+	SelectItemCategories(orderID uint64) ([]models.ItemCategory, error)
+	SelectPickups(from, to *time.Time) (*models.PickupQueue, error)
+	SelectStoreTimezone() (string, error)
 	SelectAllStatusHistory() ([]models.StatusHistory, error)
+	InsertIdempotency(idem *models.Idempotency, busyFor time.Duration) (*models.Idempotency, error)
+	UpdateIdempotency(*models.Idempotency) error
+	DeleteIdempotency(key, endpoint string) error
+	InsertBatchJob(*models.BatchJob) error
+	SelectBatchJob(uint64) (*models.BatchJob, error)
+	ClaimBatchJob(worker string, lease time.Duration) (*models.BatchJob, error)
+	HeartbeatBatchJob(*models.BatchJob) error
+	UpdateBatchJob(*models.BatchJob) error
 }
 
+// JobProgress gives the batch job as it is after the orders of a transaction: processed and result.
+// It is saved in that transaction, so a restarted job never inserts the same order twice
+type JobProgress func() (*models.BatchJob, error)
+
 func ReturnDulOrderDB(db *sqlx.DB) OrderDalInter {
 	return &dalOrder{database: db}
 }
 
-func (db *dalOrder) SelectAllOrders() ([]models.Order, error) {
+func (db *dalOrder) SelectAllOrders(filter *models.OrderFilter) ([]models.Order, error) {
 	tx, err := db.database.Beginx()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	if err != nil {
+		return nil, err
+	}
 
+	query, args := db.ordersQuery(filter)
 	var orders []models.Order
+	err = tx.Select(&orders, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return orders, tx.Commit()
+	}
 
-	err = tx.Select(&orders, `SELECT * FROM orders`)
+	// items of all orders by 1 query, not 1 query for every order
+	ids := make(pq.Int64Array, len(orders))
+	index := make(map[uint64]int, len(orders))
+	for i, ord := range orders {
+		ids[i] = int64(ord.ID)
+		index[ord.ID] = i
+	}
+	var items []struct {
+		OrderID uint64 `db:"order_id"`
+		models.OrderItem
+	}
+	err = tx.Select(&items, `
+	SELECT order_id, id, product_id, name, variant_id, variant_name, quantity, unit_price, price_rule, line_total,
+			discount, tax_rate, tax, allergens
+		FROM order_items
+		WHERE order_id = ANY($1)
+		ORDER BY order_id, id`, ids)
 	if err != nil {
 		return nil, err
 	}
+	lines := make([]models.OrderItem, len(items))
+	for i, item := range items {
+		lines[i] = item.OrderItem
+	}
+	if err = db.selectItemParts(tx, lines); err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		j := index[item.OrderID]
+		orders[j].Items = append(orders[j].Items, lines[i])
+	}
+	return orders, tx.Commit()
+}
 
-	stmt, err := tx.PrepareNamed(`SELECT product_id, quantity FROM order_items WHERE order_id = :id`)
+// selectItemParts loads the modifier and bundle component snapshots of the order lines, 1 query each
+func (db *dalOrder) selectItemParts(tx *sqlx.Tx, items []models.OrderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make(pq.Int64Array, len(items))
+	index := make(map[uint64]int, len(items))
+	for i, item := range items {
+		ids[i] = int64(item.ID)
+		index[item.ID] = i
+	}
+	var mods []struct {
+		ItemID uint64 `db:"item_id"`
+		models.OrderItemModifier
+	}
+	err := tx.Select(&mods, `
+	SELECT item_id, option_id, name, price_delta
+		FROM order_item_modifiers
+		WHERE item_id = ANY($1)
+		ORDER BY item_id, option_id`, ids)
 	if err != nil {
-		return nil, nil
+		return err
+	}
+	for _, mod := range mods {
+		i := index[mod.ItemID]
+		items[i].Modifiers = append(items[i].Modifiers, mod.OrderItemModifier)
 	}
 
-	defer stmt.Close()
+	var comps []struct {
+		ItemID uint64 `db:"item_id"`
+		models.OrderItemComponent
+	}
+	err = tx.Select(&comps, `
+	SELECT item_id, component_id, product_id, name, quantity, share
+		FROM order_item_components
+		WHERE item_id = ANY($1)
+		ORDER BY item_id, component_id`, ids)
+	if err != nil {
+		return err
+	}
+	for _, comp := range comps {
+		i := index[comp.ItemID]
+		items[i].Components = append(items[i].Components, comp.OrderItemComponent)
+	}
+	return nil
+}
 
-	for i, v := range orders {
-		err = stmt.Select(&orders[i].Items, v)
-		if err != nil {
-			return nil, err
+// ordersQuery builds the select for GET /orders. Only whitelisted column names
+// come into the text of the query, all values go as arguments
+func (db *dalOrder) ordersQuery(filter *models.OrderFilter) (string, []any) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(filter.Statuses) != 0 {
+		where = append(where, "o.status::text = ANY("+arg(filter.Statuses)+")")
+	}
+	if len(filter.Customer) != 0 {
+		where = append(where, "o.customer_name ILIKE '%' || "+arg(filter.Customer)+" || '%'")
+	}
+	if filter.CustomerID != nil {
+		where = append(where, "o.customer_id = "+arg(*filter.CustomerID))
+	}
+	if filter.Start != nil {
+		where = append(where, "o.created_at::date >= "+arg(*filter.Start)+"::date")
+	}
+	if filter.End != nil {
+		where = append(where, "o.created_at::date <= "+arg(*filter.End)+"::date")
+	}
+	if filter.MinTotal != nil {
+		where = append(where, "o.total >= "+arg(*filter.MinTotal))
+	}
+	if filter.MaxTotal != nil {
+		where = append(where, "o.total <= "+arg(*filter.MaxTotal))
+	}
+	if filter.ProductID != nil {
+		where = append(where, "EXISTS (SELECT 1 FROM order_items AS oi WHERE oi.order_id = o.id AND oi.product_id = "+arg(*filter.ProductID)+")")
+	}
+
+	column := map[string]string{"created_at": "o.created_at", "total": "o.total"}[filter.SortBy]
+	cast := map[string]string{"created_at": "::timestamptz", "total": "::numeric"}[filter.SortBy]
+	cmp, dir := ">", "ASC"
+	if filter.Desc {
+		cmp, dir = "<", "DESC"
+	}
+	if filter.After != nil {
+		if len(column) == 0 {
+			where = append(where, "o.id "+cmp+" "+arg(filter.After.ID))
+		} else {
+			where = append(where, "("+column+", o.id) "+cmp+" ("+arg(filter.After.Value)+cast+", "+arg(filter.After.ID)+")")
 		}
 	}
-	return orders, tx.Commit()
+
+	query := `SELECT o.* FROM orders AS o`
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if len(column) != 0 {
+		query += " ORDER BY " + column + " " + dir + ", o.id " + dir
+	} else {
+		query += " ORDER BY o.id " + dir
+	}
+	query += " LIMIT " + arg(filter.Limit)
+	return query, args
 }
 
 func (db *dalOrder) SelectOrder(id uint64) (*models.Order, error) {
@@ -71,38 +232,110 @@ func (db *dalOrder) SelectOrder(id uint64) (*models.Order, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = tx.Select(&order.Items, `SELECT product_id, quantity FROM order_items WHERE order_id = $1`, id)
+	err = tx.Select(&order.Items, `
+	SELECT id, product_id, name, variant_id, variant_name, quantity, unit_price, price_rule, line_total,
+			discount, tax_rate, tax, allergens
+		FROM order_items
+		WHERE order_id = $1
+		ORDER BY id`, id)
 	if err != nil {
 		return nil, err
 	}
+	if err = db.selectItemParts(tx, order.Items); err != nil {
+		return nil, err
+	}
 	return &order, tx.Commit()
 }
 
+// SelectItemCategories: categories of the order lines by the menu, in the order of the menu sections
+func (db *dalOrder) SelectItemCategories(orderID uint64) ([]models.ItemCategory, error) {
+	categories := []models.ItemCategory{}
+	err := db.database.Select(&categories, `
+	SELECT oi.id AS item_id, COALESCE(c.name, '') AS category
+		FROM order_items AS oi
+		LEFT JOIN menu_items AS m ON m.id = oi.product_id
+		LEFT JOIN categories AS c ON c.id = m.category_id
+		WHERE oi.order_id = $1
+		ORDER BY c.position NULLS LAST, c.name NULLS LAST, oi.id`, orderID)
+	return categories, err
+}
+
+// DeleteOrder: only a processing order without payments is deleted, the accepted
+// ones are cancelled or refunded, their money stays in payments and refunds
 func (db *dalOrder) DeleteOrder(id uint64) error {
 	tx, err := db.database.Beginx()
 	if err != nil {
 		return err
 	}
-	// егер әлі жабылмаған тапсырыс болса inventory ді түгендейді
-	status, err := db.getStatus(tx, id)
-	if err != nil {
-		return errors.Join(err, tx.Rollback())
+	defer tx.Rollback()
+	if _, err = db.getStatusFrom(tx, id, []string{"processing"}); err != nil {
+		return err
 	}
-	if status == "processing" {
-		err = db.inventoryRejector(tx, id)
-		if err != nil {
-			return errors.Join(err, tx.Rollback())
-		}
+	if err = db.checkNotPaid(tx, id); err != nil {
+		return err
+	}
+	// әлі жабылмаған тапсырыс, inventory ді түгендейді
+	if err = db.inventoryRejector(tx, id); err != nil {
+		return err
+	}
+	// points of the order go back, the ledger keeps its rows
+	if err = db.reversePoints(tx, id, 1, "order deleted"); err != nil {
+		return err
+	}
+	if err = db.releasePromo(tx, id); err != nil {
+		return err
 	}
-	// жәй өшіре саламыз. order_items тен өзі өшіп кетеді
+	if err = db.releasePickup(tx, id); err != nil {
+		return err
+	}
+	// order_items тен өзі өшіп кетеді, voided payments are RESTRICT (23503)
 	_, err = tx.Exec(`DELETE FROM orders WHERE id=$1`, id)
-	if err != nil {
-		return errors.Join(err, tx.Rollback())
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+		return fmt.Errorf("%w : order %d has payment records, cancel it instead", models.ErrConflict, id)
+	} else if err != nil {
+		return err
 	}
 	return tx.Commit()
 }
 
+// serialRetries: an order runs in REPEATABLE READ, so when it loses the race for a counter row
+// (uses of a promo code, points, slot places, stock, the batch job) to a transaction
+// committed after its snapshot, it fails with 40001 and is run again on a fresh snapshot
+const serialRetries = 3
+
+// isSerialFailure: could not serialize access due to concurrent update, or a deadlock
+func isSerialFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
+
+// retrySerial runs the transaction again while it loses races, then it is a conflict (409)
+func retrySerial(run func() error) error {
+	var err error
+	for range serialRetries {
+		if err = run(); !isSerialFailure(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w : %v, try again", models.ErrConflict, err)
+}
+
 func (db *dalOrder) InsertOrder(ord *models.Order, invUpdates *[]models.InventoryUpdate) error {
+	return retrySerial(func() error {
+		return db.insertOrderOnce(ord, invUpdates, nil)
+	})
+}
+
+// InsertJobOrder is InsertOrder of an async batch job, the job progress is saved with the order
+func (db *dalOrder) InsertJobOrder(ord *models.Order, invUpdates *[]models.InventoryUpdate, progress JobProgress) error {
+	return retrySerial(func() error {
+		return db.insertOrderOnce(ord, invUpdates, progress)
+	})
+}
+
+// insertOrderOnce is 1 try of InsertOrder. invUpdates gets the usage of the order before
+// the progress is made of it and is put back if the try fails, so a retry does not count it twice
+func (db *dalOrder) insertOrderOnce(ord *models.Order, invUpdates *[]models.InventoryUpdate, progress JobProgress) error {
 	tx, err := db.database.Beginx()
 	if err != nil {
 		return err
@@ -113,17 +346,92 @@ func (db *dalOrder) InsertOrder(ord *models.Order, invUpdates *[]models.Inventor
 		return err
 	}
 
-	if err = tx.QueryRow(`
-	INSERT INTO orders (customer_name, allergens)
-	VALUES($1,$2)
-	RETURNING id`, ord.CustomerName, ord.Allergens).Scan(&ord.ID); err != nil {
+	var used []models.InventoryUpdate
+	if err = db.insertOrderTx(tx, ord, &used); err != nil {
 		return err
 	}
-	err = db.detectorAndInserterOrderItems(tx, ord, invUpdates)
+	if invUpdates == nil {
+		return tx.Commit()
+	}
+	before := slices.Clone(*invUpdates)
+	db.mergerInv(used, invUpdates)
+	if err = db.saveJobTx(tx, progress); err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
+		*invUpdates = before
+	}
+	return err
+}
+
+// InsertAllOrders inserts all orders in one transaction and commits only if every order is fine.
+// errs[i] is the client error of ords[i]: allergen, not found, wrong modifiers, bad promo code, customer, points or pickup time, not enough items.
+// With dryRun nothing is committed, it only tells which orders would fail.
+// progress is of an async job (nil if not), it is saved with the orders
+func (db *dalOrder) InsertAllOrders(ords []*models.Order, invUpdates *[]models.InventoryUpdate, dryRun bool, progress JobProgress) ([]error, error) {
+	var errs []error
+	err := retrySerial(func() (err error) {
+		errs, err = db.insertAllOnce(ords, invUpdates, dryRun, progress)
 		return err
+	})
+	return errs, err
+}
+
+// insertAllOnce is 1 try of InsertAllOrders
+func (db *dalOrder) insertAllOnce(ords []*models.Order, invUpdates *[]models.InventoryUpdate, dryRun bool, progress JobProgress) ([]error, error) {
+	tx, err := db.database.Beginx()
+	if err != nil {
+		return nil, err
 	}
-	return tx.Commit()
+	defer tx.Rollback()
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(ords))
+	var failed bool
+	var invsTemp []models.InventoryUpdate
+	for i, ord := range ords {
+		// failed order is rolled back to here, so the next orders are checked against clean inventory
+		if _, err = tx.Exec(`SAVEPOINT batch_order`); err != nil {
+			return nil, err
+		}
+		err = db.insertOrderTx(tx, ord, &invsTemp)
+		if err == nil {
+			if _, err = tx.Exec(`RELEASE SAVEPOINT batch_order`); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !errors.Is(err, models.ErrAllergen) && !errors.Is(err, models.ErrNotFoundItems) &&
+			!errors.Is(err, models.ErrOrderNotEnoughItems) && !errors.Is(err, models.ErrBadInputItems) &&
+			!errors.Is(err, models.ErrUnavailableItems) && !errors.Is(err, models.ErrPromoCode) &&
+			!errors.Is(err, models.ErrCustomer) && !errors.Is(err, models.ErrLoyalty) &&
+			!errors.Is(err, models.ErrPickup) {
+			return nil, err
+		}
+		errs[i], failed = err, true
+		if _, err = tx.Exec(`ROLLBACK TO SAVEPOINT batch_order`); err != nil {
+			return nil, err
+		}
+	}
+	if failed || dryRun {
+		return errs, nil
+	}
+	if invUpdates == nil {
+		invUpdates = &[]models.InventoryUpdate{}
+	}
+	before := slices.Clone(*invUpdates)
+	db.mergerInv(invsTemp, invUpdates)
+	if err = db.saveJobTx(tx, progress); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		*invUpdates = before
+		return nil, err
+	}
+	return errs, nil
 }
 
 func (db *dalOrder) UpdateOrder(ord *models.Order) error {
@@ -140,16 +448,46 @@ func (db *dalOrder) UpdateOrder(ord *models.Order) error {
 	if status != "processing" {
 		return errors.New("it is closed order")
 	}
+	// the total can change, payments are taken back first
+	if err = db.checkNotPaid(tx, ord.ID); err != nil {
+		return err
+	}
 	err = db.inventoryRejector(tx, ord.ID)
 	if err != nil {
 		return err
 	}
 
-	// тазалау
+	// тазалау, promo code and points are checked again with the new lines
 	_, err = tx.Exec(`DELETE FROM order_items WHERE order_id = $1`, ord.ID)
 	if err != nil {
 		return err
 	}
+	if err = db.releasePromo(tx, ord.ID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM promo_redemptions WHERE order_id = $1`, ord.ID)
+	if err != nil {
+		return err
+	}
+	if err = db.reversePoints(tx, ord.ID, 1, "order updated"); err != nil {
+		return err
+	}
+	if err = db.fromCustomer(tx, ord); err != nil {
+		return err
+	}
+	// the same pickup time keeps its place in the slot, even when it is already close
+	var pickupAt *time.Time
+	if err = tx.Get(&pickupAt, `SELECT pickup_at FROM orders WHERE id = $1`, ord.ID); err != nil {
+		return err
+	}
+	if pickupAt == nil || ord.PickupAt == nil || !pickupAt.Equal(*ord.PickupAt) {
+		if err = db.releasePickup(tx, ord.ID); err != nil {
+			return err
+		}
+		if err = db.checkPickup(tx, ord); err != nil {
+			return err
+		}
+	}
 	err = db.detectorAndInserterOrderItems(tx, ord, nil)
 	if err != nil {
 		return err
@@ -157,8 +495,10 @@ func (db *dalOrder) UpdateOrder(ord *models.Order) error {
 	_, err = tx.NamedExec(`
 	UPDATE orders 
 		SET 
+			customer_id = :customer_id,
 			customer_name = :customer_name, 
 			allergens = :allergens,
+			pickup_at = :pickup_at,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id=:id`, ord)
 	if err != nil {
@@ -167,19 +507,23 @@ func (db *dalOrder) UpdateOrder(ord *models.Order) error {
 	return tx.Commit()
 }
 
+// CloseOrder accepts a processing order only if its payments cover the total, its customer earns points
 func (db *dalOrder) CloseOrder(id uint64) error {
 	tx, err := db.database.Beginx()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	status, err := db.getStatus(tx, id)
+	order, err := lockOrderPaid(tx, id)
 	if err != nil {
 		return err
 	}
-	if status != "processing" {
+	if order.Status != "processing" {
 		return models.ErrOrderStatusClosed
 	}
+	if due := math.Round(order.Due*100) / 100; due > 0 {
+		return fmt.Errorf("%w : %.2f of %.2f is due", models.ErrOrderNotPaid, due, order.Total)
+	}
 	_, err = tx.Exec(`UPDATE orders
 		SET status = 'accepted',
 		updated_at = CURRENT_TIMESTAMP
@@ -187,6 +531,66 @@ func (db *dalOrder) CloseOrder(id uint64) error {
 	if err != nil {
 		return err
 	}
+	if err = db.earnPoints(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateStatus moves the order to the status "to" only if its current status is one of "from"
+func (db *dalOrder) UpdateStatus(id uint64, from []string, to string) error {
+	tx, err := db.database.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = db.getStatusFrom(tx, id, from); err != nil {
+		return err
+	}
+	if err = db.setStatus(tx, id, to); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelOrder is like UpdateStatus, but also returns the ingredients of an unfinished order back to inventory.
+// The order and its order_items are kept, so reports still see it
+func (db *dalOrder) CancelOrder(id uint64, from []string, reason string) error {
+	tx, err := db.database.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	status, err := db.getStatusFrom(tx, id, from)
+	if err != nil {
+		return err
+	}
+	if err = db.checkNotPaid(tx, id); err != nil {
+		return err
+	}
+	if status == "processing" {
+		if err = db.inventoryRejector(tx, id); err != nil {
+			return err
+		}
+	}
+	if err = db.releasePickup(tx, id); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE orders
+		SET status = 'cancelled',
+		reason = $1,
+		updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, reason, id)
+	if err != nil {
+		return err
+	}
+	if err = db.reversePoints(tx, id, 1, "order cancelled"); err != nil {
+		return err
+	}
+	// the redemption stays for the report, its use is given back
+	if err = db.releasePromo(tx, id); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -199,6 +603,156 @@ func (db *dalOrder) SelectAllStatusHistory() ([]models.StatusHistory, error) {
 	return statusHistory, nil
 }
 
+// InsertIdempotency saves the key as "in progress". If the key is already saved it returns the saved one.
+// A key in progress for more than busyFor is of a request which died, it is free again
+func (db *dalOrder) InsertIdempotency(idem *models.Idempotency, busyFor time.Duration) (*models.Idempotency, error) {
+	tx, err := db.database.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// ключи живут сутки
+	_, err = tx.Exec(`
+	DELETE FROM idempotency_keys
+		WHERE created_at < CURRENT_TIMESTAMP - INTERVAL '24 hours'
+			OR (status_code = 0 AND created_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second')`, busyFor.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.NamedExec(`
+	INSERT INTO idempotency_keys (key, endpoint, request_hash)
+		VALUES (:key, :endpoint, :request_hash)
+		ON CONFLICT DO NOTHING`, idem)
+	if err != nil {
+		return nil, err
+	}
+	affects, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affects != 0 {
+		return nil, tx.Commit()
+	}
+
+	var saved models.Idempotency
+	err = tx.Get(&saved, `SELECT * FROM idempotency_keys WHERE key = $1 AND endpoint = $2`, idem.Key, idem.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, tx.Commit()
+}
+
+func (db *dalOrder) UpdateIdempotency(idem *models.Idempotency) error {
+	_, err := db.database.NamedExec(`
+	UPDATE idempotency_keys
+		SET status_code = :status_code, response = :response
+		WHERE key = :key AND endpoint = :endpoint`, idem)
+	return err
+}
+
+func (db *dalOrder) DeleteIdempotency(key, endpoint string) error {
+	_, err := db.database.Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND endpoint = $2`, key, endpoint)
+	return err
+}
+
+func (db *dalOrder) InsertBatchJob(job *models.BatchJob) error {
+	return db.database.QueryRow(`
+	INSERT INTO batch_jobs (atomic, total, request)
+		VALUES ($1, $2, $3::jsonb)
+	RETURNING id, status, created_at, updated_at`,
+		job.Atomic, job.Total, string(job.Request)).Scan(&job.ID, &job.Status, &job.CreatedAt, &job.UpdatedAt)
+}
+
+func (db *dalOrder) SelectBatchJob(id uint64) (*models.BatchJob, error) {
+	var job models.BatchJob
+	err := db.database.Get(&job, `SELECT * FROM batch_jobs WHERE id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimBatchJob takes the oldest queued job, or a running one whose worker stopped sending
+// heartbeats for lease (its instance died), and leases it to worker. nil if there is no job
+func (db *dalOrder) ClaimBatchJob(worker string, lease time.Duration) (*models.BatchJob, error) {
+	var job models.BatchJob
+	err := db.database.Get(&job, `
+	UPDATE batch_jobs
+		SET status = 'running', locked_by = $1, heartbeat_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM batch_jobs
+			WHERE status = 'queued'
+				OR (status = 'running' AND heartbeat_at < CURRENT_TIMESTAMP - $2 * INTERVAL '1 second')
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+	RETURNING *`, worker, lease.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// HeartbeatBatchJob extends the lease, ErrBatchLease if another worker has taken the job
+func (db *dalOrder) HeartbeatBatchJob(job *models.BatchJob) error {
+	result, err := db.database.Exec(`
+	UPDATE batch_jobs
+		SET heartbeat_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND locked_by = $2 AND status = 'running'`, job.ID, job.LockedBy)
+	return leaseKept(result, err)
+}
+
+// UpdateBatchJob saves the job only while its worker holds the lease
+func (db *dalOrder) UpdateBatchJob(job *models.BatchJob) error {
+	var result any
+	if len(job.Result) != 0 {
+		result = string(job.Result)
+	}
+	res, err := db.database.Exec(`
+	UPDATE batch_jobs
+		SET status = $1, processed = $2, result = $3::jsonb, error = $4,
+			heartbeat_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5 AND locked_by = $6`, job.Status, job.Processed, result, job.Error, job.ID, job.LockedBy)
+	return leaseKept(res, err)
+}
+
+// saveJobTx saves processed and result of the job in the transaction of its orders
+func (db *dalOrder) saveJobTx(tx *sqlx.Tx, progress JobProgress) error {
+	if progress == nil {
+		return nil
+	}
+	job, err := progress()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`
+	UPDATE batch_jobs
+		SET processed = $1, result = $2::jsonb,
+			heartbeat_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND locked_by = $4 AND status = 'running'`, job.Processed, string(job.Result), job.ID, job.LockedBy)
+	return leaseKept(result, err)
+}
+
+func leaseKept(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrBatchLease
+	}
+	return nil
+}
+
 func (db *dalOrder) getStatus(tx *sqlx.Tx, id uint64) (string, error) {
 	var status string
 	err := tx.Get(&status, `SELECT status FROM orders WHERE id=$1`, id)
@@ -210,14 +764,54 @@ func (db *dalOrder) getStatus(tx *sqlx.Tx, id uint64) (string, error) {
 	return status, nil
 }
 
+// getStatusFrom locks the order row and checks that its status is one of "from"
+func (db *dalOrder) getStatusFrom(tx *sqlx.Tx, id uint64, from []string) (string, error) {
+	var status string
+	err := tx.Get(&status, `SELECT status FROM orders WHERE id=$1 FOR UPDATE`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", models.ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if !slices.Contains(from, status) {
+		return "", fmt.Errorf("%w : %s", models.ErrOrderStatusMove, status)
+	}
+	return status, nil
+}
+
+func (db *dalOrder) setStatus(tx *sqlx.Tx, id uint64, status string) error {
+	_, err := tx.Exec(`UPDATE orders
+		SET status = $1,
+		updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, status, id)
+	return err
+}
+
+// checkNotPaid: an order with payments (not voided) is not edited or cancelled
+func (db *dalOrder) checkNotPaid(tx *sqlx.Tx, id uint64) error {
+	order, err := lockOrderPaid(tx, id)
+	if err != nil {
+		return err
+	}
+	if order.Paid > 0 {
+		return fmt.Errorf("%w : order %d has payments of %.2f, void them first", models.ErrConflict, id, order.Paid)
+	}
+	return nil
+}
+
+// inventoryRejector gives back to inventory what the order lines really took (recipe and modifiers)
 func (db *dalOrder) inventoryRejector(tx *sqlx.Tx, orderID uint64) error {
 	_, err := tx.Exec(`
 	UPDATE inventory AS inv
-	SET quantity = inv.quantity + (ings.quantity * ord.quantity)
-	FROM menu_item_ingredients AS ings
-	JOIN order_items AS ord ON ings.product_id = ord.product_id
-	WHERE inv.id = ings.inventory_id AND ord.order_id = $1`, orderID)
-	// UPDATE inventory AS inv SET quantity = inv.quantity+(i.quantity * o.quantity) FROM menu_item_ingredients AS i JOIN order_items AS o ON i.product_id = o.product_id WHERE o.order_id = 1;
+	SET quantity = inv.quantity + used.quantity
+	FROM (
+		SELECT ings.inventory_id, SUM(ings.quantity) AS quantity
+		FROM order_item_ingredients AS ings
+		JOIN order_items AS ord ON ord.id = ings.item_id
+		WHERE ord.order_id = $1
+		GROUP BY ings.inventory_id
+	) AS used
+	WHERE inv.id = used.inventory_id`, orderID)
 	if err != nil {
 		return err
 	}
@@ -225,27 +819,64 @@ func (db *dalOrder) inventoryRejector(tx *sqlx.Tx, orderID uint64) error {
 	const queryTransaction string = `
 	INSERT INTO inventory_transactions (inventory_id, quantity_change, reason)
 		SELECT 
-			inv.id,
-			(ings.quantity * ord.quantity) AS quantity_change,
+			ings.inventory_id,
+			ings.quantity AS quantity_change,
 			'cancelled'::reason_of_inventory_transaction
 		FROM 
-			inventory inv
+			order_item_ingredients ings
 		JOIN 
-			menu_item_ingredients ings ON inv.id = ings.inventory_id
-		JOIN 
-			order_items ord ON ings.product_id = ord.product_id
+			order_items ord ON ord.id = ings.item_id
 		WHERE 
 			ord.order_id = $1`
 	_, err = tx.Exec(queryTransaction, orderID)
 	return err
 }
 
+func (db *dalOrder) insertOrderTx(tx *sqlx.Tx, ord *models.Order, invUpdates *[]models.InventoryUpdate) error {
+	if err := db.fromCustomer(tx, ord); err != nil {
+		return err
+	}
+	if err := db.checkPickup(tx, ord); err != nil {
+		return err
+	}
+	if err := tx.QueryRow(`
+	INSERT INTO orders (customer_id, customer_name, allergens, pickup_at)
+	VALUES($1,$2,$3,$4)
+	RETURNING id`, ord.CustomerID, ord.CustomerName, ord.Allergens, ord.PickupAt).Scan(&ord.ID); err != nil {
+		return err
+	}
+	return db.detectorAndInserterOrderItems(tx, ord, invUpdates)
+}
+
+// fromCustomer checks the customer of the order. The name and the allergens the order
+// came without are taken from the profile, so checkAllergens uses the saved ones
+func (db *dalOrder) fromCustomer(tx *sqlx.Tx, ord *models.Order) error {
+	if ord.CustomerID == nil {
+		return nil
+	}
+	var customer models.Customer
+	err := tx.Get(&customer, `SELECT name, allergens FROM customers WHERE id = $1`, *ord.CustomerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w : customer id = %d", models.ErrCustomer, *ord.CustomerID)
+	} else if err != nil {
+		return err
+	}
+	if len(ord.CustomerName) == 0 {
+		ord.CustomerName = customer.Name
+	}
+	if ord.Allergens == nil { // "allergens": [] is no allergens this time
+		ord.Allergens = customer.Allergens
+	}
+	return nil
+}
+
 func (db *dalOrder) mergerInv(in []models.InventoryUpdate, out *[]models.InventoryUpdate) {
 	for _, invent := range in {
 		var isHere bool
 		for i, inv := range *out {
 			if inv.InventoryID == invent.InventoryID {
 				(*out)[i].QuantityUsed += invent.QuantityUsed
+				(*out)[i].Remaining = invent.Remaining // the latest one is the real one
 				isHere = true
 				break
 			}
@@ -267,6 +898,190 @@ func (db *dalOrder) checkAllergens(orderAller pq.StringArray, menuAller *pq.Stri
 	*menuAller = (*menuAller)[:invalids]
 }
 
+// componentsOf resolves the choices of a bundle line and copies them to item.Components.
+// It returns the products whose recipes the line takes (a product twice for quantity 2)
+// and the menu prices of the components. For not a bundle it is the product itself.
+// at is the pickup time of a pre-order, nil is now
+func (db *dalOrder) componentsOf(componentsStmt, productStmt *sqlx.Stmt, item *models.OrderItem, at *time.Time) (pq.Int64Array, []float64, error) {
+	var components []models.BundleComponent
+	if err := componentsStmt.Select(&components, item.ProductID); err != nil {
+		return nil, nil, err
+	}
+	if len(components) == 0 {
+		if len(item.Components) != 0 {
+			item.Warning = "not a bundle"
+			return nil, nil, fmt.Errorf("%w : product %d is not a bundle", models.ErrBadInputItems, item.ProductID)
+		}
+		return pq.Int64Array{int64(item.ProductID)}, nil, nil
+	}
+
+	chosen := make(map[uint64]uint64, len(item.Components)) // component id -> product id
+	for _, comp := range item.Components {
+		chosen[comp.ComponentID] = comp.ProductID
+	}
+	var recipe pq.Int64Array
+	var prices []float64
+	lines := make([]models.OrderItemComponent, 0, len(components))
+	for _, comp := range components {
+		product, ok := chosen[comp.ID]
+		delete(chosen, comp.ID)
+		if len(comp.Choices) == 0 {
+			item.Warning = "nothing to choose in " + comp.Name
+			return nil, nil, fmt.Errorf("%w : component %s", models.ErrNotFoundItems, comp.Name)
+		}
+		if !ok && len(comp.Choices) == 1 {
+			product, ok = uint64(comp.Choices[0]), true
+		}
+		if !ok {
+			item.Warning = "choose " + comp.Name
+			return nil, nil, fmt.Errorf("%w : component %s", models.ErrBadInputItems, comp.Name)
+		}
+		if !slices.Contains(comp.Choices, int64(product)) {
+			item.Warning = fmt.Sprintf("product %d is not a choice of %s", product, comp.Name)
+			return nil, nil, fmt.Errorf("%w : component %s", models.ErrBadInputItems, comp.Name)
+		}
+
+		var menu models.MenuItem
+		if err := productStmt.Get(&menu, product, at); err != nil {
+			return nil, nil, err
+		}
+		if !menu.Available {
+			item.Warning = fmt.Sprintf("%s is unavailable now", menu.Name)
+			return nil, nil, fmt.Errorf("%w : component %s", models.ErrUnavailableItems, comp.Name)
+		}
+		for range comp.Quantity {
+			recipe = append(recipe, int64(product))
+		}
+		prices = append(prices, menu.Price*float64(comp.Quantity))
+		lines = append(lines, models.OrderItemComponent{
+			ComponentID: comp.ID,
+			ProductID:   product,
+			Name:        menu.Name,
+			Quantity:    comp.Quantity,
+		})
+		// the bundle has allergens of what is in it
+		for _, allergen := range menu.Allergens {
+			if !slices.Contains(item.Allergens, allergen) {
+				item.Allergens = append(item.Allergens, allergen)
+			}
+		}
+	}
+	if len(chosen) != 0 {
+		item.Warning = "unknown bundle component"
+		return nil, nil, fmt.Errorf("%w : unknown component of bundle %d", models.ErrNotFoundItems, item.ProductID)
+	}
+	item.Components = lines
+	return recipe, prices, nil
+}
+
+// shareCents splits total by weights in whole cents (largest remainder): every share is >= 0,
+// the shares sum up to total and none is more than its weight while total is not more than their sum.
+// Zero weights are equal then
+func shareCents(total float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	if len(weights) == 0 {
+		return shares
+	}
+	cents := make([]int64, len(weights))
+	var sum int64
+	for i, w := range weights {
+		cents[i] = max(int64(math.Round(w*100)), 0)
+		sum += cents[i]
+	}
+	if sum == 0 {
+		for i := range cents {
+			cents[i] = 1
+		}
+		sum = int64(len(cents))
+	}
+	totalCents := max(int64(math.Round(total*100)), 0)
+
+	given := make([]int64, len(cents))
+	rests := make([]int64, len(cents))
+	left := totalCents
+	for i, c := range cents {
+		given[i], rests[i] = totalCents*c/sum, totalCents*c%sum
+		left -= given[i]
+	}
+	// cents lost by rounding down go to the biggest remainders, the first line first on a tie
+	order := make([]int, len(cents))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(rests[b], rests[a]) })
+	for _, i := range order[:left] {
+		given[i]++
+	}
+	for i, g := range given {
+		shares[i] = float64(g) / 100
+	}
+	return shares
+}
+
+// shareOut splits the unit price of a bundle to its components by their menu prices,
+// in whole cents, so the shares are never negative and sum up to the price
+func (db *dalOrder) shareOut(unitPrice float64, prices []float64, components []models.OrderItemComponent) {
+	for i, share := range shareCents(unitPrice, prices) {
+		components[i].Share = &share
+	}
+}
+
+// modifiersOf checks the chosen options of the item against the modifier groups of its product.
+// It copies the option names and prices to the item, applies the allergen substitution
+// and returns the price delta of one unit. On client mistakes item.Warning is set
+func (db *dalOrder) modifiersOf(groupsStmt, optionsStmt *sqlx.Stmt, item *models.OrderItem) (float64, error) {
+	var groups []models.ModifierGroup
+	if err := groupsStmt.Select(&groups, item.ProductID); err != nil {
+		return 0, err
+	}
+	ids := make(pq.Int64Array, len(item.Modifiers))
+	for i, mod := range item.Modifiers {
+		ids[i] = int64(mod.OptionID)
+	}
+	var options []models.ModifierOption
+	if err := optionsStmt.Select(&options, item.ProductID, ids); err != nil {
+		return 0, err
+	}
+	byID := make(map[uint64]models.ModifierOption, len(options))
+	for _, opt := range options {
+		byID[opt.ID] = opt
+	}
+
+	var delta float64
+	var removes, adds []string
+	chosen := make(map[uint64]uint64) // group id -> count of chosen options
+	for i, mod := range item.Modifiers {
+		opt, ok := byID[mod.OptionID]
+		if !ok {
+			item.Warning = "modifier not found"
+			return 0, fmt.Errorf("%w : option %d", models.ErrNotFoundItems, mod.OptionID)
+		}
+		item.Modifiers[i].Name = opt.Name
+		item.Modifiers[i].PriceDelta = &opt.PriceDelta
+		delta += opt.PriceDelta
+		chosen[opt.GroupID]++
+		removes = append(removes, opt.RemoveAllergens...)
+		adds = append(adds, opt.AddAllergens...)
+	}
+	for _, group := range groups {
+		if n := chosen[group.ID]; n < group.MinSelect || n > group.MaxSelect {
+			item.Warning = fmt.Sprintf("modifier group %q takes from %d to %d options", group.Name, group.MinSelect, group.MaxSelect)
+			return 0, fmt.Errorf("%w : %s", models.ErrBadInputItems, group.Name)
+		}
+	}
+
+	// oat milk instead of dairy: first everything removed, then everything added
+	item.Allergens = slices.DeleteFunc(item.Allergens, func(a string) bool {
+		return slices.Contains(removes, a)
+	})
+	for _, a := range adds {
+		if !slices.Contains(item.Allergens, a) {
+			item.Allergens = append(item.Allergens, a)
+		}
+	}
+	return delta, nil
+}
+
 func (db *dalOrder) detectorAndInserterOrderItems(tx *sqlx.Tx, ord *models.Order, invsUpdatesOriginal *[]models.InventoryUpdate) error {
 	// проверяет существует ли в меню через select allergens
 	stmt, err := tx.Preparex(`SELECT allergens FROM menu_items WHERE id = $1`)
@@ -275,116 +1090,293 @@ func (db *dalOrder) detectorAndInserterOrderItems(tx *sqlx.Tx, ord *models.Order
 	}
 	defer stmt.Close()
 
-	// проверяет достаточно ли ингридентов
-	const notEnoughInventsQ string = `
-	SELECT id, name, ABS(garbage) AS not_enough
+	// снятое вручную (86) и вне расписания (завтраки, сезон) не продаётся.
+	// A pre-order is checked at its pickup time
+	availableStmt, err := tx.Preparex(`
+	SELECT available, menu_item_on_schedule(id, store_time(COALESCE($2::timestamptz, NOW()))) AS on_schedule
+		FROM menu_items
+		WHERE id = $1`)
+	if err != nil {
+		return err
+	}
+	defer availableStmt.Close()
+
+	// размер: имя и множитель рецепта, его цену берёт price_at
+	variantStmt, err := tx.Preparex(`
+	SELECT name, scale
+		FROM menu_item_variants
+		WHERE id = $1 AND product_id = $2`)
+	if err != nil {
+		return err
+	}
+	defer variantStmt.Close()
+
+	// части набора с вариантами выбора, у обычного продукта их нет
+	componentsStmt, err := tx.Preparex(`
+	SELECT c.id, c.name, c.quantity,
+			array_remove(array_agg(ch.product_id ORDER BY ch.product_id), NULL) AS choices
+		FROM bundle_components AS c
+		LEFT JOIN bundle_component_choices AS ch ON ch.component_id = c.id
+		WHERE c.bundle_id = $1
+		GROUP BY c.id
+		ORDER BY c.id`)
+	if err != nil {
+		return err
+	}
+	defer componentsStmt.Close()
+
+	productStmt, err := tx.Preparex(`
+	SELECT name, price_at(id, NULL, COALESCE($2::timestamptz, NOW())) AS price, allergens,
+			available AND menu_item_on_schedule(id, store_time(COALESCE($2::timestamptz, NOW()))) AS available
+		FROM menu_items
+		WHERE id = $1`)
+	if err != nil {
+		return err
+	}
+	defer productStmt.Close()
+
+	// все группы модификаторов продукта, чтобы проверить min/max
+	groupsStmt, err := tx.Preparex(`
+	SELECT id, name, min_select, max_select
+		FROM modifier_groups
+		WHERE product_id = $1`)
+	if err != nil {
+		return err
+	}
+	defer groupsStmt.Close()
+
+	// только выбранные опции, которые принадлежат этому продукту
+	optionsStmt, err := tx.Preparex(`
+	SELECT o.id, o.group_id, o.name, o.price_delta, o.add_allergens, o.remove_allergens
+		FROM modifier_options AS o
+		JOIN modifier_groups AS g ON g.id = o.group_id
+		WHERE g.product_id = $1 AND o.id = ANY($2)`)
+	if err != nil {
+		return err
+	}
+	defer optionsStmt.Close()
+
+	// сколько каждого ингредиента нужно на строку: рецепт (умноженный на scale размера)
+	// плюс дельты выбранных опций. Хватает ли, решает recipeTree: заготовки, которых нет
+	// на полке, делаются из своего рецепта.
+	// $1 это продукты, чьи рецепты берутся: сам продукт или выбранные части набора
+	const needQ string = `
+	SELECT inventory_id AS id, SUM(quantity) * $2 AS quantity_used
 		FROM (
-  			SELECT 
-				inv.id,
-				inv.name,
-				inv.quantity - ings.quantity * $2 AS garbage
-  			FROM 
-				inventory inv
-  			JOIN 
-    			menu_item_ingredients ings ON inv.id = ings.inventory_id
-  			WHERE 
-    			ings.product_id = $1
-		) sub
-	WHERE 
-		garbage < 0`
-	// SELECT id AS inventory_id, ABS(garbage) AS not_enough FROM (SELECT inv.id, inv.quantity - ings.quantity * $2 AS garbage FROM inventory inv JOIN menu_item_ingredients ings ON inv.id = ings.inventory_id WHERE ings.product_id = $1) sub WHERE garbage < 0;
+			SELECT mi.inventory_id, mi.quantity * $4 AS quantity
+				FROM menu_item_ingredients AS mi
+				JOIN unnest($1::int[]) AS recipe (product_id) ON recipe.product_id = mi.product_id
+			UNION ALL
+			SELECT inventory_id, quantity FROM modifier_option_ingredients WHERE option_id = ANY($3)
+		) AS parts
+		GROUP BY inventory_id
+		HAVING SUM(quantity) > 0`
 
-	stmt2, err := tx.Preparex(notEnoughInventsQ)
+	needStmt, err := tx.Preparex(needQ)
 	if err != nil {
 		return err
 	}
-	defer stmt2.Close()
+	defer needStmt.Close()
+
+	tree, err := newRecipeTree(tx)
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
 
 	// ВСтавляет запись на order_items (Если до этого все items существует и ингридиенты достаточно)
-	stmt3, err := tx.Prepare(`INSERT INTO order_items VALUES($1, $2, $3)`)
+	// с копией имени и цены из меню, чтобы изменение меню не меняло старые заказы.
+	// Скидка (happy hour) берётся с цены меню или размера, модификаторы по полной цене.
+	// Цена и скидка на время заказа, как и доступность: pickup_at у предзаказа, иначе сейчас.
+	// Запланированная цена, чьё время пришло, берётся через price_at, меню не меняется.
+	// Ставка налога: своей категории, иначе общая
+	const insertItemQ string = `
+	INSERT INTO order_items (order_id, product_id, quantity, name, unit_price, variant_id, variant_name, price_rule, tax_rate, allergens)
+		SELECT $1, m.id, $3, m.name,
+				GREATEST(ROUND(price_at(m.id, $6, COALESCE($5::timestamptz, NOW())) * (100 - COALESCE(r.percent_off, 0)) / 100, 2) + $4, 0),
+				$6, $7, COALESCE(r.rule_name, ''),
+				COALESCE(
+					(SELECT rate FROM tax_rates WHERE category_id = m.category_id),
+					(SELECT rate FROM tax_rates WHERE category_id IS NULL), 0),
+				$8
+		FROM menu_items AS m
+		LEFT JOIN LATERAL best_price_rule(m.id, store_time(COALESCE($5::timestamptz, NOW()))) AS r ON TRUE
+		WHERE m.id = $2
+	RETURNING id, name, unit_price, line_total, price_rule, tax_rate`
+	insertStmt, err := tx.Preparex(insertItemQ)
 	if err != nil {
 		return err
 	}
-	defer stmt3.Close()
+	defer insertStmt.Close()
 
-	// получить отчеть ингридиентов на каждый меню items
-	const remaining string = `
-	SELECT 
-		inv.id,
-		inv.name,
-		ings.quantity * $2 AS quantity_used,
-		inv.quantity - ings.quantity * $2 AS remaining
-	FROM 
-		inventory inv
-	JOIN 
-		menu_item_ingredients ings ON inv.id = ings.inventory_id
-	WHERE 
-		ings.product_id = $1`
+	modifierStmt, err := tx.Prepare(`
+	INSERT INTO order_item_modifiers (item_id, option_id, name, price_delta)
+	VALUES ($1, $2, $3, $4)`)
+	if err != nil {
+		return err
+	}
+	defer modifierStmt.Close()
 
-	stmt4, err := tx.Preparex(remaining)
+	componentStmt, err := tx.Prepare(`
+	INSERT INTO order_item_components (item_id, component_id, product_id, name, quantity, share)
+	VALUES ($1, $2, $3, $4, $5, $6)`)
 	if err != nil {
 		return err
 	}
-	defer stmt4.Close()
+	defer componentStmt.Close()
 
-	// minus inventory for every menu item
-	const minusInvCycle string = `
-	UPDATE inventory AS inv
-	SET quantity = inv.quantity - (mi.quantity * $2)
-	FROM menu_item_ingredients AS mi
-	JOIN menu_items AS m ON mi.product_id = m.id
-	WHERE mi.inventory_id = inv.id
-  	AND m.id = $1;`
+	// что строка реально потратила, по этому потом возвращается склад
+	usedStmt, err := tx.Prepare(`
+	INSERT INTO order_item_ingredients (item_id, inventory_id, quantity)
+	VALUES ($1, $2, $3)`)
+	if err != nil {
+		return err
+	}
+	defer usedStmt.Close()
 
-	stmt5, err := tx.Prepare(minusInvCycle)
+	minusStmt, err := tx.Prepare(`UPDATE inventory SET quantity = quantity - $1 WHERE id = $2`)
 	if err != nil {
 		return err
 	}
-	defer stmt5.Close()
+	defer minusStmt.Close()
 
-	var wasError, notFound, foundAllergen bool
+	var wasError, notFound, unavailable, foundAllergen, badChoice bool
 	var invsTemp []models.InventoryUpdate
 	for i, item := range ord.Items {
-		var hasInMenu, notEnough bool
+		var delta float64
+		var needs []models.InventoryUpdate
+		var recipe pq.Int64Array
+		var prices []float64
+		var variant struct {
+			Name  string  `db:"name"`
+			Scale float64 `db:"scale"`
+		}
+		variant.Scale = 1
+		optionIDs := make(pq.Int64Array, len(item.Modifiers))
+		for j, mod := range item.Modifiers {
+			optionIDs[j] = int64(mod.OptionID)
+		}
+
 		if err = stmt.Get(&ord.Items[i].Allergens, item.ProductID); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				ord.Items[i].Warning = "not found in menu"
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			ord.Items[i].Warning = "not found in menu"
+			wasError = true
+			notFound = true
+			continue
+		}
+		var onSale struct {
+			Available  bool `db:"available"`
+			OnSchedule bool `db:"on_schedule"`
+		}
+		if err = availableStmt.Get(&onSale, item.ProductID, ord.PickupAt); err != nil {
+			return err
+		}
+		if !onSale.Available || !onSale.OnSchedule {
+			ord.Items[i].Warning = "taken off the menu"
+			if onSale.Available {
+				ord.Items[i].Warning = "not available at this time"
+			}
+			wasError = true
+			unavailable = true
+			continue
+		}
+		if item.VariantID != nil {
+			if err = variantStmt.Get(&variant, *item.VariantID, item.ProductID); errors.Is(err, sql.ErrNoRows) {
+				ord.Items[i].Warning = "variant not found"
 				wasError = true
 				notFound = true
+				continue
+			} else if err != nil {
+				return err
+			}
+			ord.Items[i].VariantName = variant.Name
+		}
+		if recipe, prices, err = db.componentsOf(componentsStmt, productStmt, &ord.Items[i], ord.PickupAt); err == nil {
+			delta, err = db.modifiersOf(groupsStmt, optionsStmt, &ord.Items[i])
+		}
+		if err != nil {
+			if errors.Is(err, models.ErrNotFoundItems) {
+				notFound = true
+			} else if errors.Is(err, models.ErrUnavailableItems) {
+				unavailable = true
+			} else if errors.Is(err, models.ErrBadInputItems) {
+				badChoice = true
 			} else {
 				return err
 			}
-		} else if db.checkAllergens(ord.Allergens, &ord.Items[i].Allergens); len(ord.Items[i].Allergens) != 0 {
+			wasError = true
+			continue
+		}
+		allergens := slices.Clone(ord.Items[i].Allergens) // checkAllergens leaves only the customer's ones
+		if db.checkAllergens(ord.Allergens, &ord.Items[i].Allergens); len(ord.Items[i].Allergens) != 0 {
 			ord.Items[i].Warning = "found allergen"
 			wasError = true
 			foundAllergen = true
-		} else if err = stmt2.Select(&ord.Items[i].NotEnoungIngs, item.ProductID, item.Quantity); err != nil {
+			continue
+		}
+		if err = needStmt.Select(&needs, recipe, item.Quantity, optionIDs, variant.Scale); err != nil {
+			return err
+		}
+		if needs, ord.Items[i].NotEnoungIngs, err = tree.take(needs); err != nil {
 			return err
-		} else if len(ord.Items[i].NotEnoungIngs) != 0 {
+		}
+		if len(ord.Items[i].NotEnoungIngs) != 0 {
+			// не хватает даже на одну штуку с этим размером и опциями, значит sold out (409),
+			// иначе просто меньше чем просят (424)
+			var one []models.InventoryUpdate
+			var n uint64
+			if err = needStmt.Select(&one, recipe, 1, optionIDs, variant.Scale); err != nil {
+				return err
+			}
+			if n, _, err = tree.available(one); err != nil {
+				return err
+			}
 			ord.Items[i].Warning = "not enough in inventory"
+			if n == 0 {
+				ord.Items[i].Warning = "sold out"
+				unavailable = true
+			}
 			wasError = true
-			notEnough = true
-		} else if !wasError { // insert to order_items
-			_, err = stmt3.Exec(ord.ID, item.ProductID, item.Quantity)
-			if err != nil {
+			continue
+		}
+		if wasError {
+			continue
+		}
+
+		// insert to order_items
+		if ord.Items[i].Allergens = allergens; allergens == nil {
+			ord.Items[i].Allergens = pq.StringArray{}
+		}
+		err = insertStmt.QueryRowx(ord.ID, item.ProductID, item.Quantity, delta, ord.PickupAt, item.VariantID, variant.Name, ord.Items[i].Allergens).
+			Scan(&ord.Items[i].ID, &ord.Items[i].Name, &ord.Items[i].UnitPrice, &ord.Items[i].LineTotal, &ord.Items[i].PriceRule, &ord.Items[i].TaxRate)
+		if err != nil {
+			return err
+		}
+		for _, mod := range ord.Items[i].Modifiers {
+			if _, err = modifierStmt.Exec(ord.Items[i].ID, mod.OptionID, mod.Name, *mod.PriceDelta); err != nil {
 				return err
-			} else if invsUpdatesOriginal != nil {
-				// 1 ингридентті 1 тапсырыста 2 меню сол 1еуін қолдануы мүмкін сол үшін керек
-				var invsTempTemp []models.InventoryUpdate
-				err = stmt4.Select(&invsTempTemp, item.ProductID, item.Quantity)
-				if err != nil {
-					return err
-				}
-				db.mergerInv(invsTempTemp, &invsTemp)
 			}
 		}
-
-		if hasInMenu && !notEnough {
-			_, err = stmt5.Exec(item.ProductID, item.Quantity)
+		db.shareOut(*ord.Items[i].UnitPrice, prices, ord.Items[i].Components)
+		for _, comp := range ord.Items[i].Components {
+			_, err = componentStmt.Exec(ord.Items[i].ID, comp.ComponentID, comp.ProductID, comp.Name, comp.Quantity, *comp.Share)
 			if err != nil {
 				return err
 			}
 		}
+		// only inserted items take from inventory, the next items are checked against what is left
+		for _, need := range needs {
+			if _, err = usedStmt.Exec(ord.Items[i].ID, need.InventoryID, need.QuantityUsed); err != nil {
+				return err
+			}
+			if _, err = minusStmt.Exec(need.QuantityUsed, need.InventoryID); err != nil {
+				return err
+			}
+		}
+		// 1 ингридентті 1 тапсырыста 2 меню сол 1еуін қолдануы мүмкін сол үшін керек
+		db.mergerInv(needs, &invsTemp)
 	}
 	// максимальна клиенттің қатесін басты проритетке аламыз
 	if wasError {
@@ -392,6 +1384,10 @@ func (db *dalOrder) detectorAndInserterOrderItems(tx *sqlx.Tx, ord *models.Order
 			return models.ErrAllergen // 418 (joke)
 		} else if notFound {
 			return models.ErrNotFoundItems // 404
+		} else if unavailable {
+			return models.ErrUnavailableItems // 409
+		} else if badChoice {
+			return models.ErrBadInputItems // 400
 		}
 		return models.ErrOrderNotEnoughItems // 424
 	}
@@ -399,42 +1395,190 @@ func (db *dalOrder) detectorAndInserterOrderItems(tx *sqlx.Tx, ord *models.Order
 	const queryTransaction string = `
 	INSERT INTO inventory_transactions (inventory_id, quantity_change, reason)
 		SELECT 
-			inv.id,
-			(ings.quantity * ord.quantity) AS quantity_change,
+			used.inventory_id,
+			-used.quantity AS quantity_change, -- taken from stock
 			'usage'::reason_of_inventory_transaction
 		FROM 
-			inventory inv
+			order_item_ingredients used
 		JOIN 
-			menu_item_ingredients ings ON inv.id = ings.inventory_id
-		JOIN 
-			order_items ord ON ings.product_id = ord.product_id
+			order_items ord ON ord.id = used.item_id
 		WHERE 
 			ord.order_id = $1`
 	_, err = tx.Exec(queryTransaction, ord.ID)
 	if err != nil {
 		return err
 	}
-	// егер бәрі дұрыс болса ғана жолдайды
+	const totalQ string = `
+	SELECT SUM(line_total)
+	FROM order_items
+	WHERE order_id = $1`
+
+	var subtotal float64
+	err = tx.Get(&subtotal, totalQ, ord.ID)
+	if err != nil {
+		return err
+	}
+	discount, err := db.applyPromo(tx, ord, subtotal)
+	if err != nil {
+		return err
+	}
+	pointsOff, err := db.applyPoints(tx, ord, subtotal-discount)
+	if err != nil {
+		return err
+	}
+	// егер бәрі дұрыс болса ғана жолдайды: an order rejected by its promo code or points
+	// is rolled back, its usage must not be in the summary
 	if invsUpdatesOriginal != nil {
 		db.mergerInv(invsTemp, invsUpdatesOriginal)
 	}
-	const totalQ string = `
-	SELECT SUM(m.price * o.quantity)
-	FROM menu_items AS m
-	JOIN order_items AS o ON m.id = o.product_id
-	WHERE o.order_id = $1`
 
-	ord.Total = new(float64)
-	err = tx.Get(ord.Total, totalQ, ord.ID)
-	if err != nil {
+	// налог строки с того, что осталось после её доли скидки: внутри цены или сверху
+	const taxQ string = `
+	UPDATE order_items AS oi
+	SET tax = ROUND((oi.line_total - oi.discount) * oi.tax_rate /
+			CASE WHEN s.prices_include_tax THEN 100 + oi.tax_rate ELSE 100 END, 2)
+	FROM tax_settings AS s
+	WHERE oi.order_id = $1`
+	if _, err = tx.Exec(taxQ, ord.ID); err != nil {
 		return err
 	}
 
 	const orderUpdateTotal string = `
-	UPDATE orders
-	SET total = $1
-	WHERE id = $2`
-	_, err = tx.Exec(orderUpdateTotal, *ord.Total, ord.ID)
+	UPDATE orders AS o
+	SET subtotal = t.subtotal, discount = $2, promo_code = $3, tax = t.tax,
+		redeem_points = $4, points_off = $5,
+		tax_inclusive = s.prices_include_tax,
+		total = t.subtotal - $2 + CASE WHEN s.prices_include_tax THEN 0 ELSE t.tax END
+	FROM (SELECT SUM(line_total) AS subtotal, SUM(tax) AS tax FROM order_items WHERE order_id = $1) AS t,
+		tax_settings AS s
+	WHERE o.id = $1
+	RETURNING o.subtotal, o.discount, o.points_off, o.tax, o.tax_inclusive, o.total`
+	ord.Subtotal, ord.Discount, ord.Tax, ord.Total = new(float64), new(float64), new(float64), new(float64)
+	ord.PointsOff = new(float64)
+	return tx.QueryRow(orderUpdateTotal, ord.ID, discount+pointsOff, ord.PromoCode, ord.RedeemPoints, pointsOff).
+		Scan(ord.Subtotal, ord.Discount, ord.PointsOff, ord.Tax, &ord.TaxInclusive, ord.Total)
+}
+
+// applyPromo checks ord.PromoCode against the inserted lines and saves its redemption.
+// The discount is a percent of the eligible lines or the fixed amount, not more than them
+func (db *dalOrder) applyPromo(tx *sqlx.Tx, ord *models.Order, subtotal float64) (float64, error) {
+	if len(ord.PromoCode) == 0 {
+		return 0, nil
+	}
+	var promo models.PromoCode
+	err := tx.Get(&promo, `SELECT * FROM promo_codes WHERE code = $1`, ord.PromoCode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: unknown %s", models.ErrPromoCode, ord.PromoCode)
+	} else if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	switch {
+	case !*promo.Active:
+		return 0, fmt.Errorf("%w: %s is turned off", models.ErrPromoCode, promo.Code)
+	case promo.StartsAt != nil && now.Before(*promo.StartsAt):
+		return 0, fmt.Errorf("%w: %s starts at %s", models.ErrPromoCode, promo.Code, promo.StartsAt.Format(time.RFC3339))
+	case promo.EndsAt != nil && !now.Before(*promo.EndsAt):
+		return 0, fmt.Errorf("%w: %s is expired", models.ErrPromoCode, promo.Code)
+	case subtotal < promo.MinSpend:
+		return 0, fmt.Errorf("%w: %s is for orders from %.2f", models.ErrPromoCode, promo.Code, promo.MinSpend)
+	case promo.MaxUsesPerCustomer != nil && ord.CustomerID == nil:
+		return 0, fmt.Errorf("%w: %s is limited per customer, it needs customer_id", models.ErrPromoCode, promo.Code)
+	}
+
+	var lines []struct {
+		ID        uint64  `db:"id"`
+		LineTotal float64 `db:"line_total"`
+	}
+	err = tx.Select(&lines, `
+	SELECT oi.id, oi.line_total
+		FROM order_items AS oi
+		JOIN menu_items AS m ON m.id = oi.product_id
+		WHERE oi.order_id = $1 AND oi.line_total > 0 AND (
+			(cardinality($2::int[]) = 0 AND cardinality($3::text[]) = 0) OR
+			oi.product_id = ANY($2) OR m.tags && $3)
+		ORDER BY oi.id`, ord.ID, promo.ProductIDs, promo.Tags)
+	if err != nil {
+		return 0, err
+	}
+	var eligible float64
+	for _, line := range lines {
+		eligible += line.LineTotal
+	}
+	if eligible == 0 {
+		return 0, fmt.Errorf("%w: no items of %s in the order", models.ErrPromoCode, promo.Code)
+	}
+	discount := min(promo.Value, eligible)
+	if promo.Kind == "percent" {
+		discount = math.Round(eligible*promo.Value) / 100
+	}
+
+	// the discount is shared by the eligible lines by their totals, tax is taken from the rest
+	totals := make([]float64, len(lines))
+	for i, line := range lines {
+		totals[i] = line.LineTotal
+	}
+	for i, share := range shareCents(discount, totals) {
+		if _, err = tx.Exec(`UPDATE order_items SET discount = $1 WHERE id = $2`, share, lines[i].ID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = db.claimPromo(tx, &promo, ord.CustomerID); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO promo_redemptions (promo_id, order_id, customer_id, customer_name, discount)
+	VALUES ($1, $2, $3, $4, $5)`, promo.ID, ord.ID, ord.CustomerID, ord.CustomerName, discount)
+	return discount, err
+}
+
+// claimPromo takes a use of the code and of its customer. The UPDATE waits for an order
+// taking the same code at once and checks the committed count; in a REPEATABLE READ order
+// that one's commit is a serialization failure (40001) and the order is run again
+func (db *dalOrder) claimPromo(tx *sqlx.Tx, promo *models.PromoCode, customerID *uint64) error {
+	result, err := tx.Exec(`
+	UPDATE promo_codes SET uses = uses + 1
+		WHERE id = $1 AND (max_uses IS NULL OR uses < max_uses)`, promo.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %s is used up", models.ErrPromoCode, promo.Code)
+	}
+	if customerID == nil {
+		return nil
+	}
+
+	result, err = tx.Exec(`
+	INSERT INTO promo_customer_uses (promo_id, customer_id, uses)
+	VALUES ($1, $2, 1)
+	ON CONFLICT (promo_id, customer_id) DO UPDATE SET uses = promo_customer_uses.uses + 1
+		WHERE $3::INT IS NULL OR promo_customer_uses.uses < $3`, promo.ID, *customerID, promo.MaxUsesPerCustomer)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %s is already used by customer %d", models.ErrPromoCode, promo.Code, *customerID)
+	}
+	return nil
+}
+
+// releasePromo gives back the use of the order's code: the order is cancelled, deleted or checked again
+func (db *dalOrder) releasePromo(tx *sqlx.Tx, orderID uint64) error {
+	_, err := tx.Exec(`
+	WITH r AS (
+		SELECT promo_id, customer_id FROM promo_redemptions WHERE order_id = $1
+	), code AS (
+		UPDATE promo_codes AS p SET uses = p.uses - 1 FROM r WHERE p.id = r.promo_id
+	)
+	UPDATE promo_customer_uses AS u SET uses = u.uses - 1
+		FROM r
+		WHERE u.promo_id = r.promo_id AND u.customer_id = r.customer_id`, orderID)
 	return err
 }
 
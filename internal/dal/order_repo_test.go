@@ -0,0 +1,45 @@
+package dal
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestShareCents(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   float64
+		weights []float64
+		want    []float64
+	}{
+		{"no weights", 5, nil, []float64{}},
+		{"exact", 10, []float64{6, 4}, []float64{6, 4}},
+		{"thirds, first gets the cent", 10, []float64{1, 1, 1}, []float64{3.34, 3.33, 3.33}},
+		{"biggest remainder gets the cent", 5, []float64{4, 3}, []float64{2.86, 2.14}},
+		{"bundle cheaper than its parts", 6.5, []float64{3.5, 2.25, 2.25}, []float64{2.84, 1.83, 1.83}},
+		{"zero weights are equal", 1, []float64{0, 0}, []float64{0.5, 0.5}},
+		{"negative weight is zero", 1, []float64{-1, 1}, []float64{0, 1}},
+		{"zero total", 0, []float64{2, 3}, []float64{0, 0}},
+		{"negative total is zero", -3, []float64{2, 3}, []float64{0, 0}},
+		{"float total", 0.3, []float64{0.1, 0.2}, []float64{0.1, 0.2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shareCents(tt.total, tt.weights)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("shareCents(%v, %v) = %v, want %v", tt.total, tt.weights, got, tt.want)
+			}
+			if len(tt.weights) == 0 || tt.total <= 0 {
+				return
+			}
+			var sum float64
+			for _, share := range got {
+				sum += share
+			}
+			if math.Round(sum*100) != math.Round(tt.total*100) {
+				t.Errorf("shares sum up to %v, want %v", sum, tt.total)
+			}
+		})
+	}
+}
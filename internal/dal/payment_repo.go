@@ -0,0 +1,150 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type dalPayment struct {
+	db *sqlx.DB
+}
+
+type PaymentDalInter interface {
+	SelectPayments(orderID uint64) (*models.OrderPayments, error)
+	InsertPayment(*models.Payment) error
+	VoidPayment(orderID, paymentID uint64) error
+}
+
+func ReturnDalPayment(db *sqlx.DB) PaymentDalInter {
+	return &dalPayment{db: db}
+}
+
+// orderPaidQ: the order with what is paid and due by payments which are not voided
+const orderPaidQ string = `
+	SELECT o.id, o.status, o.total, p.paid, o.total - p.paid AS due
+		FROM orders AS o
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(amount), 0) AS paid
+				FROM payments
+				WHERE order_id = o.id AND voided_at IS NULL
+		) AS p
+		WHERE o.id = $1`
+
+// lockOrderPaid locks the order row, so payments, close and edit of the order go one by one
+func lockOrderPaid(tx *sqlx.Tx, id uint64) (*models.OrderPayments, error) {
+	if _, err := tx.Exec(`SELECT 1 FROM orders WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return nil, err
+	}
+	var order models.OrderPayments
+	err := tx.Get(&order, orderPaidQ, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNotFound
+	}
+	return &order, err
+}
+
+func (core *dalPayment) SelectPayments(orderID uint64) (*models.OrderPayments, error) {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	if err != nil {
+		return nil, err
+	}
+
+	var order models.OrderPayments
+	err = tx.Get(&order, orderPaidQ, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	order.Payments = []models.Payment{}
+	err = tx.Select(&order.Payments, `SELECT * FROM payments WHERE order_id = $1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return &order, tx.Commit()
+}
+
+// InsertPayment pays a part of what is due of a processing order.
+// Amount 0 is all that is due, card and voucher are tendered exactly
+func (core *dalPayment) InsertPayment(pay *models.Payment) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order, err := lockOrderPaid(tx, pay.OrderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != "processing" {
+		return fmt.Errorf("%w : %s", models.ErrOrderStatusClosed, order.Status)
+	}
+	due := math.Round(order.Due*100) / 100
+	if due <= 0 {
+		return fmt.Errorf("%w : order %d is already paid", models.ErrConflict, pay.OrderID)
+	}
+	if pay.Amount == 0 {
+		pay.Amount = due
+	}
+	if pay.Amount > due {
+		return fmt.Errorf("%w : amount %.2f is more than due %.2f", models.ErrBadInput, pay.Amount, due)
+	}
+	if pay.Method != "cash" || pay.Tendered == 0 {
+		pay.Tendered = pay.Amount
+	}
+	if pay.Tendered < pay.Amount {
+		return fmt.Errorf("%w : tendered %.2f is less than amount %.2f", models.ErrBadInput, pay.Tendered, pay.Amount)
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO payments (order_id, method, amount, tendered, reference)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, change_due, created_at`,
+		pay.OrderID, pay.Method, pay.Amount, pay.Tendered, pay.Reference).Scan(&pay.ID, &pay.Change, &pay.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VoidPayment takes a payment back while the order is not closed
+func (core *dalPayment) VoidPayment(orderID, paymentID uint64) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order, err := lockOrderPaid(tx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != "processing" {
+		return fmt.Errorf("%w : %s", models.ErrOrderStatusClosed, order.Status)
+	}
+	result, err := tx.Exec(`
+	UPDATE payments
+		SET voided_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND order_id = $2 AND voided_at IS NULL`, paymentID, orderID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w : payment %d of order %d", models.ErrNotFound, paymentID, orderID)
+	}
+	return tx.Commit()
+}
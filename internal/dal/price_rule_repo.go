@@ -0,0 +1,105 @@
+package dal
+
+import (
+	"database/sql"
+	"fmt"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type dalPriceRule struct {
+	db *sqlx.DB
+}
+
+type PriceRuleDalInter interface {
+	SelectAllPriceRules() ([]models.PriceRule, error)
+	SelectPriceRule(uint64) (*models.PriceRule, error)
+	InsertPriceRule(*models.PriceRule) error
+	UpdatePriceRule(*models.PriceRule) error
+	DeletePriceRule(uint64) error
+}
+
+func ReturnDalPriceRule(db *sqlx.DB) PriceRuleDalInter {
+	return &dalPriceRule{db: db}
+}
+
+const selectPriceRuleQ string = `
+	SELECT id, name, product_id, category_id, percent_off, active, ` + windowColumns + `
+		FROM price_rules`
+
+func (core *dalPriceRule) SelectAllPriceRules() ([]models.PriceRule, error) {
+	rules := []models.PriceRule{}
+	err := core.db.Select(&rules, selectPriceRuleQ+` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (core *dalPriceRule) SelectPriceRule(id uint64) (*models.PriceRule, error) {
+	var rule models.PriceRule
+	err := core.db.Get(&rule, selectPriceRuleQ+` WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return &rule, err
+}
+
+func (core *dalPriceRule) InsertPriceRule(rule *models.PriceRule) error {
+	err := core.db.QueryRow(`
+	INSERT INTO price_rules (name, product_id, category_id, percent_off, active,
+			weekdays, start_time, end_time, start_date, end_date)
+	VALUES ($1, $2, $3, $4, $5, $6, $7::time, $8::time, $9::date, $10::date)
+	RETURNING id`,
+		rule.Name, rule.ProductID, rule.CategoryID, rule.PercentOff, rule.Active,
+		rule.Weekdays, rule.StartTime, rule.EndTime, rule.StartDate, rule.EndDate).Scan(&rule.ID)
+	return core.pqErr(err)
+}
+
+func (core *dalPriceRule) UpdatePriceRule(rule *models.PriceRule) error {
+	result, err := core.db.Exec(`
+	UPDATE price_rules
+		SET name = $2, product_id = $3, category_id = $4, percent_off = $5, active = $6,
+			weekdays = $7, start_time = $8::time, end_time = $9::time, start_date = $10::date, end_date = $11::date
+		WHERE id = $1`,
+		rule.ID, rule.Name, rule.ProductID, rule.CategoryID, rule.PercentOff, rule.Active,
+		rule.Weekdays, rule.StartTime, rule.EndTime, rule.StartDate, rule.EndDate)
+	if err != nil {
+		return core.pqErr(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (core *dalPriceRule) DeletePriceRule(id uint64) error {
+	result, err := core.db.Exec(`DELETE FROM price_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// pqErr: the same name is a conflict, unknown product or category is not found
+func (core *dalPriceRule) pqErr(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23505": // unique
+			return models.ErrConflict
+		case "23503": // foreign key
+			return fmt.Errorf("%w : product or category of the rule", models.ErrNotFound)
+		}
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package dal
+
+import (
+	"database/sql"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type dalPromo struct {
+	db *sqlx.DB
+}
+
+type PromoDalInter interface {
+	SelectAllPromos() ([]models.PromoCode, error)
+	SelectPromo(uint64) (*models.PromoCode, error)
+	InsertPromo(*models.PromoCode) error
+	UpdatePromo(*models.PromoCode) error
+	DeletePromo(uint64) error
+	SelectRedemptions(uint64) ([]models.PromoRedemption, error)
+}
+
+func ReturnDalPromo(db *sqlx.DB) PromoDalInter {
+	return &dalPromo{db: db}
+}
+
+// selectPromoQ: codes with their uses and discounts, cancelled orders are not counted
+const selectPromoQ string = `
+	SELECT p.*, u.discount_total
+		FROM promo_codes AS p
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(r.discount), 0) AS discount_total
+				FROM promo_redemptions AS r
+				JOIN orders AS o ON o.id = r.order_id
+				WHERE r.promo_id = p.id AND o.status <> 'cancelled'
+		) AS u`
+
+func (core *dalPromo) SelectAllPromos() ([]models.PromoCode, error) {
+	promos := []models.PromoCode{}
+	err := core.db.Select(&promos, selectPromoQ+` ORDER BY p.id`)
+	if err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+func (core *dalPromo) SelectPromo(id uint64) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	err := core.db.Get(&promo, selectPromoQ+` WHERE p.id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return &promo, err
+}
+
+func (core *dalPromo) InsertPromo(promo *models.PromoCode) error {
+	err := core.db.QueryRow(`
+	INSERT INTO promo_codes (code, description, kind, value, min_spend, product_ids, tags,
+			starts_at, ends_at, max_uses, max_uses_per_customer, active)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	RETURNING id, created_at`,
+		promo.Code, promo.Description, promo.Kind, promo.Value, promo.MinSpend, promo.ProductIDs, promo.Tags,
+		promo.StartsAt, promo.EndsAt, promo.MaxUses, promo.MaxUsesPerCustomer, promo.Active).
+		Scan(&promo.ID, &promo.CreatedAt)
+	return core.pqErr(err)
+}
+
+// UpdatePromo changes the code for the next orders, redemptions keep their discounts
+func (core *dalPromo) UpdatePromo(promo *models.PromoCode) error {
+	err := core.db.QueryRow(`
+	UPDATE promo_codes
+		SET code = $2, description = $3, kind = $4, value = $5, min_spend = $6, product_ids = $7, tags = $8,
+			starts_at = $9, ends_at = $10, max_uses = $11, max_uses_per_customer = $12, active = $13
+		WHERE id = $1
+	RETURNING created_at`,
+		promo.ID, promo.Code, promo.Description, promo.Kind, promo.Value, promo.MinSpend, promo.ProductIDs, promo.Tags,
+		promo.StartsAt, promo.EndsAt, promo.MaxUses, promo.MaxUsesPerCustomer, promo.Active).
+		Scan(&promo.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.ErrNotFound
+	}
+	return core.pqErr(err)
+}
+
+func (core *dalPromo) DeletePromo(id uint64) error {
+	result, err := core.db.Exec(`DELETE FROM promo_codes WHERE id = $1`, id)
+	if err != nil {
+		return core.pqErr(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// SelectRedemptions: orders that used the code, the last first
+func (core *dalPromo) SelectRedemptions(id uint64) ([]models.PromoRedemption, error) {
+	var exists bool
+	if err := core.db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM promo_codes WHERE id = $1)`, id); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, models.ErrNotFound
+	}
+	redemptions := []models.PromoRedemption{}
+	err := core.db.Select(&redemptions, `
+	SELECT r.id, r.promo_id, r.order_id, r.customer_id, r.customer_name, o.status, o.subtotal, r.discount, r.created_at
+		FROM promo_redemptions AS r
+		JOIN orders AS o ON o.id = r.order_id
+		WHERE r.promo_id = $1
+		ORDER BY r.id DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	return redemptions, nil
+}
+
+// pqErr: the same code is a conflict, a used code can not be deleted
+func (core *dalPromo) pqErr(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23505": // unique
+			return models.ErrConflict
+		case "23503": // foreign key of promo_redemptions
+			return models.ErrConflict
+		}
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// refundLine is an order line with what was paid for it and what is already refunded
+type refundLine struct {
+	ID             uint64  `db:"id"`
+	Quantity       uint64  `db:"quantity"`
+	Paid           float64 `db:"paid"` // line_total - discount (+ tax if it was on top)
+	Tax            float64 `db:"tax"`
+	Refunded       uint64  `db:"refunded"`
+	RefundedAmount float64 `db:"refunded_amount"`
+	RefundedTax    float64 `db:"refunded_tax"`
+}
+
+// refund is what quantity more units of the line give back, by their part of what was paid.
+// The last units get what is left, so rounding does not lose cents. The line counts them
+// as refunded, so the same line twice in a refund is split right
+func (line *refundLine) refund(quantity uint64) (amount, tax float64) {
+	if quantity == line.Quantity-line.Refunded {
+		amount = math.Round((line.Paid-line.RefundedAmount)*100) / 100
+		tax = math.Round((line.Tax-line.RefundedTax)*100) / 100
+	} else {
+		part := float64(quantity) / float64(line.Quantity)
+		amount = math.Round(line.Paid*part*100) / 100
+		tax = math.Round(line.Tax*part*100) / 100
+	}
+	line.Refunded += quantity
+	line.RefundedAmount += amount
+	line.RefundedTax += tax
+	return amount, tax
+}
+
+// RefundOrder gives back money for some units of the order lines (all that is left without items).
+// With restock their ingredients go back to inventory, loyalty points are taken back by the same part.
+// When every unit is refunded the order becomes refunded
+func (db *dalOrder) RefundOrder(ref *models.Refund, from []string) error {
+	tx, err := db.database.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = db.getStatusFrom(tx, ref.OrderID, from); err != nil {
+		return err
+	}
+
+	var lines []refundLine
+	err = tx.Select(&lines, `
+	SELECT oi.id, oi.quantity, oi.tax,
+			oi.line_total - oi.discount + CASE WHEN o.tax_inclusive THEN 0 ELSE oi.tax END AS paid,
+			COALESCE(SUM(ri.quantity), 0) AS refunded,
+			COALESCE(SUM(ri.amount), 0) AS refunded_amount,
+			COALESCE(SUM(ri.tax), 0) AS refunded_tax
+		FROM order_items AS oi
+		JOIN orders AS o ON o.id = oi.order_id
+		LEFT JOIN refund_items AS ri ON ri.item_id = oi.id
+		WHERE oi.order_id = $1
+		GROUP BY oi.id, o.tax_inclusive
+		ORDER BY oi.id`, ref.OrderID)
+	if err != nil {
+		return err
+	}
+	index := make(map[uint64]int, len(lines))
+	var paid float64
+	for i, line := range lines {
+		index[line.ID] = i
+		paid += line.Paid
+	}
+	if len(ref.Items) == 0 {
+		for _, line := range lines {
+			if line.Quantity > line.Refunded {
+				ref.Items = append(ref.Items, models.RefundItem{ItemID: line.ID, Quantity: line.Quantity - line.Refunded})
+			}
+		}
+		if len(ref.Items) == 0 {
+			return fmt.Errorf("%w : order %d is already refunded", models.ErrConflict, ref.OrderID)
+		}
+	}
+
+	ref.Amount, ref.Tax = 0, 0
+	for i, item := range ref.Items {
+		j, x := index[item.ItemID]
+		if !x {
+			return fmt.Errorf("%w : item %d is not in order %d", models.ErrNotFound, item.ItemID, ref.OrderID)
+		}
+		line := &lines[j]
+		left := line.Quantity - line.Refunded
+		if item.Quantity > left {
+			return fmt.Errorf("%w : only %d of item %d can be refunded", models.ErrBadInput, left, item.ItemID)
+		}
+		ref.Items[i].Amount, ref.Items[i].Tax = line.refund(item.Quantity)
+		ref.Amount += ref.Items[i].Amount
+		ref.Tax += ref.Items[i].Tax
+	}
+	ref.Amount = math.Round(ref.Amount*100) / 100
+	ref.Tax = math.Round(ref.Tax*100) / 100
+
+	if len(ref.Method) == 0 {
+		err = tx.Get(&ref.Method, `
+		SELECT method
+			FROM payments
+			WHERE order_id = $1 AND voided_at IS NULL
+			ORDER BY amount DESC, id
+			LIMIT 1`, ref.OrderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			ref.Method = "cash"
+		} else if err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO refunds (order_id, method, reason, amount, tax, restock)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`,
+		ref.OrderID, ref.Method, ref.Reason, ref.Amount, ref.Tax, ref.Restock).Scan(&ref.ID, &ref.CreatedAt)
+	if err != nil {
+		return err
+	}
+	for _, item := range ref.Items {
+		_, err = tx.Exec(`
+		INSERT INTO refund_items (refund_id, item_id, quantity, amount, tax)
+		VALUES ($1, $2, $3, $4, $5)`, ref.ID, item.ItemID, item.Quantity, item.Amount, item.Tax)
+		if err != nil {
+			return err
+		}
+		if ref.Restock {
+			if err = db.restockRefund(tx, item.ItemID, item.Quantity, lines[index[item.ItemID]].Quantity); err != nil {
+				return err
+			}
+		}
+	}
+
+	ref.Status, err = db.getStatus(tx, ref.OrderID)
+	if err != nil {
+		return err
+	}
+	refunded := true
+	for _, line := range lines {
+		refunded = refunded && line.Refunded == line.Quantity
+	}
+	if refunded {
+		if err = db.releasePickup(tx, ref.OrderID); err != nil {
+			return err
+		}
+		if err = db.setStatus(tx, ref.OrderID, "refunded"); err != nil {
+			return err
+		}
+		ref.Status = "refunded"
+	}
+	// points of the order are taken back by the refunded part of what was paid, all of them at the end
+	part := 1.0
+	if !refunded && paid > 0 {
+		part = ref.Amount / paid
+	}
+	if err = db.reversePoints(tx, ref.OrderID, part, fmt.Sprintf("refund %d", ref.ID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// restockRefund gives back to inventory the part of what the line took, for quantity of its units
+func (db *dalOrder) restockRefund(tx *sqlx.Tx, itemID, quantity, lineQuantity uint64) error {
+	_, err := tx.Exec(`
+	WITH back AS (
+		SELECT inventory_id, quantity * $2::float / $3::float AS quantity
+			FROM order_item_ingredients
+			WHERE item_id = $1
+	), restocked AS (
+		UPDATE inventory AS inv
+			SET quantity = inv.quantity + back.quantity
+			FROM back
+			WHERE inv.id = back.inventory_id
+	)
+	INSERT INTO inventory_transactions (inventory_id, quantity_change, reason)
+		SELECT inventory_id, quantity, 'refunded'::reason_of_inventory_transaction
+			FROM back`, itemID, quantity, lineQuantity)
+	return err
+}
+
+// SelectRefunds: refunds of the order with their lines, the first first
+func (db *dalOrder) SelectRefunds(orderID uint64) ([]models.Refund, error) {
+	tx, err := db.database.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.getStatus(tx, orderID); err != nil {
+		return nil, err
+	}
+
+	refunds := []models.Refund{}
+	err = tx.Select(&refunds, `
+	SELECT id, order_id, method, reason, restock, amount, tax, created_at
+		FROM refunds
+		WHERE order_id = $1
+		ORDER BY id`, orderID)
+	if err != nil || len(refunds) == 0 {
+		return refunds, err
+	}
+	var items []struct {
+		RefundID uint64 `db:"refund_id"`
+		models.RefundItem
+	}
+	err = tx.Select(&items, `
+	SELECT ri.refund_id, ri.item_id, ri.quantity, ri.amount, ri.tax
+		FROM refund_items AS ri
+		JOIN refunds AS r ON r.id = ri.refund_id
+		WHERE r.order_id = $1
+		ORDER BY ri.refund_id, ri.item_id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[uint64]int, len(refunds))
+	for i, ref := range refunds {
+		index[ref.ID] = i
+	}
+	for _, item := range items {
+		i := index[item.RefundID]
+		refunds[i].Items = append(refunds[i].Items, item.RefundItem)
+	}
+	return refunds, tx.Commit()
+}
@@ -0,0 +1,55 @@
+package dal
+
+import "testing"
+
+func TestRefundLineRefund(t *testing.T) {
+	type part struct {
+		quantity    uint64
+		amount, tax float64
+	}
+	tests := []struct {
+		name  string
+		line  refundLine
+		parts []part // refunded one after another
+	}{
+		{
+			name:  "whole line",
+			line:  refundLine{Quantity: 2, Paid: 10, Tax: 1},
+			parts: []part{{2, 10, 1}},
+		},
+		{
+			name:  "by units, the last gets the rest",
+			line:  refundLine{Quantity: 3, Paid: 10, Tax: 1},
+			parts: []part{{1, 3.33, 0.33}, {1, 3.33, 0.33}, {1, 3.34, 0.34}},
+		},
+		{
+			name:  "part then the rest",
+			line:  refundLine{Quantity: 3, Paid: 10, Tax: 1},
+			parts: []part{{2, 6.67, 0.67}, {1, 3.33, 0.33}},
+		},
+		{
+			name:  "after an earlier refund",
+			line:  refundLine{Quantity: 3, Paid: 10, Tax: 1, Refunded: 1, RefundedAmount: 3.33, RefundedTax: 0.33},
+			parts: []part{{2, 6.67, 0.67}},
+		},
+		{
+			name:  "discounted line",
+			line:  refundLine{Quantity: 4, Paid: 9.99, Tax: 0},
+			parts: []part{{1, 2.5, 0}, {2, 5, 0}, {1, 2.49, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := tt.line
+			for i, p := range tt.parts {
+				amount, tax := line.refund(p.quantity)
+				if amount != p.amount || tax != p.tax {
+					t.Fatalf("part %d: refund(%d) = %v, %v, want %v, %v", i, p.quantity, amount, tax, p.amount, p.tax)
+				}
+			}
+			if line.Refunded != line.Quantity {
+				t.Errorf("refunded %d of %d units", line.Refunded, line.Quantity)
+			}
+		})
+	}
+}
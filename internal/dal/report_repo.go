@@ -1,6 +1,7 @@
 package dal
 
 import (
+	"math"
 	"time"
 
 	"frappuccino/models"
@@ -8,12 +9,42 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// soldStatuses are the order statuses that count as a sale
+const soldStatuses string = `('accepted', 'preparing', 'ready', 'picked_up')`
+
+// revenueStatuses are sold orders and refunded ones, refunds are taken from their revenue
+const revenueStatuses string = `('accepted', 'preparing', 'ready', 'picked_up', 'refunded')`
+
+// netFlows is net revenue (without tax) as it comes: + orders at created_at, - refunds at their time
+const netFlows string = `(
+	SELECT created_at AS at, total - tax AS net FROM orders WHERE status IN ` + revenueStatuses + `
+	UNION ALL
+	SELECT created_at, tax - amount FROM refunds
+)`
+
+// lineName is the name of an order line with its variant: "Cappuccino (Large)"
+const lineName string = `oi.name || COALESCE(' (' || NULLIF(oi.variant_name, '') || ')', '')`
+
+// soldLines are the order lines as products: a bundle line is replaced by its components,
+// each with its share of the bundle price, so sales are credited to real products
+const soldLines string = `(
+	SELECT oi.order_id, oi.product_id, oi.variant_id, ` + lineName + ` AS name,
+			oi.quantity, oi.line_total AS revenue
+		FROM order_items AS oi
+		WHERE NOT EXISTS (SELECT 1 FROM order_item_components AS c WHERE c.item_id = oi.id)
+	UNION ALL
+	SELECT oi.order_id, c.product_id, NULL, c.name,
+			c.quantity * oi.quantity, c.share * oi.quantity
+		FROM order_items AS oi
+		JOIN order_item_components AS c ON c.item_id = oi.id
+)`
+
 type dalAggregation struct {
 	database *sqlx.DB
 }
 
 type AggregationDalInter interface {
-	AmountSales() (float64, error)
+	AmountSales() (*models.TotalSales, error)
 	Popularies() (*models.PopularItems, error)
 	CountOfOrderedItems(start, end *time.Time) (map[string]uint64, error)
 	SearchByWordInventory(ind string, minPrice, maxPrice float64, stc *models.SearchThings) error
@@ -21,30 +52,44 @@ type AggregationDalInter interface {
 	SearchByWordOrder(find string, minPrice, maxPrice float64, strc *models.SearchThings) error
 	PeriodMonth(month time.Month) ([]map[string]uint64, error)
 	PeriodYear(int) ([]map[string]uint64, error)
+	PeriodMonthRevenue(month time.Month) ([]map[string]float64, error)
+	PeriodYearRevenue(year int) ([]map[string]float64, error)
 	GetLeftOversRepo(*models.GetLeftOvers) error
+	LowMargins(*models.LowMargins) error
+	TenderSummary(day string, sum *models.TenderSummary) error
+	CustomerValues(sortBy string, limit uint64) ([]models.CustomerValue, error)
 }
 
 func ReturnDulAggregationDB(db *sqlx.DB) AggregationDalInter {
 	return &dalAggregation{db}
 }
 
-func (db *dalAggregation) AmountSales() (float64, error) {
+func (db *dalAggregation) AmountSales() (*models.TotalSales, error) {
+	const refundAmount string = `(SELECT COALESCE(SUM(amount), 0) FROM refunds)`
+	const refundTax string = `(SELECT COALESCE(SUM(tax), 0) FROM refunds)`
 	const sumTotal string = `
-	SELECT SUM(total)
-	FROM orders
-	WHERE status = 'accepted'`
-	var total float64
-	return total, db.database.Get(&total, sumTotal)
+	SELECT
+		COALESCE(SUM(subtotal) FILTER (WHERE status IN ` + revenueStatuses + `), 0) AS gross_sales,
+		COALESCE(SUM(discount) FILTER (WHERE status IN ` + revenueStatuses + `), 0) AS discounts,
+		COALESCE(SUM(tax) FILTER (WHERE status IN ` + revenueStatuses + `), 0) - ` + refundTax + ` AS tax,
+		` + refundAmount + ` AS refunds,
+		COALESCE(SUM(total - tax) FILTER (WHERE status IN ` + revenueStatuses + `), 0) - ` + refundAmount + ` + ` + refundTax + ` AS net_sales,
+		COALESCE(SUM(total) FILTER (WHERE status IN ` + revenueStatuses + `), 0) - ` + refundAmount + ` AS total_sales,
+		COUNT(*) FILTER (WHERE status = 'cancelled') AS cancelled_orders,
+		COALESCE(SUM(total) FILTER (WHERE status = 'cancelled'), 0) AS cancelled_total
+	FROM orders`
+	var total models.TotalSales
+	return &total, db.database.Get(&total, sumTotal)
 }
 
 func (db *dalAggregation) Popularies() (*models.PopularItems, error) {
 	const popularsQ string = `
-		SELECT oi.product_id, m.name, SUM(oi.quantity) AS sum
-			FROM order_items AS oi
-			JOIN menu_items AS m ON m.id = oi.product_id
-			JOIN orders AS o ON o.id = oi.order_id
-			WHERE o.status = 'accepted'
-			GROUP BY oi.product_id, m.name
+		SELECT l.product_id, l.variant_id, MAX(l.name) AS name,
+				SUM(l.quantity) AS sum, SUM(l.revenue) AS revenue
+			FROM ` + soldLines + ` AS l
+			JOIN orders AS o ON o.id = l.order_id
+			WHERE o.status IN ` + soldStatuses + `
+			GROUP BY l.product_id, l.variant_id
 			ORDER BY sum DESC`
 
 	var popularies models.PopularItems
@@ -54,14 +99,13 @@ func (db *dalAggregation) Popularies() (*models.PopularItems, error) {
 
 func (db *dalAggregation) CountOfOrderedItems(start, end *time.Time) (map[string]uint64, error) {
 	const countItemsQ2 string = `
-		SELECT m.name, SUM(oi.quantity) AS sum
-			FROM order_items AS oi
-			JOIN menu_items AS m ON m.id = oi.product_id
-			JOIN orders AS o ON o.id = oi.order_id
-			WHERE o.status = 'accepted' AND
+		SELECT l.name, SUM(l.quantity) AS sum
+			FROM ` + soldLines + ` AS l
+			JOIN orders AS o ON o.id = l.order_id
+			WHERE o.status IN ` + soldStatuses + ` AND
 				($1::date IS NULL OR o.created_at::date >= $1::date) AND
 				($2::date IS NULL OR o.created_at::date <= $2::date)
-			GROUP BY m.name
+			GROUP BY l.name
 			ORDER BY sum DESC`
 
 	// Было (::date)	Стало (::timestamptz)
@@ -120,20 +164,30 @@ func (db *dalAggregation) SearchByWordMenu(find string, minPrice, maxPrice float
 			m.allergens,
 			m.price,
 			array_agg(i.name) AS inventories,
+			v.names AS variant_names,
 			ROUND(
 				ts_rank(
 					setweight(to_tsvector(m.name),'A') ||
 					setweight(to_tsvector(m.description),'B') ||
 					setweight(to_tsvector(array_to_string(m.tags, ' ')), 'C') ||
 					setweight(to_tsvector(array_to_string(m.allergens, ' ')), 'D') ||
-					setweight(to_tsvector(string_agg(i.name, ' ')), 'B'),
+					setweight(to_tsvector(string_agg(i.name, ' ')), 'B') ||
+					setweight(to_tsvector(array_to_string(v.names, ' ')), 'B'),
 					to_tsquery($1)
 				)::numeric, 2) AS relevance
 		FROM menu_items AS m
 		JOIN menu_item_ingredients AS mi ON m.id=mi.product_id
 		JOIN inventory AS i ON mi.inventory_id = i.id
-		WHERE m.price BETWEEN $2 AND $3
-		GROUP BY m.id
+		-- variants in 1 row, so they don't multiply ingredients
+		CROSS JOIN LATERAL (
+			SELECT
+				COALESCE(array_agg(name ORDER BY id), '{}') AS names,
+				bool_or(price BETWEEN $2 AND $3) AS in_price
+			FROM menu_item_variants
+			WHERE product_id = m.id
+		) AS v
+		WHERE m.price BETWEEN $2 AND $3 OR v.in_price
+		GROUP BY m.id, v.names
 	)
 	SELECT * 
 	FROM ranked_menu 
@@ -151,17 +205,16 @@ func (db *dalAggregation) SearchByWordOrder(find string, minPrice, maxPrice floa
 			o.status,
 			o.allergens,
 			o.total,
-			array_agg(m.name) AS menu_items,
+			array_agg(` + lineName + `) AS menu_items,
 			ROUND(
 				ts_rank(
 					setweight(to_tsvector(o.customer_name),'A') ||
 					setweight(to_tsvector(array_to_string(o.allergens, ' ')), 'C') ||
-					setweight(to_tsvector(string_agg(m.name, ' ')), 'B'),
+					setweight(to_tsvector(string_agg(` + lineName + `, ' ')), 'B'),
 					to_tsquery($1)
 				)::numeric, 2) AS relevance
 		FROM orders AS o
 		JOIN order_items AS oi ON o.id = oi.order_id
-		JOIN menu_items AS m ON oi.product_id = m.id
 		WHERE o.total BETWEEN $2 AND $3
 		GROUP BY o.id
 	)
@@ -200,7 +253,7 @@ func (db *dalAggregation) PeriodYear(year int) ([]map[string]uint64, error) {
 	FROM orders
 	WHERE 
 		EXTRACT(YEAR FROM created_at) = $1
-		AND status = 'accepted'
+		AND status IN ` + soldStatuses + `
 	GROUP BY month, EXTRACT(MONTH FROM created_at)
 	ORDER BY EXTRACT(MONTH FROM created_at)`
 	rows, err := db.database.Queryx(query, year)
@@ -211,6 +264,51 @@ func (db *dalAggregation) PeriodYear(year int) ([]map[string]uint64, error) {
 	return db.rowsToMap(rows)
 }
 
+// PeriodMonthRevenue: net revenue by days of the month, refunds are on their day
+func (db *dalAggregation) PeriodMonthRevenue(month time.Month) ([]map[string]float64, error) {
+	const query string = `
+	SELECT EXTRACT(DAY FROM at)::int::text AS day, ROUND(SUM(net), 2) AS net_revenue
+		FROM ` + netFlows + ` AS f
+		WHERE EXTRACT(MONTH FROM at) = $1
+		GROUP BY EXTRACT(DAY FROM at)
+		ORDER BY EXTRACT(DAY FROM at)`
+	rows, err := db.database.Queryx(query, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return db.rowsToMoney(rows)
+}
+
+// PeriodYearRevenue: net revenue by months of the year
+func (db *dalAggregation) PeriodYearRevenue(year int) ([]map[string]float64, error) {
+	const query string = `
+	SELECT TO_CHAR(at, 'FMMonth') AS month, ROUND(SUM(net), 2) AS net_revenue
+		FROM ` + netFlows + ` AS f
+		WHERE EXTRACT(YEAR FROM at) = $1
+		GROUP BY month, EXTRACT(MONTH FROM at)
+		ORDER BY EXTRACT(MONTH FROM at)`
+	rows, err := db.database.Queryx(query, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return db.rowsToMoney(rows)
+}
+
+func (db *dalAggregation) rowsToMoney(rows *sqlx.Rows) ([]map[string]float64, error) {
+	result := []map[string]float64{}
+	for rows.Next() {
+		var period string
+		var money float64
+		if err := rows.Scan(&period, &money); err != nil {
+			return nil, err
+		}
+		result = append(result, map[string]float64{period: money})
+	}
+	return result, rows.Err()
+}
+
 func (db *dalAggregation) rowsToMap(rows *sqlx.Rows) ([]map[string]uint64, error) {
 	var result []map[string]uint64
 	for rows.Next() {
@@ -264,3 +362,94 @@ func (db *dalAggregation) GetLeftOversRepo(over *models.GetLeftOvers) error {
 	over.HasNextPage = over.CurrentPage < over.TotalPages
 	return tx.Commit()
 }
+
+// LowMargins: menu items and their variants costed like GET /menu/{id}/cost
+func (db *dalAggregation) LowMargins(low *models.LowMargins) error {
+	query := `
+	SELECT * FROM (
+		SELECT m.id AS product_id, NULL::int AS variant_id, m.name, m.price, ` + marginColumns("m.price", "c.cost") + `
+			FROM menu_items AS m
+			JOIN menu_item_costs AS c ON c.product_id = m.id
+		UNION ALL
+		SELECT v.product_id, v.id, m.name || ' (' || v.name || ')', v.price, ` + marginColumns("v.price", "c.cost * v.scale") + `
+			FROM menu_item_variants AS v
+			JOIN menu_items AS m ON m.id = v.product_id
+			JOIN menu_item_costs AS c ON c.product_id = v.product_id
+	) AS l
+	WHERE COALESCE(l.margin_percent, 0) < $1
+	ORDER BY COALESCE(l.margin_percent, 0), l.product_id, l.variant_id NULLS FIRST`
+
+	low.Items = []models.MenuCost{}
+	return db.database.Select(&low.Items, query, low.Threshold)
+}
+
+// TenderSummary: payments and refunds of the day (2006-01-02) by method, voided payments are not counted
+func (db *dalAggregation) TenderSummary(day string, sum *models.TenderSummary) error {
+	const query string = `
+	SELECT COALESCE(p.method, r.method) AS method,
+			COALESCE(p.payments, 0) AS payments, COALESCE(p.orders, 0) AS orders,
+			COALESCE(p.amount, 0) AS amount, COALESCE(p.tendered, 0) AS tendered,
+			COALESCE(p.change_due, 0) AS change_due, COALESCE(r.refunded, 0) AS refunded
+		FROM (
+			SELECT method, COUNT(*) AS payments, COUNT(DISTINCT order_id) AS orders,
+					SUM(amount) AS amount, SUM(tendered) AS tendered, SUM(change_due) AS change_due
+				FROM payments
+				WHERE voided_at IS NULL AND created_at::date = $1::date
+				GROUP BY method
+		) AS p
+		FULL JOIN (
+			SELECT method, SUM(amount) AS refunded
+				FROM refunds
+				WHERE created_at::date = $1::date
+				GROUP BY method
+		) AS r ON r.method = p.method
+		ORDER BY 1`
+	sum.Tenders = []models.Tender{}
+	if err := db.database.Select(&sum.Tenders, query, day); err != nil {
+		return err
+	}
+
+	// a split bill is 1 order in several tenders
+	const ordersQ string = `
+	SELECT COUNT(DISTINCT order_id)
+		FROM payments
+		WHERE voided_at IS NULL AND created_at::date = $1::date`
+	if err := db.database.Get(&sum.Orders, ordersQ, day); err != nil {
+		return err
+	}
+	for _, tender := range sum.Tenders {
+		sum.Payments += tender.Payments
+		sum.Amount += tender.Amount
+		sum.Change += tender.Change
+		sum.Refunded += tender.Refunded
+	}
+	sum.Amount = math.Round(sum.Amount*100) / 100
+	sum.Change = math.Round(sum.Change*100) / 100
+	sum.Refunded = math.Round(sum.Refunded*100) / 100
+	return nil
+}
+
+// CustomerValues: lifetime value of the customers, sortBy is a whitelisted column
+func (db *dalAggregation) CustomerValues(sortBy string, limit uint64) ([]models.CustomerValue, error) {
+	column := map[string]string{"orders": "orders", "last_order": "last_order"}[sortBy]
+	if len(column) == 0 {
+		column = "lifetime_value"
+	}
+	query := `
+	SELECT c.id, c.name,
+			COUNT(o.id) AS orders,
+			COALESCE(SUM(o.total), 0) AS spent,
+			COALESCE(SUM(r.amount), 0) AS refunded,
+			COALESCE(SUM(o.total), 0) - COALESCE(SUM(r.amount), 0) AS lifetime_value,
+			COALESCE(ROUND((SUM(o.total) - COALESCE(SUM(r.amount), 0)) / NULLIF(COUNT(o.id), 0), 2), 0) AS average_order,
+			MIN(o.created_at) AS first_order,
+			MAX(o.created_at) AS last_order
+		FROM customers AS c
+		LEFT JOIN orders AS o ON o.customer_id = c.id AND o.status IN ` + revenueStatuses + `
+		LEFT JOIN (SELECT order_id, SUM(amount) AS amount FROM refunds GROUP BY order_id) AS r ON r.order_id = o.id
+		GROUP BY c.id
+		ORDER BY ` + column + ` DESC NULLS LAST, c.id
+		LIMIT $1`
+	values := []models.CustomerValue{}
+	return values, db.database.Select(&values, query, limit)
+}
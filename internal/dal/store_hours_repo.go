@@ -0,0 +1,241 @@
+package dal
+
+import (
+	"fmt"
+	"time"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type dalStoreHours struct {
+	db *sqlx.DB
+}
+
+type StoreHoursDalInter interface {
+	SelectPickupSettings() (*models.PickupSettings, error)
+	UpdatePickupSettings(*models.PickupSettings) error
+	UpsertStoreHours(*models.StoreHours) error
+	DeleteStoreHours(weekday uint64) error
+}
+
+func ReturnDalStoreHours(db *sqlx.DB) StoreHoursDalInter {
+	return &dalStoreHours{db: db}
+}
+
+// SelectPickupSettings: the slots and the opening hours from Monday
+func (core *dalStoreHours) SelectPickupSettings() (*models.PickupSettings, error) {
+	var settings models.PickupSettings
+	err := core.db.Get(&settings, `SELECT slot_minutes, slot_capacity, max_days_ahead, timezone FROM pickup_settings`)
+	if err != nil {
+		return nil, err
+	}
+	settings.Hours = []models.StoreHours{}
+	err = core.db.Select(&settings.Hours, `
+	SELECT weekday, to_char(opens, 'HH24:MI') AS opens, to_char(closes, 'HH24:MI') AS closes
+		FROM store_hours
+		ORDER BY weekday`)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdatePickupSettings is for the next pre-orders, the booked ones stay in their slots.
+// Places of the slots are counted again, slots of other length or timezone are other slots
+func (core *dalStoreHours) UpdatePickupSettings(settings *models.PickupSettings) error {
+	tx, err := core.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// waits for the pre-orders holding the settings FOR SHARE, the later ones are run again
+	_, err = tx.Exec(`
+	UPDATE pickup_settings
+		SET slot_minutes = $1, slot_capacity = $2, max_days_ahead = $3, timezone = $4`,
+		*settings.SlotMinutes, *settings.SlotCapacity, *settings.MaxDaysAhead, *settings.Timezone)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "22023" { // unknown time zone for Postgres
+		return fmt.Errorf("%w: timezone - %s", models.ErrBadInput, *settings.Timezone)
+	} else if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM pickup_slots`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (core *dalStoreHours) UpsertStoreHours(hours *models.StoreHours) error {
+	_, err := core.db.Exec(`
+	INSERT INTO store_hours (weekday, opens, closes)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (weekday) DO UPDATE
+		SET opens = EXCLUDED.opens, closes = EXCLUDED.closes`, hours.Weekday, hours.Opens, hours.Closes)
+	return err
+}
+
+// DeleteStoreHours closes the store on the weekday
+func (core *dalStoreHours) DeleteStoreHours(weekday uint64) error {
+	result, err := core.db.Exec(`DELETE FROM store_hours WHERE weekday = $1`, weekday)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// checkPickup: the pickup time of a pre-order is ahead, not further than max_days_ahead,
+// in the opening hours of its day (in the store timezone) and its slot has room. The place
+// is taken in pickup_slots: the UPDATE waits for an order taking the same slot at once and
+// checks the committed count; in a REPEATABLE READ order that one's commit is a
+// serialization failure (40001) and the order is run again
+func (db *dalOrder) checkPickup(tx *sqlx.Tx, ord *models.Order) error {
+	if ord.PickupAt == nil {
+		return nil
+	}
+	var slot struct {
+		Capacity uint64    `db:"slot_capacity"`
+		MaxDays  uint64    `db:"max_days_ahead"`
+		Opens    *string   `db:"opens"`
+		Closes   *string   `db:"closes"`
+		Open     bool      `db:"open"`
+		Start    time.Time `db:"slot_start"` // in the store timezone
+		From     time.Time `db:"slot_from"`  // the same, as a moment
+		To       time.Time `db:"slot_to"`
+		Now      time.Time `db:"now"`
+	}
+	// FOR SHARE: a change of the settings waits for this order, or this one is run again after it
+	err := tx.Get(&slot, `
+	WITH s AS (
+		SELECT *, $1::timestamptz AT TIME ZONE timezone AS local
+			FROM pickup_settings
+			FOR SHARE
+	), slot AS (
+		SELECT s.*, pickup_slot(s.local, s.slot_minutes) AS start FROM s
+	)
+	SELECT s.slot_capacity, s.max_days_ahead, s.start AS slot_start, NOW() AS now,
+			s.start AT TIME ZONE s.timezone AS slot_from,
+			(s.start + s.slot_minutes * INTERVAL '1 minute') AT TIME ZONE s.timezone AS slot_to,
+			to_char(h.opens, 'HH24:MI') AS opens, to_char(h.closes, 'HH24:MI') AS closes,
+			COALESCE(s.local::time >= h.opens AND s.local::time < h.closes, FALSE) AS open
+		FROM slot AS s
+		LEFT JOIN store_hours AS h ON h.weekday = EXTRACT(ISODOW FROM s.local)`, *ord.PickupAt)
+	if err != nil {
+		return err
+	}
+
+	at := ord.PickupAt.Format(time.RFC3339)
+	switch {
+	case !ord.PickupAt.After(slot.Now):
+		return fmt.Errorf("%w : %s is in the past", models.ErrPickup, at)
+	case ord.PickupAt.After(slot.Now.AddDate(0, 0, int(slot.MaxDays))):
+		return fmt.Errorf("%w : %s is more than %d days ahead", models.ErrPickup, at, slot.MaxDays)
+	case slot.Opens == nil:
+		return fmt.Errorf("%w : the store is closed on %s", models.ErrPickup, slot.Start.Weekday())
+	case !slot.Open:
+		return fmt.Errorf("%w : %s, the store is open %s-%s", models.ErrPickup, at, *slot.Opens, *slot.Closes)
+	}
+
+	// the first order of the slot counts the ones before it (of other settings, or of before the table)
+	result, err := tx.Exec(`
+	INSERT INTO pickup_slots (start, taken)
+		SELECT $1, COUNT(*) + 1
+			FROM orders AS o
+			WHERE o.pickup_at >= $1 AND o.pickup_at < $2
+				AND o.id <> $3 AND o.status NOT IN ('cancelled', 'refunded')
+			HAVING COUNT(*) < $4
+	ON CONFLICT (start) DO UPDATE SET taken = pickup_slots.taken + 1
+		WHERE pickup_slots.taken < $4`, slot.From, slot.To, ord.ID, slot.Capacity)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w : the slot of %s is full (%d orders)", models.ErrPickup, slot.Start.Format("2006-01-02 15:04"), slot.Capacity)
+	}
+	return nil
+}
+
+// releasePickup gives the place of a pre-order back to its slot. It is called before the order
+// is cancelled, refunded, deleted or moved to another time
+func (db *dalOrder) releasePickup(tx *sqlx.Tx, orderID uint64) error {
+	_, err := tx.Exec(`
+	UPDATE pickup_slots AS p SET taken = p.taken - 1
+		FROM orders AS o, pickup_settings AS s
+		WHERE o.id = $1 AND o.pickup_at IS NOT NULL AND o.status NOT IN ('cancelled', 'refunded')
+			AND p.start = pickup_slot(o.pickup_at AT TIME ZONE s.timezone, s.slot_minutes) AT TIME ZONE s.timezone
+			AND p.taken > 0`, orderID)
+	return err
+}
+
+// SelectPickups: pre-orders of [from, to) by their slots, not cancelled or refunded.
+// from nil is the slot of now, to nil is no end. Slots are of the store timezone
+func (db *dalOrder) SelectPickups(from, to *time.Time) (*models.PickupQueue, error) {
+	tx, err := db.database.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	if err != nil {
+		return nil, err
+	}
+
+	queue := models.PickupQueue{Slots: []models.PickupSlot{}}
+	err = tx.QueryRow(`SELECT slot_minutes, slot_capacity FROM pickup_settings`).Scan(&queue.SlotMinutes, &queue.SlotCapacity)
+	if err != nil {
+		return nil, err
+	}
+	var orders []struct {
+		SlotStart time.Time `db:"slot_start"`
+		models.PickupOrder
+	}
+	err = tx.Select(&orders, `
+	SELECT pickup_slot(o.pickup_at AT TIME ZONE s.timezone, s.slot_minutes) AT TIME ZONE s.timezone AS slot_start,
+			o.id, o.customer_name, o.status, o.total, o.pickup_at
+		FROM orders AS o
+		CROSS JOIN pickup_settings AS s
+		WHERE o.pickup_at IS NOT NULL
+			AND o.status NOT IN ('cancelled', 'refunded')
+			AND o.pickup_at >= COALESCE($1::timestamptz, pickup_slot(NOW() AT TIME ZONE s.timezone, s.slot_minutes) AT TIME ZONE s.timezone)
+			AND ($2::timestamptz IS NULL OR o.pickup_at < $2)
+		ORDER BY o.pickup_at, o.id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	slotLength := time.Duration(queue.SlotMinutes) * time.Minute
+	for _, ord := range orders {
+		n := len(queue.Slots)
+		if n == 0 || !queue.Slots[n-1].Start.Equal(ord.SlotStart) {
+			queue.Slots = append(queue.Slots, models.PickupSlot{
+				Start:  ord.SlotStart,
+				End:    ord.SlotStart.Add(slotLength),
+				Orders: []models.PickupOrder{},
+			})
+			n++
+		}
+		slot := &queue.Slots[n-1]
+		slot.Taken++
+		if ord.Status != "picked_up" {
+			slot.Orders = append(slot.Orders, ord.PickupOrder)
+		}
+	}
+	for i := range queue.Slots {
+		queue.Slots[i].Free = queue.SlotCapacity - min(queue.Slots[i].Taken, queue.SlotCapacity)
+	}
+	return &queue, tx.Commit()
+}
+
+// SelectStoreTimezone: IANA name of the store timezone
+func (db *dalOrder) SelectStoreTimezone() (string, error) {
+	var timezone string
+	return timezone, db.database.Get(&timezone, `SELECT timezone FROM pickup_settings`)
+}
@@ -0,0 +1,100 @@
+package dal
+
+import (
+	"fmt"
+
+	"frappuccino/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type dalTax struct {
+	db *sqlx.DB
+}
+
+type TaxDalInter interface {
+	SelectTaxSettings() (*models.TaxSettings, error)
+	UpdateTaxMode(pricesIncludeTax bool) error
+	InsertTaxRate(*models.TaxRate) error
+	UpdateTaxRate(*models.TaxRate) error
+	DeleteTaxRate(uint64) error
+}
+
+func ReturnDalTax(db *sqlx.DB) TaxDalInter {
+	return &dalTax{db: db}
+}
+
+// SelectTaxSettings: the mode and the rates, the default one first
+func (core *dalTax) SelectTaxSettings() (*models.TaxSettings, error) {
+	var settings models.TaxSettings
+	err := core.db.Get(&settings, `SELECT prices_include_tax FROM tax_settings`)
+	if err != nil {
+		return nil, err
+	}
+	settings.Rates = []models.TaxRate{}
+	err = core.db.Select(&settings.Rates, `
+	SELECT id, name, rate, category_id
+		FROM tax_rates
+		ORDER BY category_id NULLS FIRST, id`)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateTaxMode is for the next orders, old orders keep their tax_inclusive
+func (core *dalTax) UpdateTaxMode(pricesIncludeTax bool) error {
+	_, err := core.db.Exec(`UPDATE tax_settings SET prices_include_tax = $1`, pricesIncludeTax)
+	return err
+}
+
+func (core *dalTax) InsertTaxRate(rate *models.TaxRate) error {
+	err := core.db.QueryRow(`
+	INSERT INTO tax_rates (name, rate, category_id)
+	VALUES ($1, $2, $3)
+	RETURNING id`, rate.Name, rate.Rate, rate.CategoryID).Scan(&rate.ID)
+	return core.pqErr(err)
+}
+
+func (core *dalTax) UpdateTaxRate(rate *models.TaxRate) error {
+	result, err := core.db.Exec(`
+	UPDATE tax_rates
+		SET name = $2, rate = $3, category_id = $4
+		WHERE id = $1`, rate.ID, rate.Name, rate.Rate, rate.CategoryID)
+	if err != nil {
+		return core.pqErr(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (core *dalTax) DeleteTaxRate(id uint64) error {
+	result, err := core.db.Exec(`DELETE FROM tax_rates WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// pqErr: the same name, a second rate of the category or a second default rate is a conflict
+func (core *dalTax) pqErr(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23505": // unique
+			return models.ErrConflict
+		case "23503": // foreign key
+			return fmt.Errorf("%w : category of the tax rate", models.ErrNotFound)
+		}
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type categoryHandToService struct {
+	categoryServInt service.CategoryServiceInter
+}
+
+type CategoryHandInter interface {
+	GetCategories(w http.ResponseWriter, r *http.Request)
+	GetCategoryByID(w http.ResponseWriter, r *http.Request)
+	PostCategory(w http.ResponseWriter, r *http.Request)
+	PutCategoryByID(w http.ResponseWriter, r *http.Request)
+	DelCategory(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnCategoryHandStruct(categorySerInt service.CategoryServiceInter) CategoryHandInter {
+	return &categoryHandToService{categoryServInt: categorySerInt}
+}
+
+func (h *categoryHandToService) GetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.categoryServInt.CollectCategories()
+	if err != nil {
+		slog.Error("Get categories", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get categories", err.Error())
+		return
+	}
+	bodyJsonStruct(w, categories, http.StatusOK)
+	slog.Info("Get all categories")
+}
+
+func (h *categoryHandToService) GetCategoryByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get category: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	category, err := h.categoryServInt.TakeCategory(id)
+	if err != nil {
+		slog.Error("Get category", "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "category", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "get category", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, category, http.StatusOK)
+}
+
+func (h *categoryHandToService) PostCategory(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post category: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var category models.Category
+	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+		slog.Error("incorrect input to post category", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.categoryServInt.CreateCategory(&category)
+	if err != nil {
+		slog.Error("Post category", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("category created", "id", category.ID)
+	bodyJsonStruct(w, category, http.StatusCreated)
+}
+
+func (h *categoryHandToService) PutCategoryByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put category: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put category: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var category models.Category
+	if err = json.NewDecoder(r.Body).Decode(&category); err != nil {
+		slog.Error("incorrect input to put category", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	category.ID = id
+	err = h.categoryServInt.UpgradeCategory(&category)
+	if err != nil {
+		slog.Error("Put category", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("category updated", "id", id)
+	bodyJsonStruct(w, category, http.StatusOK)
+}
+
+func (h *categoryHandToService) DelCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Del category: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	err = h.categoryServInt.DelCategory(id)
+	if err != nil {
+		slog.Error("Delete category", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("Deleted: ", "category by id :", id)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+func (h *categoryHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "category", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "category", err.Error())
+	case errors.Is(err, models.ErrConflict):
+		writeHttp(w, http.StatusConflict, "category", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "category", err.Error())
+	}
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type customerHandToService struct {
+	customerServInt service.CustomerServiceInter
+}
+
+type CustomerHandInter interface {
+	GetCustomers(w http.ResponseWriter, r *http.Request)
+	GetCustomerByID(w http.ResponseWriter, r *http.Request)
+	PostCustomer(w http.ResponseWriter, r *http.Request)
+	PutCustomerByID(w http.ResponseWriter, r *http.Request)
+	DelCustomer(w http.ResponseWriter, r *http.Request)
+	GetCustomerOrders(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnCustomerHandStruct(customerSerInt service.CustomerServiceInter) CustomerHandInter {
+	return &customerHandToService{customerServInt: customerSerInt}
+}
+
+func (h *customerHandToService) GetCustomers(w http.ResponseWriter, r *http.Request) {
+	customers, err := h.customerServInt.CollectCustomers()
+	if err != nil {
+		slog.Error("Get customers", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get customers", err.Error())
+		return
+	}
+	bodyJsonStruct(w, customers, http.StatusOK)
+	slog.Info("Get all customers")
+}
+
+func (h *customerHandToService) GetCustomerByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get customer: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	customer, err := h.customerServInt.TakeCustomer(id)
+	if err != nil {
+		slog.Error("Get customer", "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "customer", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "get customer", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, customer, http.StatusOK)
+}
+
+func (h *customerHandToService) PostCustomer(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post customer: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var customer models.Customer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		slog.Error("incorrect input to post customer", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.customerServInt.CreateCustomer(&customer)
+	if err != nil {
+		slog.Error("Post customer", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("customer created", "id", customer.ID)
+	bodyJsonStruct(w, customer, http.StatusCreated)
+}
+
+func (h *customerHandToService) PutCustomerByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put customer: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put customer: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var customer models.Customer
+	if err = json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		slog.Error("incorrect input to put customer", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	customer.ID = id
+	err = h.customerServInt.UpgradeCustomer(&customer)
+	if err != nil {
+		slog.Error("Put customer", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("customer updated", "id", id)
+	bodyJsonStruct(w, customer, http.StatusOK)
+}
+
+func (h *customerHandToService) DelCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Del customer: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	err = h.customerServInt.DelCustomer(id)
+	if err != nil {
+		slog.Error("Delete customer", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("Deleted: ", "customer by id :", id)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+// GetCustomerOrders takes the query parameters of GET /orders
+func (h *customerHandToService) GetCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get customer orders: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	q := r.URL.Query()
+	query := models.OrderQuery{
+		Status:    q.Get("status"),
+		StartDate: q.Get("startDate"),
+		EndDate:   q.Get("endDate"),
+		MinTotal:  q.Get("minTotal"),
+		MaxTotal:  q.Get("maxTotal"),
+		Product:   q.Get("product"),
+		SortBy:    q.Get("sortBy"),
+		Order:     q.Get("order"),
+		PageSize:  q.Get("pageSize"),
+		Cursor:    q.Get("cursor"),
+	}
+
+	orders, err := h.customerServInt.CollectCustomerOrders(id, &query)
+	if err != nil {
+		slog.Error("Get customer orders", "customer", id, "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "customer orders", err.Error())
+		} else {
+			h.writeErr(w, err)
+		}
+		return
+	}
+	bodyJsonStruct(w, orders, http.StatusOK)
+}
+
+func (h *customerHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "customer", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "customer", err.Error())
+	case errors.Is(err, models.ErrConflict):
+		writeHttp(w, http.StatusConflict, "customer", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "customer", err.Error())
+	}
+}
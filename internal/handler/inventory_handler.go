@@ -23,6 +23,8 @@ type inventoryHandlerInt interface {
 	DeleteInventory(w http.ResponseWriter, r *http.Request)
 	GetInventoryHistory(w http.ResponseWriter, r *http.Request)
 	GetReorderInventories(w http.ResponseWriter, r *http.Request)
+	PutPrepRecipe(w http.ResponseWriter, r *http.Request)
+	PostProducePrep(w http.ResponseWriter, r *http.Request)
 }
 
 func NewInventoryHandler(service service.InventoryService) inventoryHandlerInt {
@@ -190,3 +192,88 @@ func (handl *inventoryHandler) GetReorderInventories(w http.ResponseWriter, r *h
 	bodyJsonStruct(w, invents, http.StatusOK)
 	slog.Info("Get", "reorder inventories:", "succes")
 }
+
+func (handl *inventoryHandler) PutPrepRecipe(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put prep recipe: invalid parse id")
+		writeHttp(w, http.StatusBadRequest, "id url", "invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put prep recipe: content type not json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content type", "invalid")
+		return
+	}
+
+	var recipe []models.PrepIngredient
+	if err = json.NewDecoder(r.Body).Decode(&recipe); err != nil {
+		slog.Error("Put prep recipe: Error in decoder")
+		writeHttp(w, http.StatusBadRequest, "recipe", err.Error())
+		return
+	}
+
+	err = handl.invSrv.UpgradePrepRecipe(id, &recipe)
+	if err != nil {
+		slog.Error("Put prep recipe", "error", err)
+		if errors.Is(err, models.ErrNotFoundItems) {
+			bodyJsonStruct(w, recipe, http.StatusNotFound)
+			return
+		}
+		code := http.StatusInternalServerError
+		if errors.Is(err, models.ErrBadInput) {
+			code = http.StatusUnprocessableEntity
+		} else if errors.Is(err, models.ErrNotFound) {
+			code = http.StatusNotFound
+		}
+		writeHttp(w, code, "recipe", err.Error())
+		return
+	}
+
+	slog.Info("put prep recipe success", "id", id)
+	writeHttp(w, http.StatusOK, "updated", "recipe")
+}
+
+func (handl *inventoryHandler) PostProducePrep(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Produce prep: invalid parse id")
+		writeHttp(w, http.StatusBadRequest, "id url", "invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Produce prep: content type not json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content type", "invalid")
+		return
+	}
+
+	var prod models.Production
+	if err = json.NewDecoder(r.Body).Decode(&prod); err != nil {
+		slog.Error("Produce prep: Error in decoder")
+		writeHttp(w, http.StatusBadRequest, "production", err.Error())
+		return
+	}
+	prod.PrepID = id
+
+	err = handl.invSrv.ProducePrep(&prod)
+	if err != nil {
+		slog.Error("Produce prep", "error", err)
+		if errors.Is(err, models.ErrOrderNotEnoughItems) {
+			bodyJsonStruct(w, prod, http.StatusFailedDependency)
+			return
+		}
+		code := http.StatusInternalServerError
+		if errors.Is(err, models.ErrBadInput) {
+			code = http.StatusUnprocessableEntity
+		} else if errors.Is(err, models.ErrNotFound) {
+			code = http.StatusNotFound
+		} else if errors.Is(err, models.ErrConflict) {
+			code = http.StatusConflict
+		}
+		writeHttp(w, code, "production", err.Error())
+		return
+	}
+
+	slog.Info("produced prep item", "id", id, "quantity", prod.Quantity)
+	bodyJsonStruct(w, prod, http.StatusOK)
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type loyaltyHandToService struct {
+	loyaltyServInt service.LoyaltyServiceInter
+}
+
+type LoyaltyHandInter interface {
+	GetLoyalty(w http.ResponseWriter, r *http.Request)
+	PutPointValue(w http.ResponseWriter, r *http.Request)
+	PostLoyaltyRule(w http.ResponseWriter, r *http.Request)
+	PutLoyaltyRuleByID(w http.ResponseWriter, r *http.Request)
+	DelLoyaltyRule(w http.ResponseWriter, r *http.Request)
+	GetCustomerLoyalty(w http.ResponseWriter, r *http.Request)
+	PostCustomerLoyalty(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnLoyaltyHandStruct(loyaltySerInt service.LoyaltyServiceInter) LoyaltyHandInter {
+	return &loyaltyHandToService{loyaltyServInt: loyaltySerInt}
+}
+
+func (h *loyaltyHandToService) GetLoyalty(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.loyaltyServInt.TakeLoyaltySettings()
+	if err != nil {
+		slog.Error("Get loyalty", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get loyalty", err.Error())
+		return
+	}
+	bodyJsonStruct(w, settings, http.StatusOK)
+}
+
+func (h *loyaltyHandToService) PutPointValue(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put point value: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var settings models.LoyaltySettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		slog.Error("incorrect input to put point value", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.loyaltyServInt.SwitchPointValue(&settings)
+	if err != nil {
+		slog.Error("Put point value", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("point value updated", "point_value", *settings.PointValue)
+	bodyJsonStruct(w, settings, http.StatusOK)
+}
+
+func (h *loyaltyHandToService) PostLoyaltyRule(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post loyalty rule: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var rule models.LoyaltyRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		slog.Error("incorrect input to post loyalty rule", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.loyaltyServInt.CreateLoyaltyRule(&rule)
+	if err != nil {
+		slog.Error("Post loyalty rule", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("loyalty rule created", "id", rule.ID)
+	bodyJsonStruct(w, rule, http.StatusCreated)
+}
+
+func (h *loyaltyHandToService) PutLoyaltyRuleByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put loyalty rule: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put loyalty rule: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var rule models.LoyaltyRule
+	if err = json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		slog.Error("incorrect input to put loyalty rule", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	rule.ID = id
+	err = h.loyaltyServInt.UpgradeLoyaltyRule(&rule)
+	if err != nil {
+		slog.Error("Put loyalty rule", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("loyalty rule updated", "id", id)
+	bodyJsonStruct(w, rule, http.StatusOK)
+}
+
+func (h *loyaltyHandToService) DelLoyaltyRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Del loyalty rule: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	err = h.loyaltyServInt.DelLoyaltyRule(id)
+	if err != nil {
+		slog.Error("Delete loyalty rule", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("Deleted: ", "loyalty rule by id :", id)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+// GetCustomerLoyalty: GET /customers/{id}/loyalty, the balance and the ledger of the customer
+func (h *loyaltyHandToService) GetCustomerLoyalty(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get customer loyalty: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	account, err := h.loyaltyServInt.TakeLoyaltyAccount(id)
+	if err != nil {
+		slog.Error("Get customer loyalty", "customer", id, "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	bodyJsonStruct(w, account, http.StatusOK)
+}
+
+// PostCustomerLoyalty: POST /customers/{id}/loyalty, a manual adjustment {"points": -10, "note": "..."}
+func (h *loyaltyHandToService) PostCustomerLoyalty(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Post customer loyalty: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post customer loyalty: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var entry models.LoyaltyEntry
+	if err = json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		slog.Error("incorrect input to post customer loyalty", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	entry.CustomerID = id
+	err = h.loyaltyServInt.AdjustPoints(&entry)
+	if err != nil {
+		slog.Error("Post customer loyalty", "customer", id, "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("loyalty points adjusted", "customer", id, "points", entry.Points)
+	bodyJsonStruct(w, entry, http.StatusCreated)
+}
+
+func (h *loyaltyHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "loyalty", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "loyalty", err.Error())
+	case errors.Is(err, models.ErrConflict):
+		writeHttp(w, http.StatusConflict, "loyalty", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "loyalty", err.Error())
+	}
+}
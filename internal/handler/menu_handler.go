@@ -22,6 +22,12 @@ type menuHandInt interface {
 	PostMenu(w http.ResponseWriter, r *http.Request)
 	PutMenuByID(w http.ResponseWriter, r *http.Request)
 	GetHistory(w http.ResponseWriter, r *http.Request)
+	GetMenuCost(w http.ResponseWriter, r *http.Request)
+	PutMenuAvailability(w http.ResponseWriter, r *http.Request)
+	PutMenuSchedules(w http.ResponseWriter, r *http.Request)
+	PostScheduledPrice(w http.ResponseWriter, r *http.Request)
+	GetScheduledPrices(w http.ResponseWriter, r *http.Request)
+	DelScheduledPrice(w http.ResponseWriter, r *http.Request)
 }
 
 func ReturnMenuHaldStruct(menuSerInt service.MenuServiceInter) menuHandInt {
@@ -29,6 +35,10 @@ func ReturnMenuHaldStruct(menuSerInt service.MenuServiceInter) menuHandInt {
 }
 
 func (handMenu *menuHandToService) GetMenus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("view") == "sections" {
+		handMenu.getSections(w)
+		return
+	}
 	menus, err := handMenu.menuServInt.CollectMenus()
 	if err != nil {
 		slog.Error("Error getting all menus", "error", err)
@@ -40,6 +50,19 @@ func (handMenu *menuHandToService) GetMenus(w http.ResponseWriter, r *http.Reque
 	slog.Info("Get all menu list")
 }
 
+// getSections is GET /menu?view=sections: the menu grouped by categories in their order
+func (handMenu *menuHandToService) getSections(w http.ResponseWriter) {
+	sections, err := handMenu.menuServInt.CollectSections()
+	if err != nil {
+		slog.Error("Error getting menu sections", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get sections", err.Error())
+		return
+	}
+
+	bodyJsonStruct(w, sections, http.StatusOK)
+	slog.Info("Get menu sections")
+}
+
 func (handMenu *menuHandToService) GetMenuByID(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
 	if err != nil {
@@ -122,7 +145,7 @@ func (handMenu *menuHandToService) PostMenu(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	if errors.Is(err, models.ErrBadInput) {
-		writeHttp(w, http.StatusUnprocessableEntity, "failed", "menu input:")
+		writeHttp(w, http.StatusUnprocessableEntity, "failed", "menu input: "+err.Error())
 		return
 	}
 
@@ -130,6 +153,10 @@ func (handMenu *menuHandToService) PostMenu(w http.ResponseWriter, r *http.Reque
 		bodyJsonStruct(w, menuStruct.Ingredients, http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, models.ErrNotFound) { // choice of a bundle
+		writeHttp(w, http.StatusNotFound, "failed", "menu input: "+err.Error())
+		return
+	}
 	writeHttp(w, http.StatusInternalServerError, "failed", "post menu:")
 }
 
@@ -197,3 +224,179 @@ func (handMenu *menuHandToService) GetHistory(w http.ResponseWriter, r *http.Req
 	slog.Info("menu history success")
 	bodyJsonStruct(w, history, http.StatusOK)
 }
+
+func (handMenu *menuHandToService) GetMenuCost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get Menu cost: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	cost, err := handMenu.menuServInt.MenuCost(id)
+	if err != nil {
+		slog.Error("Get Menu cost", "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "menu", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "menu cost", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, cost, http.StatusOK)
+}
+
+// PutMenuAvailability: {"available": false} takes the item off the menu (86)
+func (handMenu *menuHandToService) PutMenuAvailability(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put Menu availability: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put Menu availability: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var body struct {
+		Available *bool `json:"available"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil || body.Available == nil {
+		slog.Error("Put Menu availability: invalid body")
+		writeHttp(w, http.StatusBadRequest, "input json", `{"available": true|false} is required`)
+		return
+	}
+
+	err = handMenu.menuServInt.SwitchMenu(id, *body.Available)
+	if err != nil {
+		slog.Error("Put Menu availability", "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "menu", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "menu availability", err.Error())
+		}
+		return
+	}
+	slog.Info("menu availability", "id", id, "available", *body.Available)
+	writeHttp(w, http.StatusOK, "updated", "availability")
+}
+
+func (handMenu *menuHandToService) PutMenuSchedules(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put Menu schedules: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put Menu schedules: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var schedules []models.MenuSchedule
+	if err = json.NewDecoder(r.Body).Decode(&schedules); err != nil {
+		slog.Error("Put Menu schedules: Error in decoder")
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err = handMenu.menuServInt.UpgradeSchedules(id, schedules)
+	if err != nil {
+		slog.Error("Put Menu schedules", "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusUnprocessableEntity, "schedules", err.Error())
+		} else if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "menu", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "menu schedules", err.Error())
+		}
+		return
+	}
+	slog.Info("menu schedules", "id", id, "count", len(schedules))
+	bodyJsonStruct(w, schedules, http.StatusOK)
+}
+
+// PostScheduledPrice: {"price": 5.00, "effective_at": "2026-11-01T00:00:00+05:00"}, variant_id is optional
+func (handMenu *menuHandToService) PostScheduledPrice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Post scheduled price: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post scheduled price: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var change models.ScheduledPrice
+	if err = json.NewDecoder(r.Body).Decode(&change); err != nil {
+		slog.Error("incorrect input to post scheduled price", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	change.ProductID = id
+	err = handMenu.menuServInt.SchedulePrice(&change)
+	if err != nil {
+		slog.Error("Post scheduled price", "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusUnprocessableEntity, "scheduled price", err.Error())
+		} else if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "scheduled price", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "scheduled price", err.Error())
+		}
+		return
+	}
+	slog.Info("price scheduled", "product", id, "change", change.ID)
+	bodyJsonStruct(w, change, http.StatusCreated)
+}
+
+func (handMenu *menuHandToService) GetScheduledPrices(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get scheduled prices: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	changes, err := handMenu.menuServInt.CollectScheduledPrices(id)
+	if err != nil {
+		slog.Error("Get scheduled prices", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "scheduled prices", err.Error())
+		return
+	}
+	bodyJsonStruct(w, changes, http.StatusOK)
+}
+
+func (handMenu *menuHandToService) DelScheduledPrice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Del scheduled price: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	changeID, err := strconv.ParseUint(r.PathValue("changeID"), 10, 0)
+	if err != nil {
+		slog.Error("Del scheduled price: invalid change id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid change id")
+		return
+	}
+
+	err = handMenu.menuServInt.CancelScheduledPrice(id, changeID)
+	if err != nil {
+		slog.Error("Del scheduled price", "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "scheduled price", err.Error())
+		} else if errors.Is(err, models.ErrConflict) {
+			writeHttp(w, http.StatusConflict, "scheduled price", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "scheduled price", err.Error())
+		}
+		return
+	}
+	slog.Info("Deleted: ", "scheduled price", changeID)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
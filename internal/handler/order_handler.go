@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
@@ -22,7 +26,15 @@ type ordHandInt interface {
 	PostOrder(w http.ResponseWriter, r *http.Request)
 	PutOrderByID(w http.ResponseWriter, r *http.Request)
 	PostOrdCloseById(w http.ResponseWriter, r *http.Request)
+	PostOrdPreparingById(w http.ResponseWriter, r *http.Request)
+	PostOrdReadyById(w http.ResponseWriter, r *http.Request)
+	PostOrdPickedUpById(w http.ResponseWriter, r *http.Request)
+	PostOrdCancelById(w http.ResponseWriter, r *http.Request)
+	PostOrdRefundById(w http.ResponseWriter, r *http.Request)
+	GetOrderRefunds(w http.ResponseWriter, r *http.Request)
+	GetPickupQueue(w http.ResponseWriter, r *http.Request)
 	BatchProcess(w http.ResponseWriter, r *http.Request)
+	GetBatchJob(w http.ResponseWriter, r *http.Request)
 	GetAllStatusHistory(w http.ResponseWriter, r *http.Request)
 }
 
@@ -31,10 +43,29 @@ func ReturnOrdHaldStruct(ordSerInt service.OrdServiceInter) ordHandInt {
 }
 
 func (h *ordHandToService) GetOrders(w http.ResponseWriter, r *http.Request) {
-	orders, err := h.orderService.CollectOrders()
+	q := r.URL.Query()
+	query := models.OrderQuery{
+		Status:     q.Get("status"),
+		Customer:   q.Get("customer"),
+		CustomerID: q.Get("customerId"),
+		StartDate:  q.Get("startDate"),
+		EndDate:    q.Get("endDate"),
+		MinTotal:   q.Get("minTotal"),
+		MaxTotal:   q.Get("maxTotal"),
+		Product:    q.Get("product"),
+		SortBy:     q.Get("sortBy"),
+		Order:      q.Get("order"),
+		PageSize:   q.Get("pageSize"),
+		Cursor:     q.Get("cursor"),
+	}
+	orders, err := h.orderService.CollectOrders(&query)
 	if err != nil {
 		slog.Error("Get orders", "error", err)
-		writeHttp(w, http.StatusInternalServerError, "get orders: ", err.Error())
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "get orders", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "get orders: ", err.Error())
+		}
 		return
 	}
 	bodyJsonStruct(w, orders, http.StatusOK)
@@ -76,6 +107,8 @@ func (h *ordHandToService) DelOrderByID(w http.ResponseWriter, r *http.Request)
 		slog.Error("Delete order: ", "error id:", err)
 		if err == models.ErrNotFound {
 			writeHttp(w, http.StatusNotFound, "order", err.Error())
+		} else if errors.Is(err, models.ErrOrderStatusMove) || errors.Is(err, models.ErrConflict) {
+			writeHttp(w, http.StatusConflict, "order", err.Error())
 		} else {
 			writeHttp(w, http.StatusInternalServerError, "order", err.Error())
 		}
@@ -86,6 +119,10 @@ func (h *ordHandToService) DelOrderByID(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *ordHandToService) PostOrder(w http.ResponseWriter, r *http.Request) {
+	h.idempotent(w, r, "POST /orders", h.postOrder)
+}
+
+func (h *ordHandToService) postOrder(w http.ResponseWriter, r *http.Request) {
 	var orderStruct models.Order
 	if r.Header.Get("Content-Type") != "application/json" {
 		slog.Error("post the menu: content_Type must be application/json")
@@ -127,11 +164,21 @@ func (h *ordHandToService) PostOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if errors.Is(err, models.ErrUnavailableItems) {
+		bodyJsonStruct(w, orderStruct.Items, http.StatusConflict)
+		return
+	}
+
 	if errors.Is(err, models.ErrOrderNotEnoughItems) {
 		bodyJsonStruct(w, orderStruct.Items, http.StatusFailedDependency)
 		return
 	}
 
+	if errors.Is(err, models.ErrConflict) { // lost a race to other orders
+		writeHttp(w, http.StatusConflict, "Error post order", err.Error())
+		return
+	}
+
 	writeHttp(w, http.StatusInternalServerError, "Error post order", err.Error())
 }
 
@@ -177,11 +224,21 @@ func (h *ordHandToService) PutOrderByID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if errors.Is(err, models.ErrUnavailableItems) {
+		bodyJsonStruct(w, orderStruct.Items, http.StatusConflict)
+		return
+	}
+
 	if errors.Is(err, models.ErrOrderNotEnoughItems) {
 		bodyJsonStruct(w, orderStruct.Items, http.StatusFailedDependency)
 		return
 	}
 
+	if errors.Is(err, models.ErrConflict) { // paid
+		writeHttp(w, http.StatusConflict, "Error put order", err.Error())
+		return
+	}
+
 	writeHttp(w, http.StatusInternalServerError, "Error put order", err.Error())
 }
 
@@ -200,6 +257,8 @@ func (h *ordHandToService) PostOrdCloseById(w http.ResponseWriter, r *http.Reque
 			writeHttp(w, http.StatusNotFound, "order", err.Error())
 		} else if errors.Is(err, models.ErrOrderStatusClosed) {
 			writeHttp(w, http.StatusBadRequest, "order already", err.Error())
+		} else if errors.Is(err, models.ErrOrderNotPaid) {
+			writeHttp(w, http.StatusConflict, "close order", err.Error())
 		} else {
 			writeHttp(w, http.StatusInternalServerError, "close order", err.Error())
 		}
@@ -209,7 +268,160 @@ func (h *ordHandToService) PostOrdCloseById(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (h *ordHandToService) PostOrdPreparingById(w http.ResponseWriter, r *http.Request) {
+	h.moveOrder(w, r, "preparing")
+}
+
+func (h *ordHandToService) PostOrdReadyById(w http.ResponseWriter, r *http.Request) {
+	h.moveOrder(w, r, "ready")
+}
+
+func (h *ordHandToService) PostOrdPickedUpById(w http.ResponseWriter, r *http.Request) {
+	h.moveOrder(w, r, "picked_up")
+}
+
+func (h *ordHandToService) PostOrdCancelById(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Warn("Invalid id for cancel order")
+		writeHttp(w, http.StatusBadRequest, "Invalid id", "Check the order id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("cancel order: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var cancel struct {
+		Reason string `json:"reason"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&cancel); err != nil {
+		slog.Error("incorrect input to cancel order", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err = h.orderService.CancelOrder(id, cancel.Reason)
+	if err != nil {
+		slog.Error("Cancel order", "id", id, "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "cancel order", err.Error())
+		} else if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "order", err.Error())
+		} else if errors.Is(err, models.ErrOrderStatusMove) || errors.Is(err, models.ErrConflict) {
+			writeHttp(w, http.StatusConflict, "order cancelled", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "cancel order", err.Error())
+		}
+		return
+	}
+	slog.Info("order cancelled", "id", id)
+	writeHttp(w, http.StatusOK, "order", "cancelled")
+}
+
+func (h *ordHandToService) PostOrdRefundById(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Warn("Invalid id for refund order")
+		writeHttp(w, http.StatusBadRequest, "Invalid id", "Check the order id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("refund order: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var refund models.Refund
+	if err = json.NewDecoder(r.Body).Decode(&refund); err != nil {
+		slog.Error("incorrect input to refund order", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	refund.OrderID = id
+	err = h.orderService.RefundOrder(&refund)
+	if err != nil {
+		slog.Error("Refund order", "id", id, "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "refund order", err.Error())
+		} else if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "order", err.Error())
+		} else if errors.Is(err, models.ErrOrderStatusMove) || errors.Is(err, models.ErrConflict) {
+			writeHttp(w, http.StatusConflict, "order refunded", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "refund order", err.Error())
+		}
+		return
+	}
+	slog.Info("order refunded", "id", id, "refund", refund.ID, "amount", refund.Amount)
+	bodyJsonStruct(w, refund, http.StatusCreated)
+}
+
+func (h *ordHandToService) GetOrderRefunds(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Warn("Invalid id for order refunds")
+		writeHttp(w, http.StatusBadRequest, "Invalid id", "Check the order id")
+		return
+	}
+
+	refunds, err := h.orderService.CollectRefunds(id)
+	if err != nil {
+		slog.Error("Get order refunds", "id", id, "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "order", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "order refunds", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, refunds, http.StatusOK)
+}
+
+// GetPickupQueue: GET /orders/pickups?date=21.03.2024, pre-orders by their time slots
+func (h *ordHandToService) GetPickupQueue(w http.ResponseWriter, r *http.Request) {
+	queue, err := h.orderService.CollectPickups(r.URL.Query().Get("date"))
+	if err != nil {
+		slog.Error("Get pickup queue", "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "pickups", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "pickups", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, queue, http.StatusOK)
+}
+
+func (h *ordHandToService) moveOrder(w http.ResponseWriter, r *http.Request, status string) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Warn("Invalid id for order status")
+		writeHttp(w, http.StatusBadRequest, "Invalid id", "Check the order id")
+		return
+	}
+
+	err = h.orderService.MoveOrder(id, status)
+	if err != nil {
+		slog.Error("Move order", "status", status, "id", id, "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "order", err.Error())
+		} else if errors.Is(err, models.ErrOrderStatusMove) {
+			writeHttp(w, http.StatusConflict, "order "+status, err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "order "+status, err.Error())
+		}
+		return
+	}
+	slog.Info("order moved", "id", id, "status", status)
+	writeHttp(w, http.StatusOK, "order", status)
+}
+
 func (h *ordHandToService) BatchProcess(w http.ResponseWriter, r *http.Request) {
+	h.idempotent(w, r, "POST /orders/batch-process", h.batchProcess)
+}
+
+func (h *ordHandToService) batchProcess(w http.ResponseWriter, r *http.Request) {
 	var inputOrders models.PostSomeOrders
 	if r.Header.Get("Content-Type") != "application/json" {
 		slog.Error("batch: content_Type must be application/json")
@@ -223,8 +435,41 @@ func (h *ordHandToService) BatchProcess(w http.ResponseWriter, r *http.Request)
 		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
 		return
 	}
+	atomic := false
+	if atomicParam := r.URL.Query().Get("atomic"); len(atomicParam) != 0 {
+		if atomic, err = strconv.ParseBool(atomicParam); err != nil {
+			slog.Error("batch: invalid atomic", "error", err)
+			writeHttp(w, http.StatusBadRequest, "atomic", err.Error())
+			return
+		}
+	}
+	async := false
+	if asyncParam := r.URL.Query().Get("async"); len(asyncParam) != 0 {
+		if async, err = strconv.ParseBool(asyncParam); err != nil {
+			slog.Error("batch: invalid async", "error", err)
+			writeHttp(w, http.StatusBadRequest, "async", err.Error())
+			return
+		}
+	}
+	if async {
+		job, err := h.orderService.QueueBatch(inputOrders.Orders, atomic)
+		if err != nil {
+			slog.Error("batch: cannot queue job", "error", err)
+			writeHttp(w, http.StatusInternalServerError, "batch job", err.Error())
+			return
+		}
+		slog.Info("Bulk queued", "job", job.ID)
+		w.Header().Set("Location", "/orders/batch-process/"+strconv.FormatUint(job.ID, 10))
+		bodyJsonStruct(w, job, http.StatusAccepted)
+		return
+	}
+
 	bulk := models.OutputBatches{Processed: inputOrders.Orders}
-	err = h.orderService.CreateSomeOrders(&bulk)
+	if atomic {
+		err = h.orderService.CreateAllOrders(&bulk)
+	} else {
+		err = h.orderService.CreateSomeOrders(&bulk)
+	}
 	code := http.StatusOK // 200
 	if err != nil {
 		slog.Error("incorrect input to post order", "error", err)
@@ -236,6 +481,8 @@ func (h *ordHandToService) BatchProcess(w http.ResponseWriter, r *http.Request)
 			code = http.StatusTeapot // 418
 		} else if errors.Is(err, models.ErrNotFoundItems) {
 			code = http.StatusNotFound // 404
+		} else if errors.Is(err, models.ErrUnavailableItems) || errors.Is(err, models.ErrConflict) {
+			code = http.StatusConflict // 409
 		} else if errors.Is(err, models.ErrOrderNotEnoughItems) {
 			code = http.StatusFailedDependency // 424
 		} else {
@@ -246,6 +493,26 @@ func (h *ordHandToService) BatchProcess(w http.ResponseWriter, r *http.Request)
 	bodyJsonStruct(w, bulk, code)
 }
 
+func (h *ordHandToService) GetBatchJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("jobID"), 10, 0)
+	if err != nil {
+		slog.Warn("Invalid id for batch job")
+		writeHttp(w, http.StatusBadRequest, "Invalid id", "Check the job id")
+		return
+	}
+	job, err := h.orderService.TakeBatchJob(id)
+	if err != nil {
+		slog.Error("Get batch job", "id", id, "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "batch job", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "batch job", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, job, http.StatusOK)
+}
+
 func (h *ordHandToService) GetAllStatusHistory(w http.ResponseWriter, r *http.Request) {
 	history, err := h.orderService.CollectStatusHistory()
 	if err != nil {
@@ -256,3 +523,66 @@ func (h *ordHandToService) GetAllStatusHistory(w http.ResponseWriter, r *http.Re
 	slog.Info("order status history succes")
 	bodyJsonStruct(w, history, http.StatusOK)
 }
+
+// idempotent runs next only once for the same Idempotency-Key header, repeats get the saved response
+func (h *ordHandToService) idempotent(w http.ResponseWriter, r *http.Request, endpoint string, next http.HandlerFunc) {
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) == 0 {
+		next(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.Error("idempotency: cannot read body", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input body", err.Error())
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	// the same body with another ?atomic= or ?async= is another request
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"?"+r.URL.Query().Encode()+"\n")
+	hash.Write(body)
+	idem := models.Idempotency{Key: key, Endpoint: endpoint, RequestHash: hex.EncodeToString(hash.Sum(nil))}
+
+	saved, err := h.orderService.BeginIdempotent(&idem)
+	if err != nil {
+		slog.Error("idempotency", "key", key, "error", err)
+		if errors.Is(err, models.ErrIdempotencyMismatch) {
+			writeHttp(w, http.StatusUnprocessableEntity, "Idempotency-Key", err.Error())
+		} else if errors.Is(err, models.ErrIdempotencyBusy) {
+			writeHttp(w, http.StatusConflict, "Idempotency-Key", err.Error())
+		} else if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "Idempotency-Key", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "Idempotency-Key", err.Error())
+		}
+		return
+	}
+	if saved != nil {
+		slog.Info("idempotency: replayed", "key", key)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(saved.StatusCode)
+		w.Write(saved.Response)
+		return
+	}
+
+	// the key is freed if the handler panics, the panic goes on to net/http
+	defer func() {
+		if p := recover(); p != nil {
+			idem.StatusCode = http.StatusInternalServerError
+			if err := h.orderService.FinishIdempotent(&idem); err != nil {
+				slog.Error("idempotency: cannot free key", "key", key, "error", err)
+			}
+			panic(p)
+		}
+	}()
+	rec := responseRecorder{ResponseWriter: w}
+	next(&rec, r)
+
+	idem.StatusCode, idem.Response = rec.code, rec.body.Bytes()
+	if err = h.orderService.FinishIdempotent(&idem); err != nil {
+		slog.Error("idempotency: cannot save response", "key", key, "error", err)
+	}
+}
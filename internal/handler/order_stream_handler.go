@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type orderStreamHandToService struct {
+	streamServInt service.OrderStreamServiceInter
+}
+
+type OrderStreamHandInter interface {
+	GetOrderStream(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnOrderStreamHandStruct(streamSerInt service.OrderStreamServiceInter) OrderStreamHandInter {
+	return &orderStreamHandToService{streamServInt: streamSerInt}
+}
+
+// GetOrderStream is Server-Sent Events: order_created, order_updated, order_status_changed
+// and order_deleted with the event as data. A comment every 15 seconds keeps the connection
+func (h *orderStreamHandToService) GetOrderStream(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.streamServInt.Subscribe(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		slog.Error("Order stream", "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "order stream", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "order stream", err.Error())
+		}
+		return
+	}
+	defer sub.Cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx must not buffer it
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	sent := make(map[uint64]struct{}, len(sub.Missed))
+	for _, ev := range sub.Missed {
+		if err = h.writeEvent(w, ev); err != nil {
+			return
+		}
+		sent[ev.ID] = struct{}{}
+	}
+	if err = rc.Flush(); err != nil {
+		slog.Error("Order stream: cannot flush", "error", err)
+		return
+	}
+	slog.Info("Order stream client connected", "resumed", len(sub.Missed))
+
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.Events:
+			if !ok { // too slow, it reconnects with Last-Event-ID
+				return
+			}
+			if _, x := sent[ev.ID]; x {
+				continue
+			}
+			err = h.writeEvent(w, ev)
+		case <-ping.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			slog.Info("Order stream client gone", "error", err)
+			return
+		}
+	}
+}
+
+func (h *orderStreamHandToService) writeEvent(w io.Writer, ev models.OrderEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: order_%s\ndata: %s\n\n", ev.ID, ev.Kind, data)
+	return err
+}
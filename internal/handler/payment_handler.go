@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type paymentHandToService struct {
+	paymentServInt service.PaymentServiceInter
+}
+
+type PaymentHandInter interface {
+	GetPayments(w http.ResponseWriter, r *http.Request)
+	PostPayment(w http.ResponseWriter, r *http.Request)
+	PostPaymentVoid(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnPaymentHandStruct(paymentSerInt service.PaymentServiceInter) PaymentHandInter {
+	return &paymentHandToService{paymentServInt: paymentSerInt}
+}
+
+func (h *paymentHandToService) GetPayments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get payments: invalid order id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	payments, err := h.paymentServInt.CollectPayments(id)
+	if err != nil {
+		slog.Error("Get payments", "order", id, "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	bodyJsonStruct(w, payments, http.StatusOK)
+}
+
+func (h *paymentHandToService) PostPayment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Post payment: invalid order id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post payment: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var pay models.Payment
+	if err = json.NewDecoder(r.Body).Decode(&pay); err != nil {
+		slog.Error("incorrect input to post payment", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	pay.OrderID = id
+	err = h.paymentServInt.CreatePayment(&pay)
+	if err != nil {
+		slog.Error("Post payment", "order", id, "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("payment created", "order", id, "payment", pay.ID, "method", pay.Method, "amount", pay.Amount)
+	bodyJsonStruct(w, pay, http.StatusCreated)
+}
+
+func (h *paymentHandToService) PostPaymentVoid(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Void payment: invalid order id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	paymentID, err := strconv.ParseUint(r.PathValue("paymentID"), 10, 0)
+	if err != nil {
+		slog.Error("Void payment: invalid payment id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid payment id")
+		return
+	}
+
+	err = h.paymentServInt.CancelPayment(id, paymentID)
+	if err != nil {
+		slog.Error("Void payment", "order", id, "payment", paymentID, "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("payment voided", "order", id, "payment", paymentID)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+func (h *paymentHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "payment", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "payment", err.Error())
+	case errors.Is(err, models.ErrConflict), errors.Is(err, models.ErrOrderStatusClosed):
+		writeHttp(w, http.StatusConflict, "payment", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "payment", err.Error())
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type priceRuleHandToService struct {
+	ruleServInt service.PriceRuleServiceInter
+}
+
+type PriceRuleHandInter interface {
+	GetPriceRules(w http.ResponseWriter, r *http.Request)
+	GetPriceRuleByID(w http.ResponseWriter, r *http.Request)
+	PostPriceRule(w http.ResponseWriter, r *http.Request)
+	PutPriceRuleByID(w http.ResponseWriter, r *http.Request)
+	DelPriceRule(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnPriceRuleHandStruct(ruleSerInt service.PriceRuleServiceInter) PriceRuleHandInter {
+	return &priceRuleHandToService{ruleServInt: ruleSerInt}
+}
+
+func (h *priceRuleHandToService) GetPriceRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.ruleServInt.CollectPriceRules()
+	if err != nil {
+		slog.Error("Get price rules", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get price rules", err.Error())
+		return
+	}
+	bodyJsonStruct(w, rules, http.StatusOK)
+	slog.Info("Get all price rules")
+}
+
+func (h *priceRuleHandToService) GetPriceRuleByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get price rule: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	rule, err := h.ruleServInt.TakePriceRule(id)
+	if err != nil {
+		slog.Error("Get price rule", "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "price rule", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "get price rule", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, rule, http.StatusOK)
+}
+
+func (h *priceRuleHandToService) PostPriceRule(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post price rule: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var rule models.PriceRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		slog.Error("incorrect input to post price rule", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.ruleServInt.CreatePriceRule(&rule)
+	if err != nil {
+		slog.Error("Post price rule", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("price rule created", "id", rule.ID)
+	bodyJsonStruct(w, rule, http.StatusCreated)
+}
+
+func (h *priceRuleHandToService) PutPriceRuleByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put price rule: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put price rule: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var rule models.PriceRule
+	if err = json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		slog.Error("incorrect input to put price rule", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	rule.ID = id
+	err = h.ruleServInt.UpgradePriceRule(&rule)
+	if err != nil {
+		slog.Error("Put price rule", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("price rule updated", "id", id)
+	bodyJsonStruct(w, rule, http.StatusOK)
+}
+
+func (h *priceRuleHandToService) DelPriceRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Del price rule: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	err = h.ruleServInt.DelPriceRule(id)
+	if err != nil {
+		slog.Error("Delete price rule", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("Deleted: ", "price rule by id :", id)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+func (h *priceRuleHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "price rule", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "price rule", err.Error())
+	case errors.Is(err, models.ErrConflict):
+		writeHttp(w, http.StatusConflict, "price rule", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "price rule", err.Error())
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type promoHandToService struct {
+	promoServInt service.PromoServiceInter
+}
+
+type PromoHandInter interface {
+	GetPromos(w http.ResponseWriter, r *http.Request)
+	GetPromoByID(w http.ResponseWriter, r *http.Request)
+	PostPromo(w http.ResponseWriter, r *http.Request)
+	PutPromoByID(w http.ResponseWriter, r *http.Request)
+	DelPromo(w http.ResponseWriter, r *http.Request)
+	GetPromoRedemptions(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnPromoHandStruct(promoSerInt service.PromoServiceInter) PromoHandInter {
+	return &promoHandToService{promoServInt: promoSerInt}
+}
+
+func (h *promoHandToService) GetPromos(w http.ResponseWriter, r *http.Request) {
+	promos, err := h.promoServInt.CollectPromos()
+	if err != nil {
+		slog.Error("Get promo codes", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get promo codes", err.Error())
+		return
+	}
+	bodyJsonStruct(w, promos, http.StatusOK)
+	slog.Info("Get all promo codes")
+}
+
+func (h *promoHandToService) GetPromoByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get promo code: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	promo, err := h.promoServInt.TakePromo(id)
+	if err != nil {
+		slog.Error("Get promo code", "error", err)
+		if errors.Is(err, models.ErrNotFound) {
+			writeHttp(w, http.StatusNotFound, "promo code", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "get promo code", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, promo, http.StatusOK)
+}
+
+func (h *promoHandToService) PostPromo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post promo code: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var promo models.PromoCode
+	if err := json.NewDecoder(r.Body).Decode(&promo); err != nil {
+		slog.Error("incorrect input to post promo code", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.promoServInt.CreatePromo(&promo)
+	if err != nil {
+		slog.Error("Post promo code", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("promo code created", "id", promo.ID)
+	bodyJsonStruct(w, promo, http.StatusCreated)
+}
+
+func (h *promoHandToService) PutPromoByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put promo code: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put promo code: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var promo models.PromoCode
+	if err = json.NewDecoder(r.Body).Decode(&promo); err != nil {
+		slog.Error("incorrect input to put promo code", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	promo.ID = id
+	err = h.promoServInt.UpgradePromo(&promo)
+	if err != nil {
+		slog.Error("Put promo code", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("promo code updated", "id", id)
+	bodyJsonStruct(w, promo, http.StatusOK)
+}
+
+func (h *promoHandToService) DelPromo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Del promo code: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	err = h.promoServInt.DelPromo(id)
+	if err != nil {
+		slog.Error("Delete promo code", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("Deleted: ", "promo code by id :", id)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+func (h *promoHandToService) GetPromoRedemptions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get promo redemptions: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	redemptions, err := h.promoServInt.CollectRedemptions(id)
+	if err != nil {
+		slog.Error("Get promo redemptions", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	bodyJsonStruct(w, redemptions, http.StatusOK)
+}
+
+func (h *promoHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "promo code", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "promo code", err.Error())
+	case errors.Is(err, models.ErrConflict):
+		writeHttp(w, http.StatusConflict, "promo code", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "promo code", err.Error())
+	}
+}
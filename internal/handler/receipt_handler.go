@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type receiptHandToService struct {
+	receiptServInt service.ReceiptServiceInter
+}
+
+type ReceiptHandInter interface {
+	GetReceipt(w http.ResponseWriter, r *http.Request)
+	GetKitchenTicket(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnReceiptHandStruct(receiptSerInt service.ReceiptServiceInter) ReceiptHandInter {
+	return &receiptHandToService{receiptServInt: receiptSerInt}
+}
+
+func (h *receiptHandToService) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get receipt: invalid order id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	printout, err := h.receiptServInt.Receipt(id, r.URL.Query().Get("format"))
+	if err != nil {
+		slog.Error("Get receipt", "order", id, "error", err)
+		h.writeErr(w, "receipt", err)
+		return
+	}
+	h.writePrintout(w, printout)
+}
+
+func (h *receiptHandToService) GetKitchenTicket(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Get kitchen ticket: invalid order id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	printout, err := h.receiptServInt.KitchenTicket(id, r.URL.Query().Get("format"))
+	if err != nil {
+		slog.Error("Get kitchen ticket", "order", id, "error", err)
+		h.writeErr(w, "kitchen ticket", err)
+		return
+	}
+	h.writePrintout(w, printout)
+}
+
+func (h *receiptHandToService) writePrintout(w http.ResponseWriter, printout *models.Printout) {
+	w.Header().Set("Content-Type", printout.ContentType)
+	if len(printout.FileName) != 0 {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+printout.FileName+`"`)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(printout.Body); err != nil {
+		slog.Error("cannot write the printout", "error", err)
+	}
+}
+
+func (h *receiptHandToService) writeErr(w http.ResponseWriter, where string, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusBadRequest, where, err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, where, err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, where, err.Error())
+	}
+}
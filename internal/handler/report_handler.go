@@ -1,10 +1,12 @@
 package handler
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 
 	"frappuccino/internal/service"
+	"frappuccino/models"
 )
 
 type aggregationHandler struct {
@@ -18,6 +20,9 @@ type AggregationHandInter interface {
 	FullTextSearchReport(w http.ResponseWriter, r *http.Request)
 	PeriodOrderedItems(w http.ResponseWriter, r *http.Request)
 	GetLeftOvers(w http.ResponseWriter, r *http.Request)
+	LowMargin(w http.ResponseWriter, r *http.Request)
+	Tenders(w http.ResponseWriter, r *http.Request)
+	CustomerValue(w http.ResponseWriter, r *http.Request)
 }
 
 func ReturnAggregationHandInter(aggreSer service.AggregationServiceInter) AggregationHandInter {
@@ -29,10 +34,8 @@ func (h *aggregationHandler) TotalSales(w http.ResponseWriter, r *http.Request)
 		slog.Error("Get total sales", "error", err)
 		writeHttp(w, http.StatusInternalServerError, "failed to get total sales:", err.Error())
 	} else {
-		slog.Info("Succes", "Get total sales:", total)
-		bodyJsonStruct(w, struct {
-			Total_sales float64 // `json: "total_sales"`
-		}{total}, http.StatusOK)
+		slog.Info("Succes", "Get total sales:", total.TotalSales)
+		bodyJsonStruct(w, total, http.StatusOK)
 
 		slog.Info("succes")
 	}
@@ -112,3 +115,48 @@ func (h *aggregationHandler) GetLeftOvers(w http.ResponseWriter, r *http.Request
 	bodyJsonStruct(w, overs, http.StatusOK)
 	slog.Info("Get", "overs", "OK")
 }
+
+func (h *aggregationHandler) LowMargin(w http.ResponseWriter, r *http.Request) {
+	low, err := h.aggreService.LowMarginItems(r.URL.Query().Get("threshold"))
+	if err != nil {
+		slog.Error("Get low margin items", "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "low margin", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "failed to get low margin items:", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, low, http.StatusOK)
+	slog.Info("Get low margin items", "count", len(low.Items))
+}
+
+func (h *aggregationHandler) Tenders(w http.ResponseWriter, r *http.Request) {
+	sum, err := h.aggreService.TenderReport(r.URL.Query().Get("date"))
+	if err != nil {
+		slog.Error("Get tender summary", "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "tenders", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "failed to get tender summary:", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, sum, http.StatusOK)
+	slog.Info("Get tender summary", "date", sum.Date, "amount", sum.Amount)
+}
+
+func (h *aggregationHandler) CustomerValue(w http.ResponseWriter, r *http.Request) {
+	values, err := h.aggreService.CustomerValues(r.URL.Query().Get("sortBy"), r.URL.Query().Get("limit"))
+	if err != nil {
+		slog.Error("Get customer value", "error", err)
+		if errors.Is(err, models.ErrBadInput) {
+			writeHttp(w, http.StatusBadRequest, "customer value", err.Error())
+		} else {
+			writeHttp(w, http.StatusInternalServerError, "failed to get customer value:", err.Error())
+		}
+		return
+	}
+	bodyJsonStruct(w, values, http.StatusOK)
+	slog.Info("Get customer value", "count", len(values))
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type storeHoursHandToService struct {
+	storeHoursServInt service.StoreHoursServiceInter
+}
+
+type StoreHoursHandInter interface {
+	GetStoreHours(w http.ResponseWriter, r *http.Request)
+	PutPickupSettings(w http.ResponseWriter, r *http.Request)
+	PutStoreHoursByWeekday(w http.ResponseWriter, r *http.Request)
+	DelStoreHours(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnStoreHoursHandStruct(storeHoursSerInt service.StoreHoursServiceInter) StoreHoursHandInter {
+	return &storeHoursHandToService{storeHoursServInt: storeHoursSerInt}
+}
+
+func (h *storeHoursHandToService) GetStoreHours(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.storeHoursServInt.TakePickupSettings()
+	if err != nil {
+		slog.Error("Get store hours", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get store hours", err.Error())
+		return
+	}
+	bodyJsonStruct(w, settings, http.StatusOK)
+}
+
+func (h *storeHoursHandToService) PutPickupSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put pickup settings: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var settings models.PickupSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		slog.Error("incorrect input to put pickup settings", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.storeHoursServInt.SwitchPickupSettings(&settings)
+	if err != nil {
+		slog.Error("Put pickup settings", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("pickup settings updated", "slot_minutes", *settings.SlotMinutes, "slot_capacity", *settings.SlotCapacity)
+	bodyJsonStruct(w, settings, http.StatusOK)
+}
+
+func (h *storeHoursHandToService) PutStoreHoursByWeekday(w http.ResponseWriter, r *http.Request) {
+	weekday, err := strconv.ParseUint(r.PathValue("weekday"), 10, 0)
+	if err != nil {
+		slog.Error("Put store hours: invalid weekday")
+		writeHttp(w, http.StatusBadRequest, "weekday", "Invalid weekday")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put store hours: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var hours models.StoreHours
+	if err = json.NewDecoder(r.Body).Decode(&hours); err != nil {
+		slog.Error("incorrect input to put store hours", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	hours.Weekday = weekday
+	err = h.storeHoursServInt.SetStoreHours(&hours)
+	if err != nil {
+		slog.Error("Put store hours", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("store hours updated", "weekday", weekday)
+	bodyJsonStruct(w, hours, http.StatusOK)
+}
+
+func (h *storeHoursHandToService) DelStoreHours(w http.ResponseWriter, r *http.Request) {
+	weekday, err := strconv.ParseUint(r.PathValue("weekday"), 10, 0)
+	if err != nil {
+		slog.Error("Del store hours: invalid weekday")
+		writeHttp(w, http.StatusBadRequest, "weekday", "Invalid weekday")
+		return
+	}
+
+	err = h.storeHoursServInt.CloseStoreDay(weekday)
+	if err != nil {
+		slog.Error("Delete store hours", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("Deleted: ", "store hours of weekday :", weekday)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+func (h *storeHoursHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "store hours", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "store hours", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "store hours", err.Error())
+	}
+}
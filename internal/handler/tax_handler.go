@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"frappuccino/internal/service"
+	"frappuccino/models"
+)
+
+type taxHandToService struct {
+	taxServInt service.TaxServiceInter
+}
+
+type TaxHandInter interface {
+	GetTaxes(w http.ResponseWriter, r *http.Request)
+	PutTaxMode(w http.ResponseWriter, r *http.Request)
+	PostTaxRate(w http.ResponseWriter, r *http.Request)
+	PutTaxRateByID(w http.ResponseWriter, r *http.Request)
+	DelTaxRate(w http.ResponseWriter, r *http.Request)
+}
+
+func ReturnTaxHandStruct(taxSerInt service.TaxServiceInter) TaxHandInter {
+	return &taxHandToService{taxServInt: taxSerInt}
+}
+
+func (h *taxHandToService) GetTaxes(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.taxServInt.TakeTaxSettings()
+	if err != nil {
+		slog.Error("Get taxes", "error", err)
+		writeHttp(w, http.StatusInternalServerError, "get taxes", err.Error())
+		return
+	}
+	bodyJsonStruct(w, settings, http.StatusOK)
+}
+
+func (h *taxHandToService) PutTaxMode(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put tax mode: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var settings models.TaxSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		slog.Error("incorrect input to put tax mode", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.taxServInt.SwitchTaxMode(&settings)
+	if err != nil {
+		slog.Error("Put tax mode", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("tax mode updated", "prices_include_tax", *settings.PricesIncludeTax)
+	bodyJsonStruct(w, settings, http.StatusOK)
+}
+
+func (h *taxHandToService) PostTaxRate(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Post tax rate: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var rate models.TaxRate
+	if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+		slog.Error("incorrect input to post tax rate", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	err := h.taxServInt.CreateTaxRate(&rate)
+	if err != nil {
+		slog.Error("Post tax rate", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("tax rate created", "id", rate.ID)
+	bodyJsonStruct(w, rate, http.StatusCreated)
+}
+
+func (h *taxHandToService) PutTaxRateByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Put tax rate: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		slog.Error("Put tax rate: content_Type must be application/json")
+		writeHttp(w, http.StatusUnsupportedMediaType, "content/type", "not json")
+		return
+	}
+	var rate models.TaxRate
+	if err = json.NewDecoder(r.Body).Decode(&rate); err != nil {
+		slog.Error("incorrect input to put tax rate", "error", err)
+		writeHttp(w, http.StatusBadRequest, "input json", err.Error())
+		return
+	}
+
+	rate.ID = id
+	err = h.taxServInt.UpgradeTaxRate(&rate)
+	if err != nil {
+		slog.Error("Put tax rate", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("tax rate updated", "id", id)
+	bodyJsonStruct(w, rate, http.StatusOK)
+}
+
+func (h *taxHandToService) DelTaxRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		slog.Error("Del tax rate: invalid id")
+		writeHttp(w, http.StatusBadRequest, "ID", "Invalid id")
+		return
+	}
+
+	err = h.taxServInt.DelTaxRate(id)
+	if err != nil {
+		slog.Error("Delete tax rate", "error", err)
+		h.writeErr(w, err)
+		return
+	}
+	slog.Info("Deleted: ", "tax rate by id :", id)
+	writeHttp(w, http.StatusNoContent, "", "")
+}
+
+func (h *taxHandToService) writeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBadInput):
+		writeHttp(w, http.StatusUnprocessableEntity, "tax", err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		writeHttp(w, http.StatusNotFound, "tax", err.Error())
+	case errors.Is(err, models.ErrConflict):
+		writeHttp(w, http.StatusConflict, "tax", err.Error())
+	default:
+		writeHttp(w, http.StatusInternalServerError, "tax", err.Error())
+	}
+}
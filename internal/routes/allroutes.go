@@ -1,28 +1,64 @@
 package router
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/jmoiron/sqlx"
 )
 
-func Allrouter(db *sqlx.DB) *http.ServeMux {
+// startFunc starts the background goroutines of a router's services, they stop when ctx is done
+type startFunc func(ctx context.Context) error
+
+// dsn is for the connections out of the pool (LISTEN of GET /orders/stream).
+// Allrouter only builds the routes. The returned start runs the background work
+// (price scheduler, batch workers, order events stream), the caller decides when
+func Allrouter(db *sqlx.DB, dsn string) (*http.ServeMux, startFunc) {
 	muxRoot := http.NewServeMux()
 
 	inventoryRouter := inventoryRouter(db)
 
 	addPrefixToRouter("/inventory", muxRoot, inventoryRouter)
 
-	menuMux := menuRouter(db)
+	menuMux, startMenu := menuRouter(db)
 	addPrefixToRouter("/menu", muxRoot, menuMux)
 
-	orderMux := orderRouter(db)
+	// "/menu/categories/" and "/menu/price-rules/" are longer, so they are not caught by "/menu/{id}"
+	categoryMux := categoryRouter(db)
+	addPrefixToRouter("/menu/categories", muxRoot, categoryMux)
+	priceRuleMux := priceRuleRouter(db)
+	addPrefixToRouter("/menu/price-rules", muxRoot, priceRuleMux)
+
+	orderMux, startOrder := orderRouter(db, dsn)
 	addPrefixToRouter("/orders", muxRoot, orderMux)
 
+	customerMux := customerRouter(db)
+	addPrefixToRouter("/customers", muxRoot, customerMux)
+
+	loyaltyMux := loyaltyRouter(db)
+	addPrefixToRouter("/loyalty", muxRoot, loyaltyMux)
+
+	promoMux := promoRouter(db)
+	addPrefixToRouter("/promo-codes", muxRoot, promoMux)
+
+	taxMux := taxRouter(db)
+	addPrefixToRouter("/taxes", muxRoot, taxMux)
+
+	storeHoursMux := storeHoursRouter(db)
+	addPrefixToRouter("/store-hours", muxRoot, storeHoursMux)
+
 	reports := aggregationReportRouter(db)
 	addPrefixToRouter("/reports", muxRoot, reports)
 
-	return muxRoot
+	start := func(ctx context.Context) error {
+		for _, start := range []startFunc{startMenu, startOrder} {
+			if err := start(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return muxRoot, start
 }
 
 func addPrefixToRouter(prefix string, mux, child *http.ServeMux) {
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"net/http"
+
+	"frappuccino/internal/dal"
+	"frappuccino/internal/handler"
+	"frappuccino/internal/service"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func categoryRouter(db *sqlx.DB) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dalCategoryInter := dal.ReturnDalCategory(db)
+	categorySerInter := service.ReturnCategorySerStruct(dalCategoryInter)
+	handCategory := handler.ReturnCategoryHandStruct(categorySerInter)
+
+	mux.HandleFunc("GET /", handCategory.GetCategories)
+	mux.HandleFunc("GET /{id}", handCategory.GetCategoryByID)
+	mux.HandleFunc("POST /", handCategory.PostCategory)
+	mux.HandleFunc("PUT /{id}", handCategory.PutCategoryByID)
+	mux.HandleFunc("DELETE /{id}", handCategory.DelCategory)
+	return mux
+}
@@ -0,0 +1,31 @@
+package router
+
+import (
+	"net/http"
+
+	"frappuccino/internal/dal"
+	"frappuccino/internal/handler"
+	"frappuccino/internal/service"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func customerRouter(db *sqlx.DB) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dalCustomerInter := dal.ReturnDalCustomer(db)
+	serOrderInter := service.ReturnOrdSerStruct(dal.ReturnDulOrderDB(db))
+	customerSerInter := service.ReturnCustomerSerStruct(dalCustomerInter, serOrderInter)
+	handCustomer := handler.ReturnCustomerHandStruct(customerSerInter)
+	handLoyalty := handler.ReturnLoyaltyHandStruct(service.ReturnLoyaltySerStruct(dal.ReturnDalLoyalty(db)))
+
+	mux.HandleFunc("GET /", handCustomer.GetCustomers)
+	mux.HandleFunc("GET /{id}", handCustomer.GetCustomerByID)
+	mux.HandleFunc("POST /", handCustomer.PostCustomer)
+	mux.HandleFunc("PUT /{id}", handCustomer.PutCustomerByID)
+	mux.HandleFunc("DELETE /{id}", handCustomer.DelCustomer)
+	mux.HandleFunc("GET /{id}/orders", handCustomer.GetCustomerOrders)
+	mux.HandleFunc("GET /{id}/loyalty", handLoyalty.GetCustomerLoyalty)
+	mux.HandleFunc("POST /{id}/loyalty", handLoyalty.PostCustomerLoyalty)
+	return mux
+}
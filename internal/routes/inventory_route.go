@@ -24,5 +24,7 @@ func inventoryRouter(db *sqlx.DB) *http.ServeMux {
 	mux.HandleFunc("DELETE /{id}", handInvInt.DeleteInventory)
 	mux.HandleFunc("GET /history", handInvInt.GetInventoryHistory)
 	mux.HandleFunc("GET /reorder", handInvInt.GetReorderInventories)
+	mux.HandleFunc("PUT /{id}/recipe", handInvInt.PutPrepRecipe)
+	mux.HandleFunc("POST /{id}/produce", handInvInt.PostProducePrep)
 	return mux
 }
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"net/http"
+
+	"frappuccino/internal/dal"
+	"frappuccino/internal/handler"
+	"frappuccino/internal/service"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func loyaltyRouter(db *sqlx.DB) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dalLoyaltyInter := dal.ReturnDalLoyalty(db)
+	loyaltySerInter := service.ReturnLoyaltySerStruct(dalLoyaltyInter)
+	handLoyalty := handler.ReturnLoyaltyHandStruct(loyaltySerInter)
+
+	mux.HandleFunc("GET /", handLoyalty.GetLoyalty)
+	mux.HandleFunc("PUT /", handLoyalty.PutPointValue)
+	mux.HandleFunc("POST /rules", handLoyalty.PostLoyaltyRule)
+	mux.HandleFunc("PUT /rules/{id}", handLoyalty.PutLoyaltyRuleByID)
+	mux.HandleFunc("DELETE /rules/{id}", handLoyalty.DelLoyaltyRule)
+	return mux
+}
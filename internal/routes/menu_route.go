@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"net/http"
 
 	"frappuccino/internal/dal"
@@ -10,12 +11,16 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-func menuRouter(db *sqlx.DB) *http.ServeMux {
+func menuRouter(db *sqlx.DB) (*http.ServeMux, startFunc) {
 	mux := http.NewServeMux()
 
 	var dalMenuInter dal.MenuDalInter = dal.ReturnDalMenuCore(db)
 	menuSerInter := service.ReturnMenuSerStruct(dalMenuInter)
 	handMenu := handler.ReturnMenuHaldStruct(menuSerInter)
+	start := func(ctx context.Context) error {
+		menuSerInter.StartPriceScheduler(ctx)
+		return nil
+	}
 
 	mux.HandleFunc("GET /", handMenu.GetMenus)
 	mux.HandleFunc("GET /{id}", handMenu.GetMenuByID)
@@ -23,5 +28,11 @@ func menuRouter(db *sqlx.DB) *http.ServeMux {
 	mux.HandleFunc("POST /", handMenu.PostMenu)
 	mux.HandleFunc("PUT /{id}", handMenu.PutMenuByID)
 	mux.HandleFunc("GET /history", handMenu.GetHistory)
-	return mux
+	mux.HandleFunc("GET /{id}/cost", handMenu.GetMenuCost)
+	mux.HandleFunc("PUT /{id}/availability", handMenu.PutMenuAvailability)
+	mux.HandleFunc("PUT /{id}/schedules", handMenu.PutMenuSchedules)
+	mux.HandleFunc("POST /{id}/prices", handMenu.PostScheduledPrice)
+	mux.HandleFunc("GET /{id}/prices", handMenu.GetScheduledPrices)
+	mux.HandleFunc("DELETE /{id}/prices/{changeID}", handMenu.DelScheduledPrice)
+	return mux, start
 }
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"net/http"
 
 	"frappuccino/internal/dal"
@@ -10,19 +11,44 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-func orderRouter(db *sqlx.DB) *http.ServeMux {
+func orderRouter(db *sqlx.DB, dsn string) (*http.ServeMux, startFunc) {
 	mux := http.NewServeMux()
 	var dalOrdInter dal.OrderDalInter = dal.ReturnDulOrderDB(db)
 	var serOrderInter service.OrdServiceInter = service.ReturnOrdSerStruct(dalOrdInter)
+	serStreamInter := service.ReturnOrderStreamSerStruct(dal.ReturnDalOrderEvent(db, dsn), dalOrdInter)
+	start := func(ctx context.Context) error {
+		if err := serOrderInter.StartBatchWorkers(ctx); err != nil {
+			return err
+		}
+		return serStreamInter.StartStream(ctx)
+	}
 	handOrd := handler.ReturnOrdHaldStruct(serOrderInter)
+	handStream := handler.ReturnOrderStreamHandStruct(serStreamInter)
+	var dalPaymentInter dal.PaymentDalInter = dal.ReturnDalPayment(db)
+	handPayment := handler.ReturnPaymentHandStruct(service.ReturnPaymentSerStruct(dalPaymentInter))
+	handReceipt := handler.ReturnReceiptHandStruct(service.ReturnReceiptSerStruct(dalOrdInter, dalPaymentInter))
 
 	mux.HandleFunc("GET /", handOrd.GetOrders)
 	mux.HandleFunc("GET /{id}", handOrd.GetOrderByID)
+	mux.HandleFunc("GET /stream", handStream.GetOrderStream)
+	mux.HandleFunc("GET /pickups", handOrd.GetPickupQueue)
 	mux.HandleFunc("DELETE /{id}", handOrd.DelOrderByID)
 	mux.HandleFunc("POST /", handOrd.PostOrder)
 	mux.HandleFunc("PUT /{id}", handOrd.PutOrderByID)
 	mux.HandleFunc("POST /{id}/close", handOrd.PostOrdCloseById)
+	mux.HandleFunc("POST /{id}/preparing", handOrd.PostOrdPreparingById)
+	mux.HandleFunc("POST /{id}/ready", handOrd.PostOrdReadyById)
+	mux.HandleFunc("POST /{id}/picked-up", handOrd.PostOrdPickedUpById)
+	mux.HandleFunc("POST /{id}/cancel", handOrd.PostOrdCancelById)
+	mux.HandleFunc("POST /{id}/refund", handOrd.PostOrdRefundById)
+	mux.HandleFunc("GET /{id}/refunds", handOrd.GetOrderRefunds)
+	mux.HandleFunc("GET /{id}/payments", handPayment.GetPayments)
+	mux.HandleFunc("POST /{id}/payments", handPayment.PostPayment)
+	mux.HandleFunc("POST /{id}/payments/{paymentID}/void", handPayment.PostPaymentVoid)
+	mux.HandleFunc("GET /{id}/receipt", handReceipt.GetReceipt)
+	mux.HandleFunc("GET /{id}/kitchen-ticket", handReceipt.GetKitchenTicket)
 	mux.HandleFunc("POST /batch-process", handOrd.BatchProcess)
+	mux.HandleFunc("GET /batch-process/{jobID}", handOrd.GetBatchJob)
 	mux.HandleFunc("GET /history", handOrd.GetAllStatusHistory)
-	return mux
+	return mux, start
 }
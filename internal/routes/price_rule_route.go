@@ -0,0 +1,26 @@
+package router
+
+import (
+	"net/http"
+
+	"frappuccino/internal/dal"
+	"frappuccino/internal/handler"
+	"frappuccino/internal/service"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func priceRuleRouter(db *sqlx.DB) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dalRuleInter := dal.ReturnDalPriceRule(db)
+	ruleSerInter := service.ReturnPriceRuleSerStruct(dalRuleInter)
+	handRule := handler.ReturnPriceRuleHandStruct(ruleSerInter)
+
+	mux.HandleFunc("GET /", handRule.GetPriceRules)
+	mux.HandleFunc("GET /{id}", handRule.GetPriceRuleByID)
+	mux.HandleFunc("POST /", handRule.PostPriceRule)
+	mux.HandleFunc("PUT /{id}", handRule.PutPriceRuleByID)
+	mux.HandleFunc("DELETE /{id}", handRule.DelPriceRule)
+	return mux
+}
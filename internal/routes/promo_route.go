@@ -0,0 +1,27 @@
+package router
+
+import (
+	"net/http"
+
+	"frappuccino/internal/dal"
+	"frappuccino/internal/handler"
+	"frappuccino/internal/service"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func promoRouter(db *sqlx.DB) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dalPromoInter := dal.ReturnDalPromo(db)
+	promoSerInter := service.ReturnPromoSerStruct(dalPromoInter)
+	handPromo := handler.ReturnPromoHandStruct(promoSerInter)
+
+	mux.HandleFunc("GET /", handPromo.GetPromos)
+	mux.HandleFunc("GET /{id}", handPromo.GetPromoByID)
+	mux.HandleFunc("GET /{id}/redemptions", handPromo.GetPromoRedemptions)
+	mux.HandleFunc("POST /", handPromo.PostPromo)
+	mux.HandleFunc("PUT /{id}", handPromo.PutPromoByID)
+	mux.HandleFunc("DELETE /{id}", handPromo.DelPromo)
+	return mux
+}
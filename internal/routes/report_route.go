@@ -23,5 +23,8 @@ func aggregationReportRouter(db *sqlx.DB) *http.ServeMux {
 	mux.HandleFunc("GET /orderedItemsByPeriod", handAggre.PeriodOrderedItems)
 	mux.HandleFunc("GET /getLeftOvers", handAggre.GetLeftOvers)
 	mux.HandleFunc("GET /numberOfOrderedItems", handAggre.NumberOfOrderedItems)
+	mux.HandleFunc("GET /low-margin", handAggre.LowMargin)
+	mux.HandleFunc("GET /tenders", handAggre.Tenders)
+	mux.HandleFunc("GET /customer-value", handAggre.CustomerValue)
 	return mux
 }
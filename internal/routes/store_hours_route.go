@@ -0,0 +1,25 @@
+package router
+
+import (
+	"net/http"
+
+	"frappuccino/internal/dal"
+	"frappuccino/internal/handler"
+	"frappuccino/internal/service"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func storeHoursRouter(db *sqlx.DB) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dalStoreHoursInter := dal.ReturnDalStoreHours(db)
+	storeHoursSerInter := service.ReturnStoreHoursSerStruct(dalStoreHoursInter)
+	handStoreHours := handler.ReturnStoreHoursHandStruct(storeHoursSerInter)
+
+	mux.HandleFunc("GET /", handStoreHours.GetStoreHours)
+	mux.HandleFunc("PUT /", handStoreHours.PutPickupSettings)
+	mux.HandleFunc("PUT /{weekday}", handStoreHours.PutStoreHoursByWeekday)
+	mux.HandleFunc("DELETE /{weekday}", handStoreHours.DelStoreHours)
+	return mux
+}
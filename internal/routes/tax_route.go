@@ -0,0 +1,26 @@
+package router
+
+import (
+	"net/http"
+
+	"frappuccino/internal/dal"
+	"frappuccino/internal/handler"
+	"frappuccino/internal/service"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func taxRouter(db *sqlx.DB) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dalTaxInter := dal.ReturnDalTax(db)
+	taxSerInter := service.ReturnTaxSerStruct(dalTaxInter)
+	handTax := handler.ReturnTaxHandStruct(taxSerInter)
+
+	mux.HandleFunc("GET /", handTax.GetTaxes)
+	mux.HandleFunc("PUT /", handTax.PutTaxMode)
+	mux.HandleFunc("POST /rates", handTax.PostTaxRate)
+	mux.HandleFunc("PUT /rates/{id}", handTax.PutTaxRateByID)
+	mux.HandleFunc("DELETE /rates/{id}", handTax.DelTaxRate)
+	return mux
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+)
+
+type categoryServiceToDal struct {
+	categoryDal dal.CategoryDalInter
+}
+
+type CategoryServiceInter interface {
+	CollectCategories() ([]models.Category, error)
+	TakeCategory(uint64) (*models.Category, error)
+	CreateCategory(*models.Category) error
+	UpgradeCategory(*models.Category) error
+	DelCategory(uint64) error
+}
+
+func ReturnCategorySerStruct(categoryDal dal.CategoryDalInter) CategoryServiceInter {
+	return &categoryServiceToDal{categoryDal: categoryDal}
+}
+
+func (ser *categoryServiceToDal) CollectCategories() ([]models.Category, error) {
+	return ser.categoryDal.SelectAllCategories()
+}
+
+func (ser *categoryServiceToDal) TakeCategory(id uint64) (*models.Category, error) {
+	category, err := ser.categoryDal.SelectCategory(id)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - category id = %d", err, id)
+	}
+	return category, err
+}
+
+func (ser *categoryServiceToDal) CreateCategory(category *models.Category) error {
+	if err := ser.checkCategory(category); err != nil {
+		return err
+	}
+	err := ser.categoryDal.InsertCategory(category)
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : category %s already exists", err, category.Name)
+	}
+	return err
+}
+
+func (ser *categoryServiceToDal) UpgradeCategory(category *models.Category) error {
+	if err := ser.checkCategory(category); err != nil {
+		return err
+	}
+	err := ser.categoryDal.UpdateCategory(category)
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : category %s already exists", err, category.Name)
+	}
+	return err
+}
+
+func (ser *categoryServiceToDal) DelCategory(id uint64) error {
+	err := ser.categoryDal.DeleteCategory(id)
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : category %d has menu items, move them first", err, id)
+	}
+	return err
+}
+
+func (ser *categoryServiceToDal) checkCategory(category *models.Category) error {
+	category.Name = strings.TrimSpace(category.Name)
+	if len(category.Name) == 0 || isInvalidName(category.Name) {
+		return fmt.Errorf("%w: invalid category name - %s", models.ErrBadInput, category.Name)
+	}
+	category.Description = strings.TrimSpace(category.Description)
+	return nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+
+	"github.com/lib/pq"
+)
+
+type customerServiceToDal struct {
+	customerDal dal.CustomerDalInter
+	orderSer    OrdServiceInter // history of a customer is GET /orders of them
+}
+
+type CustomerServiceInter interface {
+	CollectCustomers() ([]models.Customer, error)
+	TakeCustomer(uint64) (*models.Customer, error)
+	CreateCustomer(*models.Customer) error
+	UpgradeCustomer(*models.Customer) error
+	DelCustomer(uint64) error
+	CollectCustomerOrders(id uint64, query *models.OrderQuery) (*models.OrdersPage, error)
+}
+
+var (
+	phoneRegexp = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{4,31}$`)
+	emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+func ReturnCustomerSerStruct(customerDal dal.CustomerDalInter, orderSer OrdServiceInter) CustomerServiceInter {
+	return &customerServiceToDal{customerDal: customerDal, orderSer: orderSer}
+}
+
+func (ser *customerServiceToDal) CollectCustomers() ([]models.Customer, error) {
+	return ser.customerDal.SelectAllCustomers()
+}
+
+func (ser *customerServiceToDal) TakeCustomer(id uint64) (*models.Customer, error) {
+	customer, err := ser.customerDal.SelectCustomer(id)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - customer id = %d", err, id)
+	}
+	return customer, err
+}
+
+func (ser *customerServiceToDal) CreateCustomer(customer *models.Customer) error {
+	if err := ser.checkCustomer(customer); err != nil {
+		return err
+	}
+	return ser.conflict(ser.customerDal.InsertCustomer(customer))
+}
+
+func (ser *customerServiceToDal) UpgradeCustomer(customer *models.Customer) error {
+	if err := ser.checkCustomer(customer); err != nil {
+		return err
+	}
+	err := ser.conflict(ser.customerDal.UpdateCustomer(customer))
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - customer id = %d", err, customer.ID)
+	}
+	return err
+}
+
+func (ser *customerServiceToDal) DelCustomer(id uint64) error {
+	err := ser.customerDal.DeleteCustomer(id)
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : customer %d has orders or loyalty points", err, id)
+	} else if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - customer id = %d", err, id)
+	}
+	return err
+}
+
+// CollectCustomerOrders: orders of the customer with the filters and pages of GET /orders
+func (ser *customerServiceToDal) CollectCustomerOrders(id uint64, query *models.OrderQuery) (*models.OrdersPage, error) {
+	if _, err := ser.TakeCustomer(id); err != nil {
+		return nil, err
+	}
+	query.CustomerID = strconv.FormatUint(id, 10)
+	return ser.orderSer.CollectOrders(query)
+}
+
+func (ser *customerServiceToDal) conflict(err error) error {
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : phone or email is of another customer", err)
+	}
+	return err
+}
+
+// checkCustomer: valid name, phone and email if they are given, allergens without empty and repeated ones
+func (ser *customerServiceToDal) checkCustomer(customer *models.Customer) error {
+	customer.Name = strings.TrimSpace(customer.Name)
+	if isInvalidName(customer.Name) {
+		return fmt.Errorf("%w: invalid customer name - %s", models.ErrBadInput, customer.Name)
+	}
+	customer.Phone = strings.TrimSpace(customer.Phone)
+	if len(customer.Phone) != 0 && !phoneRegexp.MatchString(customer.Phone) {
+		return fmt.Errorf("%w: invalid phone - %s", models.ErrBadInput, customer.Phone)
+	}
+	customer.Email = strings.ToLower(strings.TrimSpace(customer.Email))
+	if len(customer.Email) != 0 && (len(customer.Email) > 128 || !emailRegexp.MatchString(customer.Email)) {
+		return fmt.Errorf("%w: invalid email - %s", models.ErrBadInput, customer.Email)
+	}
+	allergens := pq.StringArray{}
+	for _, allergen := range customer.Allergens {
+		allergen = strings.TrimSpace(allergen)
+		if len(allergen) > 64 {
+			return fmt.Errorf("%w: too long allergen - %s", models.ErrBadInput, allergen)
+		}
+		if len(allergen) != 0 && !slices.Contains(allergens, allergen) {
+			allergens = append(allergens, allergen)
+		}
+	}
+	customer.Allergens = allergens
+	customer.Notes = strings.TrimSpace(customer.Notes)
+	return nil
+}
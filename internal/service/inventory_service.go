@@ -20,6 +20,8 @@ type InventoryService interface {
 	RemoveInventory(uint64) (*models.InventoryDepend, error)
 	CollectInventoryHistory() ([]models.InventoryTransaction, error)
 	CollectReorder() ([]models.Inventory, error)
+	UpgradePrepRecipe(id uint64, recipe *[]models.PrepIngredient) error
+	ProducePrep(*models.Production) error
 }
 
 func ReturnInventorySerInt(dalInter dal.InventoryDataAccess) InventoryService {
@@ -65,7 +67,7 @@ func (ser *inventoryServiceDal) RemoveInventory(id uint64) (*models.InventoryDep
 		}
 		return nil, err
 	}
-	if menuDepend != nil && len(menuDepend.Menus) != 0 {
+	if menuDepend != nil && (len(menuDepend.Menus) != 0 || len(menuDepend.Preps) != 0) {
 		menuDepend.Err = "Found Depends"
 		return menuDepend, nil
 	}
@@ -94,3 +96,38 @@ func (ser *inventoryServiceDal) CollectInventoryHistory() ([]models.InventoryTra
 func (ser *inventoryServiceDal) CollectReorder() ([]models.Inventory, error) {
 	return ser.invDal.SelectReorder()
 }
+
+// UpgradePrepRecipe sets what 1 unit of the prep item is made of, empty recipe makes it raw again
+func (ser *inventoryServiceDal) UpgradePrepRecipe(id uint64, recipe *[]models.PrepIngredient) error {
+	uniq := map[uint64]struct{}{}
+	for i, ing := range *recipe {
+		(*recipe)[i].Status = ""
+		if ing.InventoryID == id {
+			return fmt.Errorf("%w : prep item can not be made of itself", models.ErrBadInput)
+		}
+		if ing.Quantity <= 0 {
+			return fmt.Errorf("%w : invalid quantity of %d - %f", models.ErrBadInput, ing.InventoryID, ing.Quantity)
+		}
+		if _, x := uniq[ing.InventoryID]; x {
+			return fmt.Errorf("%w : duplicated ingredient %d", models.ErrBadInput, ing.InventoryID)
+		}
+		uniq[ing.InventoryID] = struct{}{}
+	}
+	err := ser.invDal.UpdatePrepRecipe(id, recipe)
+	if errors.Is(err, models.ErrNotFound) && !errors.Is(err, models.ErrNotFoundItems) {
+		err = fmt.Errorf("%w - id = %d", err, id)
+	}
+	return err
+}
+
+func (ser *inventoryServiceDal) ProducePrep(prod *models.Production) error {
+	if prod.Quantity <= 0 {
+		return fmt.Errorf("%w : invalid quantity - %f", models.ErrBadInput, prod.Quantity)
+	}
+	prod.Used, prod.NotEnough = nil, nil
+	err := ser.invDal.ProducePrep(prod)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - id = %d", err, prod.PrepID)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+
+	"github.com/lib/pq"
+)
+
+type loyaltyServiceToDal struct {
+	loyaltyDal dal.LoyaltyDalInter
+}
+
+type LoyaltyServiceInter interface {
+	TakeLoyaltySettings() (*models.LoyaltySettings, error)
+	SwitchPointValue(*models.LoyaltySettings) error
+	CreateLoyaltyRule(*models.LoyaltyRule) error
+	UpgradeLoyaltyRule(*models.LoyaltyRule) error
+	DelLoyaltyRule(uint64) error
+	TakeLoyaltyAccount(customerID uint64) (*models.LoyaltyAccount, error)
+	AdjustPoints(*models.LoyaltyEntry) error
+}
+
+func ReturnLoyaltySerStruct(loyaltyDal dal.LoyaltyDalInter) LoyaltyServiceInter {
+	return &loyaltyServiceToDal{loyaltyDal: loyaltyDal}
+}
+
+func (ser *loyaltyServiceToDal) TakeLoyaltySettings() (*models.LoyaltySettings, error) {
+	return ser.loyaltyDal.SelectLoyaltySettings()
+}
+
+func (ser *loyaltyServiceToDal) SwitchPointValue(settings *models.LoyaltySettings) error {
+	if settings.PointValue == nil || *settings.PointValue <= 0 {
+		return fmt.Errorf("%w: point_value must be more than 0", models.ErrBadInput)
+	}
+	settings.Rules = nil
+	return ser.loyaltyDal.UpdatePointValue(*settings.PointValue)
+}
+
+func (ser *loyaltyServiceToDal) CreateLoyaltyRule(rule *models.LoyaltyRule) error {
+	if err := ser.checkLoyaltyRule(rule); err != nil {
+		return err
+	}
+	return ser.conflict(ser.loyaltyDal.InsertLoyaltyRule(rule), rule)
+}
+
+func (ser *loyaltyServiceToDal) UpgradeLoyaltyRule(rule *models.LoyaltyRule) error {
+	if err := ser.checkLoyaltyRule(rule); err != nil {
+		return err
+	}
+	return ser.conflict(ser.loyaltyDal.UpdateLoyaltyRule(rule), rule)
+}
+
+func (ser *loyaltyServiceToDal) DelLoyaltyRule(id uint64) error {
+	return ser.loyaltyDal.DeleteLoyaltyRule(id)
+}
+
+func (ser *loyaltyServiceToDal) TakeLoyaltyAccount(customerID uint64) (*models.LoyaltyAccount, error) {
+	account, err := ser.loyaltyDal.SelectLoyaltyAccount(customerID)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - customer id = %d", err, customerID)
+	}
+	return account, err
+}
+
+// AdjustPoints: a manual entry of the staff, points are added (or taken off if negative)
+func (ser *loyaltyServiceToDal) AdjustPoints(entry *models.LoyaltyEntry) error {
+	if entry.Points == 0 {
+		return fmt.Errorf("%w: points must not be 0", models.ErrBadInput)
+	}
+	entry.Note = strings.TrimSpace(entry.Note)
+	if len(entry.Note) == 0 {
+		return fmt.Errorf("%w: note is required for an adjustment", models.ErrBadInput)
+	}
+	entry.Kind, entry.OrderID = "adjust", nil
+	err := ser.loyaltyDal.InsertLoyaltyEntry(entry)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - customer id = %d", err, entry.CustomerID)
+	}
+	return err
+}
+
+func (ser *loyaltyServiceToDal) conflict(err error, rule *models.LoyaltyRule) error {
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : loyalty rule %s already exists", err, rule.Name)
+	}
+	return err
+}
+
+// checkLoyaltyRule: an amount rule needs per_amount, an item rule needs tags
+func (ser *loyaltyServiceToDal) checkLoyaltyRule(rule *models.LoyaltyRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if len(rule.Name) == 0 || isInvalidName(rule.Name) {
+		return fmt.Errorf("%w: invalid loyalty rule name - %s", models.ErrBadInput, rule.Name)
+	}
+	if rule.Points == 0 {
+		return fmt.Errorf("%w: points must be more than 0", models.ErrBadInput)
+	}
+	if rule.Active == nil {
+		rule.Active = new(bool)
+		*rule.Active = true
+	}
+	tags := pq.StringArray{}
+	for _, tag := range rule.Tags {
+		if tag = strings.TrimSpace(tag); len(tag) == 0 {
+			return fmt.Errorf("%w: empty tag", models.ErrBadInput)
+		}
+		tags = append(tags, tag)
+	}
+	rule.Tags = tags
+
+	switch rule.Kind {
+	case "amount":
+		if rule.PerAmount == nil || *rule.PerAmount <= 0 {
+			return fmt.Errorf("%w: per_amount must be more than 0 for an amount rule", models.ErrBadInput)
+		}
+		rule.Tags = pq.StringArray{}
+	case "item":
+		if len(rule.Tags) == 0 {
+			return fmt.Errorf("%w: tags are required for an item rule", models.ErrBadInput)
+		}
+		rule.PerAmount = nil
+	default:
+		return fmt.Errorf("%w: kind must be amount or item - %s", models.ErrBadInput, rule.Kind)
+	}
+	return nil
+}
@@ -1,11 +1,17 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
+	"time"
 
 	"frappuccino/internal/dal"
 	"frappuccino/models"
+
+	"github.com/lib/pq"
 )
 
 type menuServiceToDal struct {
@@ -14,11 +20,19 @@ type menuServiceToDal struct {
 
 type MenuServiceInter interface {
 	CollectMenus() ([]models.MenuItem, error)
+	CollectSections() ([]models.MenuSection, error)
 	TakeMenu(uint64) (*models.MenuItem, error)
 	DelServiceMenuById(uint64) (*models.MenuDepend, error)
 	CreateMenu(*models.MenuItem) error
 	UpgradeMenu(*models.MenuItem) error
 	CollectHistory() ([]models.PriceHistory, error)
+	MenuCost(uint64) (*models.MenuCost, error)
+	SwitchMenu(id uint64, available bool) error
+	UpgradeSchedules(id uint64, schedules []models.MenuSchedule) error
+	SchedulePrice(*models.ScheduledPrice) error
+	CollectScheduledPrices(productID uint64) ([]models.ScheduledPrice, error)
+	CancelScheduledPrice(productID, id uint64) error
+	StartPriceScheduler(ctx context.Context)
 }
 
 func ReturnMenuSerStruct(interMenuDal dal.MenuDalInter) MenuServiceInter {
@@ -29,6 +43,38 @@ func (ser *menuServiceToDal) CollectMenus() ([]models.MenuItem, error) {
 	return ser.menuDal.SelectAllMenus()
 }
 
+// CollectSections groups the menu by categories in their order, items without one go last "Other"
+func (ser *menuServiceToDal) CollectSections() ([]models.MenuSection, error) {
+	categories, menus, err := ser.menuDal.SelectMenuSections()
+	if err != nil {
+		return nil, err
+	}
+
+	sections := make([]models.MenuSection, len(categories), len(categories)+1)
+	index := make(map[uint64]int, len(categories))
+	for i, category := range categories {
+		sections[i] = models.MenuSection{Category: category, Items: []models.MenuItem{}}
+		index[category.ID] = i
+	}
+	var other []models.MenuItem
+	for _, menu := range menus {
+		if menu.CategoryID == nil {
+			other = append(other, menu)
+			continue
+		}
+		i, ok := index[*menu.CategoryID]
+		if !ok { // the category is not in the list, the item is still shown
+			other = append(other, menu)
+			continue
+		}
+		sections[i].Items = append(sections[i].Items, menu)
+	}
+	if len(other) != 0 {
+		sections = append(sections, models.MenuSection{Category: models.Category{Name: "Other"}, Items: other})
+	}
+	return sections, nil
+}
+
 func (ser *menuServiceToDal) TakeMenu(id uint64) (*models.MenuItem, error) {
 	return ser.menuDal.SelectMenu(id)
 }
@@ -58,12 +104,85 @@ func (ser *menuServiceToDal) CollectHistory() ([]models.PriceHistory, error) {
 	return ser.menuDal.SelectPriceHistory()
 }
 
+func (ser *menuServiceToDal) MenuCost(id uint64) (*models.MenuCost, error) {
+	return ser.menuDal.SelectMenuCost(id)
+}
+
+// SwitchMenu is the manual sold out (86): false takes the item off, true puts it back
+func (ser *menuServiceToDal) SwitchMenu(id uint64, available bool) error {
+	return ser.menuDal.UpdateMenuAvailable(id, available)
+}
+
+// UpgradeSchedules replaces the windows when the item is sold, empty list sells it always
+func (ser *menuServiceToDal) UpgradeSchedules(id uint64, schedules []models.MenuSchedule) error {
+	for i := range schedules {
+		schedules[i].ID, schedules[i].ProductID = 0, 0
+		if err := checkTimeWindow(&schedules[i].TimeWindow); err != nil {
+			return fmt.Errorf("%w (schedule %d)", err, i+1)
+		}
+	}
+	return ser.menuDal.UpdateMenuSchedules(id, schedules)
+}
+
+// SchedulePrice plans a new price of the item (or of its variant) from effective_at
+func (ser *menuServiceToDal) SchedulePrice(change *models.ScheduledPrice) error {
+	change.ID, change.AppliedAt, change.CreatedAt = 0, nil, time.Time{}
+	if change.NewPrice < 0 {
+		return fmt.Errorf("%w: negative price", models.ErrBadInput)
+	}
+	if change.EffectiveAt.IsZero() {
+		return fmt.Errorf("%w: no effective_at", models.ErrBadInput)
+	}
+	if change.EffectiveAt.Before(time.Now()) {
+		return fmt.Errorf("%w: effective_at is in the past, use PUT /menu/{id}", models.ErrBadInput)
+	}
+	return ser.menuDal.InsertScheduledPrice(change)
+}
+
+func (ser *menuServiceToDal) CollectScheduledPrices(productID uint64) ([]models.ScheduledPrice, error) {
+	return ser.menuDal.SelectScheduledPrices(productID)
+}
+
+func (ser *menuServiceToDal) CancelScheduledPrice(productID, id uint64) error {
+	err := ser.menuDal.DeleteScheduledPrice(productID, id)
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : price change %d is already applied", err, id)
+	} else if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w : price change %d of menu item %d", err, id, productID)
+	}
+	return err
+}
+
+// StartPriceScheduler applies due scheduled prices every PRICE_SCHEDULER_SECONDS (default 60),
+// so the menu shows them in time, until ctx is done. Orders only read them with price_at
+func (ser *menuServiceToDal) StartPriceScheduler(ctx context.Context) {
+	every := time.Duration(envUint("PRICE_SCHEDULER_SECONDS", 60)) * time.Second
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			if n, err := ser.menuDal.ApplyScheduledPrices(); err != nil {
+				slog.Error("price scheduler", "error", err)
+			} else if n != 0 {
+				slog.Info("price scheduler", "applied", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
 func (ser *menuServiceToDal) checkMenuStruct(menu *models.MenuItem) error {
 	if isInvalidName(menu.Name) {
 		return fmt.Errorf("%w: invalid name - %s", models.ErrBadInput, menu.Name)
 	}
 
 	menu.Description = strings.TrimSpace(menu.Description)
+	menu.Margin, menu.Availability = nil, nil
+	menu.Schedules = nil // only by PUT /menu/{id}/schedules
 
 	if len(menu.Description) == 0 {
 		return fmt.Errorf("%w: empty description", models.ErrBadInput)
@@ -76,10 +195,20 @@ func (ser *menuServiceToDal) checkMenuStruct(menu *models.MenuItem) error {
 		return fmt.Errorf("%w: negative menu price", models.ErrBadInput)
 	}
 
-	if len(menu.Ingredients) == 0 {
+	if err := ser.checkComponents(menu); err != nil {
+		return err
+	}
+	if len(menu.Ingredients) == 0 && menu.Kind != "bundle" {
 		return fmt.Errorf("%w: empty ingridents", models.ErrBadInput)
 	}
 
+	if err := ser.checkVariants(menu.Variants); err != nil {
+		return err
+	}
+	if err := ser.checkModifiers(menu.Modifiers); err != nil {
+		return err
+	}
+
 	forTestUniqIngs, invalids := map[uint64]struct{}{}, map[uint64]struct{}{}
 
 	// check for unique and negative quantity ing
@@ -116,3 +245,141 @@ func (ser *menuServiceToDal) checkMenuStruct(menu *models.MenuItem) error {
 	menu.Ingredients = menu.Ingredients[:invalidCount]
 	return models.ErrBadInputItems
 }
+
+// checkComponents: a bundle has components and no recipe of its own (it takes the recipes
+// of the components), an item is the opposite
+func (ser *menuServiceToDal) checkComponents(menu *models.MenuItem) error {
+	switch menu.Kind {
+	case "":
+		menu.Kind = "item"
+		fallthrough
+	case "item":
+		if len(menu.Components) != 0 {
+			return fmt.Errorf("%w: components are only for kind bundle", models.ErrBadInput)
+		}
+		return nil
+	case "bundle":
+	default:
+		return fmt.Errorf("%w: unknown kind %s", models.ErrBadInput, menu.Kind)
+	}
+
+	if len(menu.Ingredients) != 0 {
+		return fmt.Errorf("%w: bundle has no ingredients, only components", models.ErrBadInput)
+	}
+	if len(menu.Components) == 0 {
+		return fmt.Errorf("%w: empty components", models.ErrBadInput)
+	}
+	names := map[string]struct{}{}
+	for i := range menu.Components {
+		comp := &menu.Components[i]
+		comp.Name = strings.TrimSpace(comp.Name)
+		if len(comp.Name) == 0 {
+			return fmt.Errorf("%w: empty component name", models.ErrBadInput)
+		}
+		if _, x := names[comp.Name]; x {
+			return fmt.Errorf("%w: duplicated component %s", models.ErrBadInput, comp.Name)
+		}
+		names[comp.Name] = struct{}{}
+		if comp.Quantity == 0 {
+			comp.Quantity = 1
+		}
+		if len(comp.Choices) == 0 {
+			return fmt.Errorf("%w: component %s has no choices", models.ErrBadInput, comp.Name)
+		}
+		choices := map[int64]struct{}{}
+		for _, product := range comp.Choices {
+			if _, x := choices[product]; x || product <= 0 {
+				return fmt.Errorf("%w: component %s: invalid or duplicated choice %d", models.ErrBadInput, comp.Name, product)
+			}
+			choices[product] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// checkVariants: unique names, not negative price. Scale 0 (not given) means the recipe as is
+func (ser *menuServiceToDal) checkVariants(variants []models.MenuVariant) error {
+	names, ids := map[string]struct{}{}, map[uint64]struct{}{}
+	for i := range variants {
+		variant := &variants[i]
+		variant.Name = strings.TrimSpace(variant.Name)
+		if len(variant.Name) == 0 {
+			return fmt.Errorf("%w: empty variant name", models.ErrBadInput)
+		}
+		if _, x := names[variant.Name]; x {
+			return fmt.Errorf("%w: duplicated variant %s", models.ErrBadInput, variant.Name)
+		}
+		names[variant.Name] = struct{}{}
+		if _, x := ids[variant.ID]; x && variant.ID != 0 {
+			return fmt.Errorf("%w: duplicated variant id %d", models.ErrBadInput, variant.ID)
+		}
+		ids[variant.ID] = struct{}{}
+
+		if variant.Price < 0 {
+			return fmt.Errorf("%w: negative price of variant %s", models.ErrBadInput, variant.Name)
+		}
+		if variant.Scale == 0 {
+			variant.Scale = 1
+		} else if variant.Scale < 0 {
+			return fmt.Errorf("%w: negative scale of variant %s", models.ErrBadInput, variant.Name)
+		}
+	}
+	return nil
+}
+
+// checkModifiers: names are unique in the menu item and in the group,
+// the group can be chosen (max_select >= 1) and option ingredients are deltas, not zero
+func (ser *menuServiceToDal) checkModifiers(groups []models.ModifierGroup) error {
+	groupNames := map[string]struct{}{}
+	for i := range groups {
+		group := &groups[i]
+		group.Name = strings.TrimSpace(group.Name)
+		if len(group.Name) == 0 {
+			return fmt.Errorf("%w: empty modifier group name", models.ErrBadInput)
+		}
+		if _, x := groupNames[group.Name]; x {
+			return fmt.Errorf("%w: duplicated modifier group %s", models.ErrBadInput, group.Name)
+		}
+		groupNames[group.Name] = struct{}{}
+
+		if len(group.Options) == 0 {
+			return fmt.Errorf("%w: modifier group %s has no options", models.ErrBadInput, group.Name)
+		}
+		if group.MaxSelect == 0 || group.MaxSelect < group.MinSelect || group.MinSelect > uint64(len(group.Options)) {
+			return fmt.Errorf("%w: modifier group %s: wrong min_select or max_select", models.ErrBadInput, group.Name)
+		}
+
+		optionNames := map[string]struct{}{}
+		for j := range group.Options {
+			opt := &group.Options[j]
+			opt.Name = strings.TrimSpace(opt.Name)
+			if len(opt.Name) == 0 {
+				return fmt.Errorf("%w: empty option name in modifier group %s", models.ErrBadInput, group.Name)
+			}
+			if _, x := optionNames[opt.Name]; x {
+				return fmt.Errorf("%w: duplicated option %s", models.ErrBadInput, opt.Name)
+			}
+			optionNames[opt.Name] = struct{}{}
+
+			// NOT NULL in db
+			if opt.AddAllergens == nil {
+				opt.AddAllergens = pq.StringArray{}
+			}
+			if opt.RemoveAllergens == nil {
+				opt.RemoveAllergens = pq.StringArray{}
+			}
+
+			uniqIngs := map[uint64]struct{}{}
+			for _, ing := range opt.Ingredients {
+				if ing.Quantity == 0 {
+					return fmt.Errorf("%w: option %s: zero quantity of ingredient %d", models.ErrBadInput, opt.Name, ing.InventoryID)
+				}
+				if _, x := uniqIngs[ing.InventoryID]; x {
+					return fmt.Errorf("%w: option %s: duplicated ingredient %d", models.ErrBadInput, opt.Name, ing.InventoryID)
+				}
+				uniqIngs[ing.InventoryID] = struct{}{}
+			}
+		}
+	}
+	return nil
+}
@@ -1,8 +1,16 @@
 package service
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"frappuccino/internal/dal"
@@ -11,25 +19,176 @@ import (
 
 type ordServiceToDal struct {
 	ordDalInt dal.OrderDalInter
+	jobWake   chan struct{} // wakes up batch workers when a job is queued
+	worker    string        // name of this instance in the leases of batch jobs
+	jobLease  time.Duration
 }
 
 type OrdServiceInter interface {
-	CollectOrders() ([]models.Order, error)
+	CollectOrders(*models.OrderQuery) (*models.OrdersPage, error)
 	TakeOrder(uint64) (*models.Order, error)
 	RemoveOrder(uint64) error
 	CreateOrder(*models.Order) error
 	UpgradeOrder(id uint64, ord *models.Order) error
 	ShutOrder(uint64) error
+	MoveOrder(id uint64, status string) error
+	CancelOrder(id uint64, reason string) error
+	RefundOrder(*models.Refund) error
+	CollectRefunds(orderID uint64) ([]models.Refund, error)
+	CollectPickups(date string) (*models.PickupQueue, error)
 	CreateSomeOrders(batch *models.OutputBatches) error
+	CreateAllOrders(batch *models.OutputBatches) error
+	QueueBatch(orders []models.Order, atomic bool) (*models.BatchJob, error)
+	TakeBatchJob(uint64) (*models.BatchJob, error)
+	StartBatchWorkers(ctx context.Context) error
 	CollectStatusHistory() ([]models.StatusHistory, error)
+	BeginIdempotent(*models.Idempotency) (*models.Idempotency, error)
+	FinishIdempotent(*models.Idempotency) error
 }
 
+// orderMoves is the order state machine: the statuses an order may move to from each status.
+// 'processing' -> 'accepted' is done only by ShutOrder, '-> refunded' by the last RefundOrder.
+var orderMoves = map[string][]string{
+	"processing": {"accepted", "cancelled"},
+	"accepted":   {"preparing", "refunded"},
+	"preparing":  {"ready", "refunded"},
+	"ready":      {"picked_up", "refunded"},
+	"picked_up":  {"refunded"},
+}
+
+var orderStatuses = []string{"processing", "accepted", "preparing", "ready", "picked_up", "cancelled", "refunded"}
+
 func ReturnOrdSerStruct(ord dal.OrderDalInter) OrdServiceInter {
 	return &ordServiceToDal{ordDalInt: ord}
 }
 
-func (ser *ordServiceToDal) CollectOrders() ([]models.Order, error) {
-	return ser.ordDalInt.SelectAllOrders()
+func (ser *ordServiceToDal) CollectOrders(query *models.OrderQuery) (*models.OrdersPage, error) {
+	filter, err := ser.parseOrderQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	page := models.OrdersPage{PageSize: filter.Limit}
+	// +1 to know is there the next page
+	filter.Limit++
+	page.Orders, err = ser.ordDalInt.SelectAllOrders(filter)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(page.Orders)) < filter.Limit {
+		return &page, nil
+	}
+	page.Orders = page.Orders[:page.PageSize]
+	page.HasNextPage = true
+
+	last := page.Orders[len(page.Orders)-1]
+	cursor := models.OrderCursor{SortBy: filter.SortBy, Desc: filter.Desc, ID: last.ID}
+	switch filter.SortBy {
+	case "created_at":
+		cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case "total":
+		cursor.Value = strconv.FormatFloat(*last.Total, 'f', -1, 64)
+	}
+	cursorJson, err := json.Marshal(cursor)
+	if err != nil {
+		return nil, err
+	}
+	page.NextCursor = base64.RawURLEncoding.EncodeToString(cursorJson)
+	return &page, nil
+}
+
+func (ser *ordServiceToDal) parseOrderQuery(query *models.OrderQuery) (*models.OrderFilter, error) {
+	filter := models.OrderFilter{SortBy: "id", Limit: 50}
+	var err error
+
+	for _, status := range strings.FieldsFunc(query.Status, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !slices.Contains(orderStatuses, status) {
+			return nil, fmt.Errorf("%w : unknown status %s", models.ErrBadInput, status)
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	filter.Customer = strings.TrimSpace(query.Customer)
+	if len(query.CustomerID) != 0 {
+		customer, err := strconv.ParseUint(query.CustomerID, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%w : invalid customerId %s", models.ErrBadInput, query.CustomerID)
+		}
+		filter.CustomerID = &customer
+	}
+
+	for _, date := range []struct {
+		from string
+		to   **time.Time
+	}{{query.StartDate, &filter.Start}, {query.EndDate, &filter.End}} {
+		if len(date.from) == 0 {
+			continue
+		}
+		t, err := time.Parse("02.01.2006", date.from)
+		if err != nil {
+			return nil, fmt.Errorf("%w : invalid date %s", models.ErrBadInput, date.from)
+		}
+		*date.to = &t
+	}
+
+	for _, total := range []struct {
+		from string
+		to   **float64
+	}{{query.MinTotal, &filter.MinTotal}, {query.MaxTotal, &filter.MaxTotal}} {
+		if len(total.from) == 0 {
+			continue
+		}
+		f, err := strconv.ParseFloat(total.from, 64)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("%w : invalid total %s", models.ErrBadInput, total.from)
+		}
+		*total.to = &f
+	}
+
+	if len(query.Product) != 0 {
+		product, err := strconv.ParseUint(query.Product, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%w : invalid product %s", models.ErrBadInput, query.Product)
+		}
+		filter.ProductID = &product
+	}
+
+	switch strings.ToLower(query.SortBy) {
+	case "", "id":
+	case "created_at", "total":
+		filter.SortBy = strings.ToLower(query.SortBy)
+	default:
+		return nil, fmt.Errorf("%w : unknown sortBy %s", models.ErrBadInput, query.SortBy)
+	}
+
+	switch strings.ToLower(query.Order) {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return nil, fmt.Errorf("%w : unknown order %s", models.ErrBadInput, query.Order)
+	}
+
+	if len(query.PageSize) != 0 {
+		filter.Limit, err = strconv.ParseUint(query.PageSize, 10, 0)
+		if err != nil || filter.Limit == 0 || filter.Limit > 500 {
+			return nil, fmt.Errorf("%w : pageSize must be 1..500", models.ErrBadInput)
+		}
+	}
+
+	if len(query.Cursor) != 0 {
+		cursorJson, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("%w : invalid cursor", models.ErrBadInput)
+		}
+		filter.After = new(models.OrderCursor)
+		if err = json.Unmarshal(cursorJson, filter.After); err != nil {
+			return nil, fmt.Errorf("%w : invalid cursor", models.ErrBadInput)
+		}
+		// курсор другой сортировки даст мусор
+		if filter.After.SortBy != filter.SortBy || filter.After.Desc != filter.Desc {
+			return nil, fmt.Errorf("%w : cursor is for another sort", models.ErrBadInput)
+		}
+	}
+	return &filter, nil
 }
 
 func (ser *ordServiceToDal) TakeOrder(id uint64) (*models.Order, error) {
@@ -59,39 +218,150 @@ func (ser *ordServiceToDal) ShutOrder(id uint64) error {
 	return ser.ordDalInt.CloseOrder(id)
 }
 
+func (ser *ordServiceToDal) MoveOrder(id uint64, status string) error {
+	from := ser.movesFrom(status)
+	// they have their own rules: close, cancel and refund
+	if len(from) == 0 || status == "accepted" || status == "cancelled" || status == "refunded" {
+		return fmt.Errorf("%w : unknown status %s", models.ErrBadInput, status)
+	}
+	return ser.ordDalInt.UpdateStatus(id, from, status)
+}
+
+func (ser *ordServiceToDal) CancelOrder(id uint64, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if len(reason) == 0 {
+		return fmt.Errorf("%w : empty reason", models.ErrBadInput)
+	}
+	return ser.ordDalInt.CancelOrder(id, ser.movesFrom("cancelled"), reason)
+}
+
+// RefundOrder refunds some units of the lines (all without items) of an accepted or later order
+func (ser *ordServiceToDal) RefundOrder(ref *models.Refund) error {
+	ref.Reason = strings.TrimSpace(ref.Reason)
+	if len(ref.Reason) == 0 {
+		return fmt.Errorf("%w : empty reason", models.ErrBadInput)
+	}
+	ref.Method = strings.ToLower(strings.TrimSpace(ref.Method))
+	if len(ref.Method) != 0 && !slices.Contains(paymentMethods, ref.Method) {
+		return fmt.Errorf("%w : method must be one of %s", models.ErrBadInput, strings.Join(paymentMethods, ", "))
+	}
+	items := map[uint64]struct{}{}
+	for i, item := range ref.Items {
+		if item.Quantity == 0 {
+			return fmt.Errorf("%w : zero quantity of item %d", models.ErrBadInput, item.ItemID)
+		}
+		if _, x := items[item.ItemID]; x {
+			return fmt.Errorf("%w : duplicated item %d", models.ErrBadInput, item.ItemID)
+		}
+		items[item.ItemID] = struct{}{}
+		ref.Items[i].Amount, ref.Items[i].Tax = 0, 0
+	}
+	ref.ID, ref.Status = 0, ""
+	return ser.ordDalInt.RefundOrder(ref, ser.movesFrom("refunded"))
+}
+
+func (ser *ordServiceToDal) CollectRefunds(orderID uint64) ([]models.Refund, error) {
+	return ser.ordDalInt.SelectRefunds(orderID)
+}
+
+// CollectPickups: the pre-orders of the date (dd.mm.yyyy) of the store, without it all from the current slot.
+// Times are shown in the store timezone
+func (ser *ordServiceToDal) CollectPickups(date string) (*models.PickupQueue, error) {
+	timezone, err := ser.ordDalInt.SelectStoreTimezone()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := storeLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	var from, to *time.Time
+	if date = strings.TrimSpace(date); len(date) != 0 {
+		day, err := time.ParseInLocation("02.01.2006", date, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%w : invalid date %s", models.ErrBadInput, date)
+		}
+		next := day.AddDate(0, 0, 1)
+		from, to = &day, &next
+	}
+	queue, err := ser.ordDalInt.SelectPickups(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	queue.Timezone = timezone
+	for i := range queue.Slots {
+		slot := &queue.Slots[i]
+		slot.Start, slot.End = slot.Start.In(loc), slot.End.In(loc)
+		for j := range slot.Orders {
+			slot.Orders[j].PickupAt = slot.Orders[j].PickupAt.In(loc)
+		}
+	}
+	return queue, nil
+}
+
+// movesFrom returns every status from which an order may move to status
+func (ser *ordServiceToDal) movesFrom(status string) []string {
+	var from []string
+	for old, nexts := range orderMoves {
+		if slices.Contains(nexts, status) {
+			from = append(from, old)
+		}
+	}
+	return from
+}
+
 func (ser *ordServiceToDal) CreateSomeOrders(bulk *models.OutputBatches) error {
-	var unknownErr error
+	return ser.createSomeOrders(bulk, nil)
+}
 
-	var wasBadInput, wasNotEnough bool
+// createSomeOrders processes orders from job.Processed, earlier ones are already in bulk.
+// job is nil if it is not async. Each accepted order is saved with the progress of the job
+// in one transaction, a rejected one only moves the progress
+func (ser *ordServiceToDal) createSomeOrders(bulk *models.OutputBatches, job *models.BatchJob) error {
+	var unknownErr error
+	var from uint64
+	if job != nil {
+		from = job.Processed
+	}
 
-	for i := range bulk.Processed {
+	for i := from; i < uint64(len(bulk.Processed)); i++ {
 		bulk.Summary.TotalOrders++
 		bulk.Processed[i].CreatedAt = time.Time{}
 		bulk.Processed[i].UpdatedAt = time.Time{}
+		bulk.Processed[i].Status, bulk.Processed[i].Reason = "", ""
 		err := ser.checkOrderStruct(&bulk.Processed[i])
 
 		if err != nil {
 			bulk.Processed[i].Reason = "bad input"
-			wasBadInput = true
-		} else if err = ser.ordDalInt.InsertOrder(&bulk.Processed[i], &bulk.Summary.InventoryUpdates); err == nil { // err==nil
-			bulk.Summary.TotalRevenue += *bulk.Processed[i].Total
-			bulk.Summary.Accepted++
-			bulk.Processed[i].Status = "accepted"
-			bulk.Processed[i].Items = nil
-			continue
+		} else if err = ser.insertBatchOrder(bulk, i, job); err == nil { // err==nil
+			acceptBatchOrder(bulk, i)
+		} else if errors.Is(err, models.ErrBatchLease) {
+			return err
 		} else if errors.Is(err, models.ErrOrderNotEnoughItems) {
 			bulk.Processed[i].Reason = "insufficient_inventory"
-			wasNotEnough = true
 		} else if errors.Is(err, models.ErrNotFoundItems) {
 			bulk.Processed[i].Reason = "ErrNotFoundItems"
+		} else if errors.Is(err, models.ErrUnavailableItems) {
+			bulk.Processed[i].Reason = "unavailable"
+		} else if errors.Is(err, models.ErrBadInput) { // wrong modifiers or promo code
+			bulk.Processed[i].Reason = "bad input"
+		} else if errors.Is(err, models.ErrConflict) { // lost a race to other orders, it can be sent again
+			bulk.Processed[i].Reason = "conflict"
 		} else { // critical error
 			unknownErr = err
 			bulk.Processed[i].Reason = fmt.Sprintf("unknown error: %s", err.Error())
 		}
-		bulk.Summary.Rejected++
-		bulk.Processed[i].Status = "rejected"
-
-		// bulk.Processed[i].Items = nil
+		if bulk.Processed[i].Status != "accepted" {
+			bulk.Summary.Rejected++
+			bulk.Processed[i].Status = "rejected"
+			if job != nil { // nothing was inserted, only the progress
+				if err = ser.saveJobProgress(job, bulk, i+1); err != nil {
+					return err
+				}
+			}
+		}
 	}
 	if unknownErr != nil { // 500
 		return unknownErr
@@ -105,43 +375,388 @@ func (ser *ordServiceToDal) CreateSomeOrders(bulk *models.OutputBatches) error {
 		return models.ErrOrdersMultiStatus
 	}
 	// значить все были Rejected
+	var wasBadInput, wasNotEnough, wasUnavailable bool
+	for _, ord := range bulk.Processed {
+		wasBadInput = wasBadInput || ord.Reason == "bad input"
+		wasNotEnough = wasNotEnough || ord.Reason == "insufficient_inventory"
+		wasUnavailable = wasUnavailable || ord.Reason == "unavailable"
+	}
 	if wasBadInput { // 400
 		return models.ErrBadInput
 	}
 	if wasNotEnough {
 		return models.ErrOrderNotEnoughItems
 	}
+	if wasUnavailable {
+		return models.ErrUnavailableItems
+	}
 	return models.ErrNotFoundItems
 }
 
+func (ser *ordServiceToDal) CreateAllOrders(bulk *models.OutputBatches) error {
+	return ser.createAllOrders(bulk, nil)
+}
+
+// createAllOrders is the atomic batch: every order is created or none of them.
+// If the batch is rejected, reasons show which orders would have failed.
+// The whole job is done with the commit of its orders
+func (ser *ordServiceToDal) createAllOrders(bulk *models.OutputBatches, job *models.BatchJob) error {
+	bulk.Summary.Atomic = true
+	var valid []*models.Order
+	var wasBadInput, wasAllergen, wasNotFound, wasUnavailable, wasNotEnough bool
+
+	for i := range bulk.Processed {
+		bulk.Summary.TotalOrders++
+		bulk.Processed[i].CreatedAt = time.Time{}
+		bulk.Processed[i].UpdatedAt = time.Time{}
+		bulk.Processed[i].Reason = ""
+		if err := ser.checkOrderStruct(&bulk.Processed[i]); err != nil {
+			bulk.Processed[i].Reason = "bad input"
+			wasBadInput = true
+			continue
+		}
+		valid = append(valid, &bulk.Processed[i])
+	}
+
+	// if there was bad input, the rest are only checked
+	var progress dal.JobProgress
+	if job != nil {
+		progress = func() (*models.BatchJob, error) {
+			done := cloneBatch(bulk)
+			for i := range done.Processed {
+				acceptBatchOrder(done, uint64(i))
+			}
+			return jobProgress(job, done, job.Total)
+		}
+	}
+	errs, err := ser.ordDalInt.InsertAllOrders(valid, &bulk.Summary.InventoryUpdates, wasBadInput, progress)
+	if err != nil {
+		return err
+	}
+	for i, err := range errs {
+		if errors.Is(err, models.ErrAllergen) {
+			valid[i].Reason = "found_allergen"
+			wasAllergen = true
+		} else if errors.Is(err, models.ErrNotFoundItems) {
+			valid[i].Reason = "ErrNotFoundItems"
+			wasNotFound = true
+		} else if errors.Is(err, models.ErrUnavailableItems) {
+			valid[i].Reason = "unavailable"
+			wasUnavailable = true
+		} else if errors.Is(err, models.ErrOrderNotEnoughItems) {
+			valid[i].Reason = "insufficient_inventory"
+			wasNotEnough = true
+		} else if errors.Is(err, models.ErrBadInput) {
+			valid[i].Reason = "bad input"
+			wasBadInput = true
+		}
+	}
+
+	failed := wasBadInput || wasAllergen || wasNotFound || wasUnavailable || wasNotEnough
+	if !failed { // 200
+		for i := range bulk.Processed {
+			acceptBatchOrder(bulk, uint64(i))
+		}
+		return nil
+	}
+	for i := range bulk.Processed {
+		ord := &bulk.Processed[i]
+		if len(ord.Reason) == 0 {
+			ord.Reason = "batch_rolled_back" // it was fine, but others were not
+			ord.Items = nil
+		} else {
+			bulk.Summary.Failed++
+		}
+		ord.ID, ord.Total = 0, nil
+		ord.Status = "rejected"
+		bulk.Summary.Rejected++
+	}
+	bulk.Summary.InventoryUpdates = nil
+
+	if wasBadInput {
+		return models.ErrBadInput
+	} else if wasAllergen {
+		return models.ErrAllergen
+	} else if wasNotFound {
+		return models.ErrNotFoundItems
+	} else if wasUnavailable {
+		return models.ErrUnavailableItems
+	}
+	return models.ErrOrderNotEnoughItems
+}
+
+// QueueBatch saves the batch as a job, batch workers will process it
+func (ser *ordServiceToDal) QueueBatch(orders []models.Order, atomic bool) (*models.BatchJob, error) {
+	request, err := json.Marshal(orders)
+	if err != nil {
+		return nil, err
+	}
+	job := models.BatchJob{Atomic: atomic, Total: uint64(len(orders)), Request: request}
+	if err = ser.ordDalInt.InsertBatchJob(&job); err != nil {
+		return nil, err
+	}
+	select {
+	case ser.jobWake <- struct{}{}:
+	default: // all workers are busy, someone will take it after
+	}
+	return &job, nil
+}
+
+func (ser *ordServiceToDal) TakeBatchJob(id uint64) (*models.BatchJob, error) {
+	return ser.ordDalInt.SelectBatchJob(id)
+}
+
+// StartBatchWorkers starts BATCH_WORKERS (default 4) goroutines for async batch jobs.
+// A worker leases its job for BATCH_LEASE_SECONDS (default 60) and renews it while the job runs,
+// so jobs of a stopped instance are continued by any instance when their lease ends.
+// When ctx is done the workers take no more jobs, a running job is left to its lease
+func (ser *ordServiceToDal) StartBatchWorkers(ctx context.Context) error {
+	workers := envUint("BATCH_WORKERS", 4)
+	ser.jobLease = time.Duration(envUint("BATCH_LEASE_SECONDS", 60)) * time.Second
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	ser.worker = fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	ser.jobWake = make(chan struct{}, workers)
+	for range workers {
+		go ser.batchWorker(ctx)
+	}
+	return nil
+}
+
+func (ser *ordServiceToDal) batchWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := ser.ordDalInt.ClaimBatchJob(ser.worker, ser.jobLease)
+		if err != nil {
+			slog.Error("batch worker: cannot claim job", "error", err)
+		}
+		if job == nil {
+			// new jobs wake us up, the timer is for jobs of other instances, ended leases and db errors
+			select {
+			case <-ctx.Done():
+			case <-ser.jobWake:
+			case <-time.After(30 * time.Second):
+			}
+			continue
+		}
+		ser.runBatchJob(job)
+	}
+}
+
+// heartbeat renews the lease of the job until stop is closed or the lease is lost
+func (ser *ordServiceToDal) heartbeat(job models.BatchJob, stop <-chan struct{}) {
+	ticker := time.NewTicker(ser.jobLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := ser.ordDalInt.HeartbeatBatchJob(&job); err != nil {
+				slog.Error("batch job: heartbeat", "job", job.ID, "error", err)
+				if errors.Is(err, models.ErrBatchLease) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (ser *ordServiceToDal) runBatchJob(job *models.BatchJob) {
+	slog.Info("batch job started", "job", job.ID, "from", job.Processed)
+	stop := make(chan struct{})
+	defer close(stop)
+	go ser.heartbeat(*job, stop)
+
+	var bulk models.OutputBatches
+	var err error
+	if len(job.Result) != 0 { // continue after restart
+		err = json.Unmarshal(job.Result, &bulk)
+	} else {
+		err = json.Unmarshal(job.Request, &bulk.Processed)
+	}
+
+	// an atomic job with a result was committed with it, only its status was not saved
+	if err == nil && job.Atomic && len(job.Result) == 0 {
+		err = ser.createAllOrders(&bulk, job)
+	} else if err == nil && !job.Atomic {
+		err = ser.createSomeOrders(&bulk, job)
+	}
+	if errors.Is(err, models.ErrBatchLease) {
+		slog.Warn("batch job: lease lost, another worker continues it", "job", job.ID)
+		return
+	}
+
+	job.Status = "done"
+	if err != nil {
+		job.Error = err.Error()
+		// rejected orders are a normal result of the batch, anything else is a failure
+		if !errors.Is(err, models.ErrOrdersMultiStatus) && !errors.Is(err, models.ErrBadInput) &&
+			!errors.Is(err, models.ErrAllergen) && !errors.Is(err, models.ErrNotFoundItems) &&
+			!errors.Is(err, models.ErrOrderNotEnoughItems) && !errors.Is(err, models.ErrUnavailableItems) {
+			job.Status = "failed"
+		}
+	}
+	if job.Status == "done" {
+		job.Processed = job.Total
+	}
+	if result, err := json.Marshal(bulk); err == nil {
+		job.Result = result
+	}
+	if err = ser.ordDalInt.UpdateBatchJob(job); err != nil {
+		slog.Error("batch job: cannot save result", "job", job.ID, "error", err)
+		return
+	}
+	slog.Info("batch job finished", "job", job.ID, "status", job.Status)
+}
+
+// insertBatchOrder inserts bulk.Processed[i], with the job progress as if it is accepted
+func (ser *ordServiceToDal) insertBatchOrder(bulk *models.OutputBatches, i uint64, job *models.BatchJob) error {
+	if job == nil {
+		return ser.ordDalInt.InsertOrder(&bulk.Processed[i], &bulk.Summary.InventoryUpdates)
+	}
+	return ser.ordDalInt.InsertJobOrder(&bulk.Processed[i], &bulk.Summary.InventoryUpdates, func() (*models.BatchJob, error) {
+		done := cloneBatch(bulk)
+		acceptBatchOrder(done, i)
+		return jobProgress(job, done, i+1)
+	})
+}
+
+func (ser *ordServiceToDal) saveJobProgress(job *models.BatchJob, bulk *models.OutputBatches, done uint64) error {
+	next, err := jobProgress(job, bulk, done)
+	if err != nil {
+		return err
+	}
+	*job = *next
+	return ser.ordDalInt.UpdateBatchJob(job)
+}
+
+// jobProgress is the job after "done" orders with bulk as its result
+func jobProgress(job *models.BatchJob, bulk *models.OutputBatches, done uint64) (*models.BatchJob, error) {
+	result, err := json.Marshal(bulk)
+	if err != nil {
+		return nil, err
+	}
+	next := *job
+	next.Processed, next.Result = done, result
+	return &next, nil
+}
+
+// cloneBatch copies the orders, so the progress can be made before the order is really committed
+func cloneBatch(bulk *models.OutputBatches) *models.OutputBatches {
+	clone := *bulk
+	clone.Processed = slices.Clone(bulk.Processed)
+	return &clone
+}
+
+func acceptBatchOrder(bulk *models.OutputBatches, i uint64) {
+	ord := &bulk.Processed[i]
+	bulk.Summary.TotalRevenue += *ord.Total
+	bulk.Summary.Accepted++
+	ord.Status = "accepted"
+	ord.Items = nil
+}
+
 func (ser *ordServiceToDal) CollectStatusHistory() ([]models.StatusHistory, error) {
 	return ser.ordDalInt.SelectAllStatusHistory()
 }
 
+// BeginIdempotent returns the saved response to replay, or nil if the request must be processed
+func (ser *ordServiceToDal) BeginIdempotent(idem *models.Idempotency) (*models.Idempotency, error) {
+	if len(idem.Key) > 255 {
+		return nil, fmt.Errorf("%w : too long Idempotency-Key", models.ErrBadInput)
+	}
+	busyFor := time.Duration(envUint("IDEMPOTENCY_BUSY_SECONDS", 120)) * time.Second
+	saved, err := ser.ordDalInt.InsertIdempotency(idem, busyFor)
+	if err != nil || saved == nil {
+		return nil, err
+	}
+	if saved.RequestHash != idem.RequestHash {
+		return nil, models.ErrIdempotencyMismatch
+	}
+	if saved.StatusCode == 0 {
+		return nil, models.ErrIdempotencyBusy
+	}
+	return saved, nil
+}
+
+// FinishIdempotent saves the response. Server errors are not saved, so the client can retry
+func (ser *ordServiceToDal) FinishIdempotent(idem *models.Idempotency) error {
+	if idem.StatusCode >= 500 {
+		return ser.ordDalInt.DeleteIdempotency(idem.Key, idem.Endpoint)
+	}
+	return ser.ordDalInt.UpdateIdempotency(idem)
+}
+
 func (ser *ordServiceToDal) checkOrderStruct(ord *models.Order) error {
-	if isInvalidName(ord.CustomerName) {
-		return fmt.Errorf("%w : invalid name", models.ErrBadInput)
+	// the name of a customer can come from the profile
+	if ord.CustomerID == nil || len(ord.CustomerName) != 0 {
+		if isInvalidName(ord.CustomerName) {
+			return fmt.Errorf("%w : invalid name", models.ErrBadInput)
+		}
 	}
 	if len(ord.Items) == 0 {
 		return fmt.Errorf("%w : empty items", models.ErrBadInput)
 	}
-	forTestUniqItems := map[uint64]int{}
-	var hasZeroQuantity bool
+	ord.PromoCode = strings.ToUpper(strings.TrimSpace(ord.PromoCode))
+	if len(ord.PromoCode) > 32 {
+		return fmt.Errorf("%w : too long promo_code", models.ErrBadInput)
+	}
+	ord.Subtotal, ord.Discount, ord.Tax, ord.Total = nil, nil, nil, nil
+	if ord.PickupAt != nil { // slots are in minutes
+		*ord.PickupAt = ord.PickupAt.Truncate(time.Minute)
+	}
+	// the same product with other variant, modifiers or bundle choices is another line:
+	// "latte" and "large latte + oat milk"
+	forTestUniqItems := map[string]int{}
+	var hasZeroQuantity, hasBadChoice bool
 	for i, item := range ord.Items {
 		ord.Items[i].Warning = ""
 		ord.Items[i].Allergens = nil
 		ord.Items[i].NotEnoungIngs = nil
+		ord.Items[i].ID, ord.Items[i].Name, ord.Items[i].VariantName = 0, "", ""
+		ord.Items[i].UnitPrice, ord.Items[i].LineTotal = nil, nil
+		ord.Items[i].Discount, ord.Items[i].TaxRate, ord.Items[i].Tax = nil, nil, nil
+
+		options := make([]uint64, len(item.Modifiers))
+		for j, mod := range item.Modifiers {
+			options[j] = mod.OptionID
+			ord.Items[i].Modifiers[j].Name, ord.Items[i].Modifiers[j].PriceDelta = "", nil
+		}
+		slices.Sort(options)
+		choices := make([]string, len(item.Components))
+		components := map[uint64]struct{}{}
+		for j, comp := range item.Components {
+			choices[j] = fmt.Sprint(comp.ComponentID, ":", comp.ProductID)
+			components[comp.ComponentID] = struct{}{}
+			ord.Items[i].Components[j].Name, ord.Items[i].Components[j].Quantity = "", 0
+			ord.Items[i].Components[j].Share = nil
+		}
+		slices.Sort(choices)
+		var variant uint64
+		if item.VariantID != nil {
+			variant = *item.VariantID
+		}
+		key := fmt.Sprint(item.ProductID, variant, options, choices)
+
 		if item.Quantity == 0 {
 			ord.Items[i].Warning = "zero quantity"
 			hasZeroQuantity = true
-		} else if ind, x := forTestUniqItems[item.ProductID]; x {
+		} else if len(slices.Compact(options)) != len(item.Modifiers) {
+			ord.Items[i].Warning = "duplicated modifier"
+			hasBadChoice = true
+		} else if len(components) != len(item.Components) {
+			ord.Items[i].Warning = "duplicated bundle component"
+			hasBadChoice = true
+		} else if ind, x := forTestUniqItems[key]; x {
 			ord.Items[ind].Warning = "duplicated"
 			ord.Items[i].Warning = "duplicated"
 		}
-		forTestUniqItems[item.ProductID] = i
+		forTestUniqItems[key] = i
 	}
 
-	if len(forTestUniqItems) == len(ord.Items) && !hasZeroQuantity {
+	if len(forTestUniqItems) == len(ord.Items) && !hasZeroQuantity && !hasBadChoice {
 		return nil
 	}
 
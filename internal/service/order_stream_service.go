@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+)
+
+// orderStreamService is the hub of GET /orders/stream: one LISTEN for all the clients
+type orderStreamService struct {
+	eventDal dal.OrderEventDalInter
+	orderDal dal.OrderDalInter
+	mu       sync.Mutex
+	clients  map[chan models.OrderEvent]struct{}
+}
+
+type OrderStreamServiceInter interface {
+	StartStream(ctx context.Context) error
+	Subscribe(lastEventID string) (*models.OrderSubscription, error)
+}
+
+// events of a client not read yet, a slower client is dropped and resumes with Last-Event-ID
+const clientBuffer = 64
+
+// a reconnect gets not more missed events than this, the rest is in GET /orders
+const resumeLimit = 1000
+
+func ReturnOrderStreamSerStruct(eventDal dal.OrderEventDalInter, orderDal dal.OrderDalInter) OrderStreamServiceInter {
+	return &orderStreamService{
+		eventDal: eventDal,
+		orderDal: orderDal,
+		clients:  map[chan models.OrderEvent]struct{}{},
+	}
+}
+
+// StartStream listens to the events of orders, sends them to the clients
+// and removes events older than ORDER_EVENTS_KEEP_HOURS (default 24), until ctx is done
+func (ser *orderStreamService) StartStream(ctx context.Context) error {
+	events := make(chan models.OrderEvent, clientBuffer)
+	if err := ser.eventDal.Listen(ctx, events); err != nil {
+		return err
+	}
+	go func() {
+		for ev := range events {
+			ser.withOrder(&ev)
+			ser.broadcast(ev)
+		}
+	}()
+
+	keep := time.Duration(envUint("ORDER_EVENTS_KEEP_HOURS", 24)) * time.Hour
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			if n, err := ser.eventDal.DeleteEventsBefore(time.Now().Add(-keep)); err != nil {
+				slog.Error("delete old order events", "error", err)
+			} else if n != 0 {
+				slog.Info("old order events deleted", "count", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Subscribe: a new client gets the events after lastEventID (if it is given) and then the live ones
+func (ser *orderStreamService) Subscribe(lastEventID string) (*models.OrderSubscription, error) {
+	var after uint64
+	if lastEventID = strings.TrimSpace(lastEventID); len(lastEventID) != 0 {
+		var err error
+		if after, err = strconv.ParseUint(lastEventID, 10, 0); err != nil {
+			return nil, fmt.Errorf("%w: Last-Event-ID - %s", models.ErrBadInput, lastEventID)
+		}
+	}
+
+	// first subscribed, then read: an event between them is in both and is skipped by id
+	events := make(chan models.OrderEvent, clientBuffer)
+	ser.mu.Lock()
+	ser.clients[events] = struct{}{}
+	ser.mu.Unlock()
+	sub := &models.OrderSubscription{
+		Events: events,
+		Cancel: func() { ser.unsubscribe(events) },
+	}
+	if len(lastEventID) == 0 {
+		return sub, nil
+	}
+
+	missed, err := ser.eventDal.SelectEventsAfter(after, resumeLimit)
+	if err != nil {
+		sub.Cancel()
+		return nil, err
+	}
+	for i := range missed {
+		ser.withOrder(&missed[i])
+	}
+	sub.Missed = missed
+	return sub, nil
+}
+
+func (ser *orderStreamService) unsubscribe(events chan models.OrderEvent) {
+	ser.mu.Lock()
+	defer ser.mu.Unlock()
+	if _, ok := ser.clients[events]; ok {
+		delete(ser.clients, events)
+		close(events)
+	}
+}
+
+// broadcast does not wait for anybody: a full client is closed
+func (ser *orderStreamService) broadcast(ev models.OrderEvent) {
+	ser.mu.Lock()
+	defer ser.mu.Unlock()
+	for events := range ser.clients {
+		select {
+		case events <- ev:
+		default:
+			slog.Warn("order stream client is too slow, dropped", "event", ev.ID)
+			delete(ser.clients, events)
+			close(events)
+		}
+	}
+}
+
+// withOrder adds the order as it is now, a deleted one has only the event
+func (ser *orderStreamService) withOrder(ev *models.OrderEvent) {
+	if ev.Kind == "deleted" {
+		return
+	}
+	order, err := ser.orderDal.SelectOrder(ev.OrderID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("order of the event", "event", ev.ID, "order", ev.OrderID, "error", err)
+		}
+		return
+	}
+	ev.Order = order
+}
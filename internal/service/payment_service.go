@@ -0,0 +1,74 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+)
+
+type paymentServiceToDal struct {
+	paymentDal dal.PaymentDalInter
+}
+
+type PaymentServiceInter interface {
+	CollectPayments(orderID uint64) (*models.OrderPayments, error)
+	CreatePayment(*models.Payment) error
+	CancelPayment(orderID, paymentID uint64) error
+}
+
+var paymentMethods = []string{"cash", "card", "voucher"}
+
+func ReturnPaymentSerStruct(paymentDal dal.PaymentDalInter) PaymentServiceInter {
+	return &paymentServiceToDal{paymentDal: paymentDal}
+}
+
+func (ser *paymentServiceToDal) CollectPayments(orderID uint64) (*models.OrderPayments, error) {
+	payments, err := ser.paymentDal.SelectPayments(orderID)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - order id = %d", err, orderID)
+	}
+	return payments, err
+}
+
+func (ser *paymentServiceToDal) CreatePayment(pay *models.Payment) error {
+	if err := ser.checkPayment(pay); err != nil {
+		return err
+	}
+	err := ser.paymentDal.InsertPayment(pay)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - order id = %d", err, pay.OrderID)
+	}
+	return err
+}
+
+func (ser *paymentServiceToDal) CancelPayment(orderID, paymentID uint64) error {
+	return ser.paymentDal.VoidPayment(orderID, paymentID)
+}
+
+// checkPayment: known method, money in cents, tendered only for cash
+func (ser *paymentServiceToDal) checkPayment(pay *models.Payment) error {
+	pay.Method = strings.ToLower(strings.TrimSpace(pay.Method))
+	if !slices.Contains(paymentMethods, pay.Method) {
+		return fmt.Errorf("%w: method must be one of %s", models.ErrBadInput, strings.Join(paymentMethods, ", "))
+	}
+	for _, money := range []*float64{&pay.Amount, &pay.Tendered} {
+		if math.IsNaN(*money) || *money < 0 || *money > 1e8 {
+			return fmt.Errorf("%w: invalid amount %v", models.ErrBadInput, *money)
+		}
+		*money = math.Round(*money*100) / 100
+	}
+	if pay.Method != "cash" && pay.Tendered != 0 && pay.Tendered != pay.Amount {
+		return fmt.Errorf("%w: only cash has change", models.ErrBadInput)
+	}
+	pay.Reference = strings.TrimSpace(pay.Reference)
+	if len(pay.Reference) > 64 {
+		return fmt.Errorf("%w: too long reference", models.ErrBadInput)
+	}
+	pay.ID, pay.Change, pay.VoidedAt = 0, 0, nil
+	return nil
+}
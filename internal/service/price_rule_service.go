@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+)
+
+type priceRuleServiceToDal struct {
+	ruleDal dal.PriceRuleDalInter
+}
+
+type PriceRuleServiceInter interface {
+	CollectPriceRules() ([]models.PriceRule, error)
+	TakePriceRule(uint64) (*models.PriceRule, error)
+	CreatePriceRule(*models.PriceRule) error
+	UpgradePriceRule(*models.PriceRule) error
+	DelPriceRule(uint64) error
+}
+
+func ReturnPriceRuleSerStruct(ruleDal dal.PriceRuleDalInter) PriceRuleServiceInter {
+	return &priceRuleServiceToDal{ruleDal: ruleDal}
+}
+
+func (ser *priceRuleServiceToDal) CollectPriceRules() ([]models.PriceRule, error) {
+	return ser.ruleDal.SelectAllPriceRules()
+}
+
+func (ser *priceRuleServiceToDal) TakePriceRule(id uint64) (*models.PriceRule, error) {
+	rule, err := ser.ruleDal.SelectPriceRule(id)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - price rule id = %d", err, id)
+	}
+	return rule, err
+}
+
+func (ser *priceRuleServiceToDal) CreatePriceRule(rule *models.PriceRule) error {
+	if err := ser.checkPriceRule(rule); err != nil {
+		return err
+	}
+	return ser.conflict(ser.ruleDal.InsertPriceRule(rule), rule)
+}
+
+func (ser *priceRuleServiceToDal) UpgradePriceRule(rule *models.PriceRule) error {
+	if err := ser.checkPriceRule(rule); err != nil {
+		return err
+	}
+	return ser.conflict(ser.ruleDal.UpdatePriceRule(rule), rule)
+}
+
+func (ser *priceRuleServiceToDal) DelPriceRule(id uint64) error {
+	return ser.ruleDal.DeletePriceRule(id)
+}
+
+func (ser *priceRuleServiceToDal) conflict(err error, rule *models.PriceRule) error {
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : price rule %s already exists", err, rule.Name)
+	}
+	return err
+}
+
+// checkPriceRule: a product or a category (or neither for the whole menu), percent in (0, 100]
+func (ser *priceRuleServiceToDal) checkPriceRule(rule *models.PriceRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if len(rule.Name) == 0 || isInvalidName(rule.Name) {
+		return fmt.Errorf("%w: invalid price rule name - %s", models.ErrBadInput, rule.Name)
+	}
+	if rule.ProductID != nil && rule.CategoryID != nil {
+		return fmt.Errorf("%w: product_id or category_id, not both", models.ErrBadInput)
+	}
+	if rule.PercentOff <= 0 || rule.PercentOff > 100 {
+		return fmt.Errorf("%w: percent_off must be in (0, 100]", models.ErrBadInput)
+	}
+	if rule.Active == nil {
+		active := true
+		rule.Active = &active
+	}
+	return checkTimeWindow(&rule.TimeWindow)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+
+	"github.com/lib/pq"
+)
+
+type promoServiceToDal struct {
+	promoDal dal.PromoDalInter
+}
+
+type PromoServiceInter interface {
+	CollectPromos() ([]models.PromoCode, error)
+	TakePromo(uint64) (*models.PromoCode, error)
+	CreatePromo(*models.PromoCode) error
+	UpgradePromo(*models.PromoCode) error
+	DelPromo(uint64) error
+	CollectRedemptions(uint64) ([]models.PromoRedemption, error)
+}
+
+func ReturnPromoSerStruct(promoDal dal.PromoDalInter) PromoServiceInter {
+	return &promoServiceToDal{promoDal: promoDal}
+}
+
+func (ser *promoServiceToDal) CollectPromos() ([]models.PromoCode, error) {
+	return ser.promoDal.SelectAllPromos()
+}
+
+func (ser *promoServiceToDal) TakePromo(id uint64) (*models.PromoCode, error) {
+	promo, err := ser.promoDal.SelectPromo(id)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - promo id = %d", err, id)
+	}
+	return promo, err
+}
+
+func (ser *promoServiceToDal) CreatePromo(promo *models.PromoCode) error {
+	if err := ser.checkPromo(promo); err != nil {
+		return err
+	}
+	return ser.conflict(ser.promoDal.InsertPromo(promo), promo)
+}
+
+func (ser *promoServiceToDal) UpgradePromo(promo *models.PromoCode) error {
+	if err := ser.checkPromo(promo); err != nil {
+		return err
+	}
+	if err := ser.conflict(ser.promoDal.UpdatePromo(promo), promo); err != nil {
+		return err
+	}
+	// uses are shown as they are
+	saved, err := ser.promoDal.SelectPromo(promo.ID)
+	if err != nil {
+		return err
+	}
+	*promo = *saved
+	return nil
+}
+
+func (ser *promoServiceToDal) DelPromo(id uint64) error {
+	err := ser.promoDal.DeletePromo(id)
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : promo id = %d is used by orders, turn it off by active", err, id)
+	}
+	return err
+}
+
+func (ser *promoServiceToDal) CollectRedemptions(id uint64) ([]models.PromoRedemption, error) {
+	redemptions, err := ser.promoDal.SelectRedemptions(id)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - promo id = %d", err, id)
+	}
+	return redemptions, err
+}
+
+func (ser *promoServiceToDal) conflict(err error, promo *models.PromoCode) error {
+	if errors.Is(err, models.ErrConflict) {
+		err = fmt.Errorf("%w : promo code %s already exists", err, promo.Code)
+	}
+	return err
+}
+
+// checkPromo: code of letters, digits, '-' and '_' in upper case, percent in (0, 100],
+// validity window and limits make sense. Eligible products and tags are not checked
+// against the menu, an unknown one only matches nothing
+func (ser *promoServiceToDal) checkPromo(promo *models.PromoCode) error {
+	promo.Code = strings.ToUpper(strings.TrimSpace(promo.Code))
+	if !regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`).MatchString(promo.Code) {
+		return fmt.Errorf("%w: invalid promo code - %s, 3..32 letters, digits, '-' or '_'", models.ErrBadInput, promo.Code)
+	}
+	promo.Description = strings.TrimSpace(promo.Description)
+	switch promo.Kind {
+	case "percent":
+		if promo.Value <= 0 || promo.Value > 100 {
+			return fmt.Errorf("%w: percent value must be in (0, 100]", models.ErrBadInput)
+		}
+	case "fixed":
+		if promo.Value <= 0 {
+			return fmt.Errorf("%w: fixed value must be more than 0", models.ErrBadInput)
+		}
+	default:
+		return fmt.Errorf("%w: kind must be percent or fixed", models.ErrBadInput)
+	}
+	if promo.MinSpend < 0 {
+		return fmt.Errorf("%w: negative min_spend", models.ErrBadInput)
+	}
+
+	if promo.ProductIDs == nil {
+		promo.ProductIDs = pq.Int64Array{}
+	}
+	for _, id := range promo.ProductIDs {
+		if id <= 0 {
+			return fmt.Errorf("%w: invalid product id %d", models.ErrBadInput, id)
+		}
+	}
+	tags := pq.StringArray{}
+	for _, tag := range promo.Tags {
+		if tag = strings.TrimSpace(tag); len(tag) == 0 {
+			return fmt.Errorf("%w: empty tag", models.ErrBadInput)
+		}
+		tags = append(tags, tag)
+	}
+	promo.Tags = tags
+
+	if promo.StartsAt != nil && promo.EndsAt != nil && !promo.StartsAt.Before(*promo.EndsAt) {
+		return fmt.Errorf("%w: starts_at must be before ends_at", models.ErrBadInput)
+	}
+	if (promo.MaxUses != nil && *promo.MaxUses == 0) || (promo.MaxUsesPerCustomer != nil && *promo.MaxUsesPerCustomer == 0) {
+		return fmt.Errorf("%w: zero uses, omit the limit or set active false", models.ErrBadInput)
+	}
+	if promo.Active == nil {
+		active := true
+		promo.Active = &active
+	}
+	promo.Uses, promo.DiscountTotal = 0, 0
+	return nil
+}
@@ -0,0 +1,488 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+)
+
+type receiptServiceToDal struct {
+	orderDal   dal.OrderDalInter
+	paymentDal dal.PaymentDalInter
+	store      models.ReceiptStore
+}
+
+type ReceiptServiceInter interface {
+	Receipt(orderID uint64, format string) (*models.Printout, error)
+	KitchenTicket(orderID uint64, format string) (*models.Printout, error)
+}
+
+var printFormats = []string{"text", "html", "escpos"}
+
+func ReturnReceiptSerStruct(orderDal dal.OrderDalInter, paymentDal dal.PaymentDalInter) ReceiptServiceInter {
+	return &receiptServiceToDal{
+		orderDal:   orderDal,
+		paymentDal: paymentDal,
+		store: models.ReceiptStore{
+			Name:     envString("RECEIPT_STORE_NAME", "Frappuccino"),
+			Footer:   strings.ReplaceAll(envString("RECEIPT_FOOTER", "Thank you!"), `\n`, "\n"),
+			Currency: envString("RECEIPT_CURRENCY", "KZT"),
+			Width:    int(min(max(envUint("RECEIPT_WIDTH", 42), 24), 96)),
+		},
+	}
+}
+
+// printStyle is how a line is printed, every format does it in its own way
+type printStyle uint8
+
+const (
+	styleRow    printStyle = iota // left and right text
+	styleTitle                    // centered, double size
+	styleCenter                   // centered
+	styleBold                     // bold row
+	styleRule                     // ----------
+)
+
+// printLine is one line of a receipt or a ticket before rendering
+type printLine struct {
+	left, right string
+	style       printStyle
+}
+
+// Receipt: lines with prices, totals, taxes, payments and refunds of the order
+func (ser *receiptServiceToDal) Receipt(orderID uint64, format string) (*models.Printout, error) {
+	format, err := checkPrintFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	order, err := ser.takeOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := ser.paymentDal.SelectPayments(orderID)
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := ser.orderDal.SelectRefunds(orderID)
+	if err != nil {
+		return nil, err
+	}
+	return ser.render(ser.receiptLines(order, payments, refunds), format, "receipt-"+strconv.FormatUint(orderID, 10))
+}
+
+// KitchenTicket: lines without prices, grouped by menu category, same lines are joined
+func (ser *receiptServiceToDal) KitchenTicket(orderID uint64, format string) (*models.Printout, error) {
+	format, err := checkPrintFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	order, err := ser.takeOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := ser.orderDal.SelectItemCategories(orderID)
+	if err != nil {
+		return nil, err
+	}
+	return ser.render(ser.kitchenLines(order, categories), format, "kitchen-"+strconv.FormatUint(orderID, 10))
+}
+
+func checkPrintFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if len(format) == 0 {
+		return "text", nil
+	}
+	if !slices.Contains(printFormats, format) {
+		return "", fmt.Errorf("%w: format must be one of %s", models.ErrBadInput, strings.Join(printFormats, ", "))
+	}
+	return format, nil
+}
+
+// takeOrder: the order with its times in the store timezone
+func (ser *receiptServiceToDal) takeOrder(id uint64) (*models.Order, error) {
+	order, err := ser.orderDal.SelectOrder(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w - order id = %d", models.ErrNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+	timezone, err := ser.orderDal.SelectStoreTimezone()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := storeLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	order.CreatedAt = order.CreatedAt.In(loc)
+	if order.PickupAt != nil {
+		pickup := order.PickupAt.In(loc)
+		order.PickupAt = &pickup
+	}
+	return order, nil
+}
+
+func (ser *receiptServiceToDal) receiptLines(order *models.Order, payments *models.OrderPayments, refunds []models.Refund) []printLine {
+	lines := ser.orderHeader(order, ser.store.Name)
+	for _, item := range order.Items {
+		lines = append(lines, printLine{left: itemTitle(item.Quantity, item), right: money(item.LineTotal)})
+		if item.Quantity > 1 {
+			lines = append(lines, printLine{left: "    @ " + money(item.UnitPrice)})
+		}
+		lines = append(lines, itemParts(item, true)...)
+		if len(item.PriceRule) != 0 {
+			lines = append(lines, printLine{left: "  " + item.PriceRule})
+		}
+	}
+
+	lines = append(lines, printLine{style: styleRule}, printLine{left: "Subtotal", right: money(order.Subtotal)})
+	// order.Discount is the promo code and the points together, they are printed apart
+	if order.Discount != nil && *order.Discount != 0 {
+		promo := *order.Discount
+		if order.PointsOff != nil {
+			promo -= *order.PointsOff
+		}
+		if promo = math.Round(promo*100) / 100; promo != 0 {
+			lines = append(lines, printLine{left: "Discount " + order.PromoCode, right: "-" + money(&promo)})
+		}
+		if order.PointsOff != nil && *order.PointsOff != 0 {
+			points := fmt.Sprintf("Points (%d)", order.RedeemPoints)
+			lines = append(lines, printLine{left: points, right: "-" + money(order.PointsOff)})
+		}
+	}
+	// tax by rates, in the order of the rates
+	taxes := map[float64]float64{}
+	var rates []float64
+	for _, item := range order.Items {
+		if item.TaxRate == nil || item.Tax == nil || *item.Tax == 0 {
+			continue
+		}
+		if _, ok := taxes[*item.TaxRate]; !ok {
+			rates = append(rates, *item.TaxRate)
+		}
+		taxes[*item.TaxRate] += *item.Tax
+	}
+	slices.Sort(rates)
+	for _, rate := range rates {
+		label := "Tax " + strconv.FormatFloat(rate, 'f', -1, 64) + "%"
+		if order.TaxInclusive {
+			label += " incl."
+		}
+		tax := taxes[rate]
+		lines = append(lines, printLine{left: label, right: money(&tax)})
+	}
+	lines = append(lines, printLine{left: "TOTAL", right: money(order.Total) + " " + ser.store.Currency, style: styleBold})
+
+	var paid bool
+	for _, pay := range payments.Payments {
+		if pay.VoidedAt != nil {
+			continue
+		}
+		if !paid {
+			lines = append(lines, printLine{style: styleRule})
+			paid = true
+		}
+		method := strings.ToUpper(pay.Method[:1]) + pay.Method[1:]
+		if len(pay.Reference) != 0 {
+			method += " " + pay.Reference
+		}
+		lines = append(lines, printLine{left: method, right: money(&pay.Amount)})
+		if pay.Change != 0 {
+			lines = append(lines, printLine{left: "  Tendered", right: money(&pay.Tendered)}, printLine{left: "  Change", right: money(&pay.Change)})
+		}
+	}
+	if payments.Due > 0 && order.Status == "processing" {
+		lines = append(lines, printLine{left: "Due", right: money(&payments.Due), style: styleBold})
+	}
+	for _, ref := range refunds {
+		lines = append(lines,
+			printLine{left: "Refund " + ref.Method + " " + ref.CreatedAt.Format("02.01.2006"), right: "-" + money(&ref.Amount)},
+			printLine{left: "  " + ref.Reason})
+	}
+
+	lines = append(lines, printLine{style: styleRule})
+	for _, footer := range strings.Split(ser.store.Footer, "\n") {
+		if len(strings.TrimSpace(footer)) != 0 {
+			lines = append(lines, printLine{left: footer, style: styleCenter})
+		}
+	}
+	return lines
+}
+
+func (ser *receiptServiceToDal) kitchenLines(order *models.Order, categories []models.ItemCategory) []printLine {
+	lines := ser.orderHeader(order, "KITCHEN")
+	if len(order.Allergens) != 0 {
+		lines = append(lines, printLine{left: "ALLERGY: " + strings.Join(order.Allergens, ", "), style: styleBold}, printLine{style: styleRule})
+	}
+
+	items := make(map[uint64]models.OrderItem, len(order.Items))
+	for _, item := range order.Items {
+		items[item.ID] = item
+	}
+	// same product, variant, modifiers and choices in a category is one line for the kitchen
+	type ticketLine struct {
+		key      string
+		quantity uint64
+		item     models.OrderItem
+	}
+	var group []ticketLine
+	flush := func(category string) {
+		if len(group) == 0 {
+			return
+		}
+		if len(category) == 0 {
+			category = "Other"
+		}
+		lines = append(lines, printLine{left: "== " + category + " ==", style: styleBold})
+		for _, line := range group {
+			lines = append(lines, printLine{left: itemTitle(line.quantity, line.item), style: styleBold})
+			lines = append(lines, itemParts(line.item, false)...)
+			if len(line.item.Allergens) != 0 {
+				lines = append(lines, printLine{left: "  ! " + strings.Join(line.item.Allergens, ", ")})
+			}
+		}
+		group = group[:0]
+	}
+	for i, category := range categories {
+		item, ok := items[category.ItemID]
+		if !ok {
+			continue
+		}
+		key := fmt.Sprint(item.ProductID, "/", item.VariantName)
+		for _, mod := range item.Modifiers {
+			key += fmt.Sprint("/m", mod.OptionID)
+		}
+		for _, comp := range item.Components {
+			key += fmt.Sprint("/c", comp.ComponentID, ":", comp.ProductID)
+		}
+		if j := slices.IndexFunc(group, func(line ticketLine) bool { return line.key == key }); j != -1 {
+			group[j].quantity += item.Quantity
+		} else {
+			group = append(group, ticketLine{key: key, quantity: item.Quantity, item: item})
+		}
+		if i == len(categories)-1 || categories[i+1].Category != category.Category {
+			flush(category.Category)
+		}
+	}
+	return lines
+}
+
+// orderHeader: title, order number, time, customer, pickup time of a pre-order and the status if it is not a sale
+func (ser *receiptServiceToDal) orderHeader(order *models.Order, title string) []printLine {
+	lines := []printLine{
+		{left: title, style: styleTitle},
+		{style: styleRule},
+		{left: "Order #" + strconv.FormatUint(order.ID, 10), right: order.CreatedAt.Format("02.01.2006 15:04")},
+		{left: "Customer", right: order.CustomerName},
+	}
+	if order.PickupAt != nil {
+		lines = append(lines, printLine{left: "PICKUP", right: order.PickupAt.Format("02.01.2006 15:04"), style: styleBold})
+	}
+	if order.Status == "cancelled" || order.Status == "refunded" {
+		lines = append(lines, printLine{left: "*** " + strings.ToUpper(order.Status) + " ***", style: styleCenter})
+	}
+	return append(lines, printLine{style: styleRule})
+}
+
+func itemTitle(quantity uint64, item models.OrderItem) string {
+	title := strconv.FormatUint(quantity, 10) + " x " + item.Name
+	if len(item.VariantName) != 0 {
+		title += " (" + item.VariantName + ")"
+	}
+	return title
+}
+
+// itemParts: modifiers (with their price for the receipt) and bundle choices under the line
+func itemParts(item models.OrderItem, prices bool) []printLine {
+	var lines []printLine
+	for _, mod := range item.Modifiers {
+		line := "  + " + mod.Name
+		if prices && mod.PriceDelta != nil && *mod.PriceDelta != 0 {
+			line += fmt.Sprintf(" (%+.2f)", *mod.PriceDelta)
+		}
+		lines = append(lines, printLine{left: line})
+	}
+	for _, comp := range item.Components {
+		lines = append(lines, printLine{left: "  - " + strconv.FormatUint(comp.Quantity, 10) + " x " + comp.Name})
+	}
+	return lines
+}
+
+func money(x *float64) string {
+	if x == nil {
+		return "0.00"
+	}
+	return strconv.FormatFloat(*x, 'f', 2, 64)
+}
+
+func (ser *receiptServiceToDal) render(lines []printLine, format, name string) (*models.Printout, error) {
+	switch format {
+	case "html":
+		return &models.Printout{ContentType: "text/html; charset=utf-8", Body: ser.renderHTML(lines)}, nil
+	case "escpos":
+		return &models.Printout{ContentType: "application/octet-stream", FileName: name + ".bin", Body: ser.renderEscPos(lines)}, nil
+	default:
+		var b bytes.Buffer
+		for _, line := range lines {
+			b.WriteString(ser.textLine(line, ser.store.Width))
+			b.WriteByte('\n')
+		}
+		return &models.Printout{ContentType: "text/plain; charset=utf-8", Body: b.Bytes()}, nil
+	}
+}
+
+// textLine is the line in monospace text of width characters, long text is wrapped
+func (ser *receiptServiceToDal) textLine(line printLine, width int) string {
+	switch line.style {
+	case styleRule:
+		return strings.Repeat("-", width)
+	case styleTitle, styleCenter:
+		rows := wrap(line.left, width)
+		for i, row := range rows {
+			rows[i] = strings.Repeat(" ", (width-utf8.RuneCountInString(row))/2) + row
+		}
+		return strings.Join(rows, "\n")
+	}
+	indent := len(line.left) - len(strings.TrimLeft(line.left, " ")) // of modifiers, kept on every row
+	rows := wrap(line.left, width-indent)
+	if len(rows) == 0 {
+		rows = []string{""}
+	}
+	for i := range rows {
+		rows[i] = strings.Repeat(" ", indent) + rows[i]
+	}
+	if len(line.right) != 0 {
+		last := utf8.RuneCountInString(rows[len(rows)-1])
+		right := utf8.RuneCountInString(line.right)
+		if last+1+right > width {
+			rows, last = append(rows, ""), 0
+		}
+		rows[len(rows)-1] += strings.Repeat(" ", max(width-last-right, 1)) + line.right
+	}
+	return strings.Join(rows, "\n")
+}
+
+// wrap splits the text by words into rows of width, a longer word is cut
+func wrap(text string, width int) []string {
+	var rows []string
+	var row []rune
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		for len(runes) > width {
+			if len(row) != 0 {
+				rows, row = append(rows, string(row)), nil
+			}
+			rows, runes = append(rows, string(runes[:width])), runes[width:]
+		}
+		if len(row) != 0 && len(row)+1+len(runes) > width {
+			rows, row = append(rows, string(row)), nil
+		}
+		if len(row) != 0 {
+			row = append(row, ' ')
+		}
+		row = append(row, runes...)
+	}
+	if len(row) != 0 {
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+func (ser *receiptServiceToDal) renderHTML(lines []printLine) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: monospace; width: %dch; margin: 1em auto; }
+.row { display: flex; justify-content: space-between; gap: 1ch; white-space: pre-wrap; }
+.center { text-align: center; }
+.bold { font-weight: bold; }
+h1 { text-align: center; font-size: 1.6em; margin: 0; }
+hr { border: none; border-top: 1px dashed; }
+</style>
+</head>
+<body>
+`, html.EscapeString(ser.store.Name), ser.store.Width)
+	for _, line := range lines {
+		left, right := html.EscapeString(line.left), html.EscapeString(line.right)
+		switch line.style {
+		case styleRule:
+			b.WriteString("<hr>\n")
+		case styleTitle:
+			fmt.Fprintf(&b, "<h1>%s</h1>\n", left)
+		case styleCenter:
+			fmt.Fprintf(&b, "<div class=\"center\">%s</div>\n", left)
+		case styleBold:
+			fmt.Fprintf(&b, "<div class=\"row bold\"><span>%s</span><span>%s</span></div>\n", left, right)
+		default:
+			fmt.Fprintf(&b, "<div class=\"row\"><span>%s</span><span>%s</span></div>\n", left, right)
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.Bytes()
+}
+
+// ESC/POS commands of thermal printers
+var (
+	escInit       = []byte{0x1b, '@'}
+	escAlignLeft  = []byte{0x1b, 'a', 0}
+	escAlignMid   = []byte{0x1b, 'a', 1}
+	escBoldOn     = []byte{0x1b, 'E', 1}
+	escBoldOff    = []byte{0x1b, 'E', 0}
+	escDoubleSize = []byte{0x1d, '!', 0x11}
+	escNormalSize = []byte{0x1d, '!', 0}
+	escFeedCut    = []byte{0x1d, 'V', 66, 3} // feed 3 lines and partial cut
+)
+
+// renderEscPos: bytes for a thermal printer in its default code page,
+// so only ASCII is printed, other letters become '?'
+func (ser *receiptServiceToDal) renderEscPos(lines []printLine) []byte {
+	var b bytes.Buffer
+	b.Write(escInit)
+	for _, line := range lines {
+		switch line.style {
+		case styleTitle:
+			b.Write(escAlignMid)
+			b.Write(escDoubleSize)
+			b.WriteString(escPosText(strings.Join(wrap(line.left, ser.store.Width/2), "\n")))
+			b.Write(escNormalSize)
+			b.Write(escAlignLeft)
+		case styleCenter:
+			b.Write(escAlignMid)
+			b.WriteString(escPosText(strings.Join(wrap(line.left, ser.store.Width), "\n")))
+			b.Write(escAlignLeft)
+		case styleBold:
+			b.Write(escBoldOn)
+			b.WriteString(escPosText(ser.textLine(line, ser.store.Width)))
+			b.Write(escBoldOff)
+		default:
+			b.WriteString(escPosText(ser.textLine(line, ser.store.Width)))
+		}
+		b.WriteByte('\n')
+	}
+	b.Write(escFeedCut)
+	return b.Bytes()
+}
+
+// escPosText keeps printable ASCII and new lines, data must not send commands to the printer
+func escPosText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r >= ' ' && r < 0x7f {
+			return r
+		}
+		return '?'
+	}, s)
+}
@@ -17,19 +17,22 @@ type aggregationService struct {
 }
 
 type AggregationServiceInter interface {
-	SumOrder() (float64, error)
+	SumOrder() (*models.TotalSales, error)
 	PopularItems() (*models.PopularItems, error)
 	NumberOfOrderedItemsService(start, end string) (map[string]uint64, error)
 	Search(find, from, minPrice, maxPrice string) (*models.SearchThings, error)
 	OrderedItemsPeriod(period, month, year string) (*models.OrderStats, error)
 	GetLeftOversService(sort, page, pageSize string) (*models.GetLeftOvers, error)
+	LowMarginItems(threshold string) (*models.LowMargins, error)
+	TenderReport(date string) (*models.TenderSummary, error)
+	CustomerValues(sortBy, limit string) ([]models.CustomerValue, error)
 }
 
 func ReturnAggregationService(aggDalInter dal.AggregationDalInter) AggregationServiceInter {
 	return &aggregationService{aggreDalInter: aggDalInter}
 }
 
-func (ser *aggregationService) SumOrder() (float64, error) {
+func (ser *aggregationService) SumOrder() (*models.TotalSales, error) {
 	return ser.aggreDalInter.AmountSales()
 }
 
@@ -139,6 +142,10 @@ func (ser *aggregationService) OrderedItemsPeriod(period, month, year string) (*
 		if err != nil {
 			return nil, err
 		}
+		orderStats.NetRevenue, err = ser.aggreDalInter.PeriodMonthRevenue(mouthInt)
+		if err != nil {
+			return nil, err
+		}
 		orderStats.Month = mouthInt.String()
 	case "month":
 		yearInt := time.Now().Year()
@@ -155,6 +162,10 @@ func (ser *aggregationService) OrderedItemsPeriod(period, month, year string) (*
 		if err != nil {
 			return nil, err
 		}
+		orderStats.NetRevenue, err = ser.aggreDalInter.PeriodYearRevenue(yearInt)
+		if err != nil {
+			return nil, err
+		}
 		orderStats.Year = yearInt
 	default:
 		return nil, errors.New("dsf")
@@ -200,3 +211,54 @@ func (ser *aggregationService) GetLeftOversService(sort, page, pageSize string)
 	}
 	return &overs, nil
 }
+
+// LowMarginItems: threshold is margin percent, LOW_MARGIN_PERCENT (60) if it is not given
+func (ser *aggregationService) LowMarginItems(threshold string) (*models.LowMargins, error) {
+	low := models.LowMargins{Threshold: float64(envUint("LOW_MARGIN_PERCENT", 60))}
+	if len(threshold) != 0 {
+		var err error
+		if low.Threshold, err = strconv.ParseFloat(threshold, 64); err != nil || math.IsNaN(low.Threshold) ||
+			low.Threshold <= 0 || low.Threshold > 100 {
+			return nil, fmt.Errorf("%w : threshold must be a percent in (0, 100] - %s", models.ErrBadInput, threshold)
+		}
+	}
+	err := ser.aggreDalInter.LowMargins(&low)
+	if err != nil {
+		return nil, err
+	}
+	return &low, nil
+}
+
+// TenderReport: end of day payments by method, date is 02.01.2006, today if it is not given
+func (ser *aggregationService) TenderReport(date string) (*models.TenderSummary, error) {
+	day := time.Now()
+	if len(date) != 0 {
+		var err error
+		if day, err = time.Parse("02.01.2006", date); err != nil {
+			return nil, fmt.Errorf("%w : invalid date %s", models.ErrBadInput, date)
+		}
+	}
+	sum := models.TenderSummary{Date: day.Format("02.01.2006")}
+	if err := ser.aggreDalInter.TenderSummary(day.Format(time.DateOnly), &sum); err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+// CustomerValues: customers by lifetime value (default), orders or last_order, the biggest first
+func (ser *aggregationService) CustomerValues(sortBy, limit string) ([]models.CustomerValue, error) {
+	sortBy = strings.ToLower(strings.TrimSpace(sortBy))
+	switch sortBy {
+	case "", "lifetime_value", "orders", "last_order":
+	default:
+		return nil, fmt.Errorf("%w : sortBy must be lifetime_value, orders or last_order - %s", models.ErrBadInput, sortBy)
+	}
+	var n uint64 = 50
+	if len(limit) != 0 {
+		var err error
+		if n, err = strconv.ParseUint(limit, 10, 0); err != nil || n == 0 || n > 500 {
+			return nil, fmt.Errorf("%w : limit must be 1..500", models.ErrBadInput)
+		}
+	}
+	return ser.aggreDalInter.CustomerValues(sortBy, n)
+}
@@ -1,8 +1,94 @@
 package service
 
-import "regexp"
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+	_ "time/tzdata" // the store timezone is found without tzdata in the image
+
+	"frappuccino/models"
+)
 
 func isInvalidName(name string) bool {
 	return !regexp.MustCompile(`^[ \w+]{1,128}$`).MatchString(name) ||
 		regexp.MustCompile("  ").MatchString(name) || name[0] == ' ' || name[len(name)-1] == ' '
 }
+
+// envUint reads a positive number from the environment, def if it is not set or invalid
+func envUint(name string, def uint64) uint64 {
+	if n, err := strconv.ParseUint(os.Getenv(name), 10, 0); err == nil && n != 0 {
+		return n
+	}
+	return def
+}
+
+// envString reads a text from the environment, def if it is not set
+func envString(name, def string) string {
+	if s, ok := os.LookupEnv(name); ok {
+		return s
+	}
+	return def
+}
+
+// checkTimeWindow: weekdays 1..7, both times or none, start date not after end date.
+// Times and dates are written back in the form they are shown
+func checkTimeWindow(s *models.TimeWindow) error {
+	days := map[int64]struct{}{}
+	for _, day := range s.Weekdays {
+		if _, x := days[day]; x || day < 1 || day > 7 {
+			return fmt.Errorf("%w: invalid or duplicated weekday %d", models.ErrBadInput, day)
+		}
+		days[day] = struct{}{}
+	}
+	if len(s.Weekdays) == 0 {
+		s.Weekdays = nil // every day
+	}
+
+	if (s.StartTime == nil) != (s.EndTime == nil) {
+		return fmt.Errorf("%w: start_time and end_time go together", models.ErrBadInput)
+	}
+	if s.StartTime != nil {
+		start, err := time.Parse("15:04", *s.StartTime)
+		if err != nil {
+			return fmt.Errorf("%w: start_time - %s", models.ErrBadInput, *s.StartTime)
+		}
+		end, err := time.Parse("15:04", *s.EndTime)
+		if err != nil {
+			return fmt.Errorf("%w: end_time - %s", models.ErrBadInput, *s.EndTime)
+		}
+		if start.Equal(end) {
+			return fmt.Errorf("%w: empty time window", models.ErrBadInput)
+		}
+		*s.StartTime, *s.EndTime = start.Format("15:04"), end.Format("15:04")
+	}
+
+	var start, end time.Time
+	var err error
+	if s.StartDate != nil {
+		if start, err = time.Parse(time.DateOnly, *s.StartDate); err != nil {
+			return fmt.Errorf("%w: start_date - %s", models.ErrBadInput, *s.StartDate)
+		}
+		*s.StartDate = start.Format(time.DateOnly)
+	}
+	if s.EndDate != nil {
+		if end, err = time.Parse(time.DateOnly, *s.EndDate); err != nil {
+			return fmt.Errorf("%w: end_date - %s", models.ErrBadInput, *s.EndDate)
+		}
+		*s.EndDate = end.Format(time.DateOnly)
+	}
+	if s.StartDate != nil && s.EndDate != nil && start.After(end) {
+		return fmt.Errorf("%w: start_date after end_date", models.ErrBadInput)
+	}
+	return nil
+}
+
+// storeLocation: the store timezone by its IANA name, Postgres has the same one in pickup_settings
+func storeLocation(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil || len(name) == 0 {
+		return nil, fmt.Errorf("%w: timezone - %q", models.ErrBadInput, name)
+	}
+	return loc, nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+)
+
+type storeHoursServiceToDal struct {
+	storeHoursDal dal.StoreHoursDalInter
+}
+
+type StoreHoursServiceInter interface {
+	TakePickupSettings() (*models.PickupSettings, error)
+	SwitchPickupSettings(*models.PickupSettings) error
+	SetStoreHours(*models.StoreHours) error
+	CloseStoreDay(weekday uint64) error
+}
+
+func ReturnStoreHoursSerStruct(storeHoursDal dal.StoreHoursDalInter) StoreHoursServiceInter {
+	return &storeHoursServiceToDal{storeHoursDal: storeHoursDal}
+}
+
+func (ser *storeHoursServiceToDal) TakePickupSettings() (*models.PickupSettings, error) {
+	return ser.storeHoursDal.SelectPickupSettings()
+}
+
+// SwitchPickupSettings: a slot is a part of the day, so slot_minutes divides 24 hours.
+// timezone is optional, it is kept if it is not given
+func (ser *storeHoursServiceToDal) SwitchPickupSettings(settings *models.PickupSettings) error {
+	if settings.SlotMinutes == nil || settings.SlotCapacity == nil || settings.MaxDaysAhead == nil {
+		return fmt.Errorf("%w: slot_minutes, slot_capacity and max_days_ahead are required", models.ErrBadInput)
+	}
+	if *settings.SlotMinutes == 0 || 24*60%*settings.SlotMinutes != 0 {
+		return fmt.Errorf("%w: slot_minutes must divide 24 hours - %d", models.ErrBadInput, *settings.SlotMinutes)
+	}
+	if *settings.SlotCapacity == 0 || *settings.MaxDaysAhead == 0 {
+		return fmt.Errorf("%w: slot_capacity and max_days_ahead must be more than 0", models.ErrBadInput)
+	}
+	if settings.Timezone == nil { // the same store
+		old, err := ser.storeHoursDal.SelectPickupSettings()
+		if err != nil {
+			return err
+		}
+		settings.Timezone = old.Timezone
+	}
+	if _, err := storeLocation(*settings.Timezone); err != nil {
+		return err
+	}
+	settings.Hours = nil
+	return ser.storeHoursDal.UpdatePickupSettings(settings)
+}
+
+// SetStoreHours: opens and closes are "HH:MM" of the same day
+func (ser *storeHoursServiceToDal) SetStoreHours(hours *models.StoreHours) error {
+	if hours.Weekday < 1 || hours.Weekday > 7 {
+		return fmt.Errorf("%w: weekday must be 1 (Monday) .. 7 (Sunday) - %d", models.ErrBadInput, hours.Weekday)
+	}
+	opens, err := time.Parse("15:04", hours.Opens)
+	if err != nil {
+		return fmt.Errorf("%w: opens - %s", models.ErrBadInput, hours.Opens)
+	}
+	closes, err := time.Parse("15:04", hours.Closes)
+	if err != nil {
+		return fmt.Errorf("%w: closes - %s", models.ErrBadInput, hours.Closes)
+	}
+	if !opens.Before(closes) {
+		return fmt.Errorf("%w: opens must be before closes", models.ErrBadInput)
+	}
+	hours.Opens, hours.Closes = opens.Format("15:04"), closes.Format("15:04")
+	return ser.storeHoursDal.UpsertStoreHours(hours)
+}
+
+func (ser *storeHoursServiceToDal) CloseStoreDay(weekday uint64) error {
+	err := ser.storeHoursDal.DeleteStoreHours(weekday)
+	if errors.Is(err, models.ErrNotFound) {
+		err = fmt.Errorf("%w - the store is already closed on weekday %d", err, weekday)
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"frappuccino/internal/dal"
+	"frappuccino/models"
+)
+
+type taxServiceToDal struct {
+	taxDal dal.TaxDalInter
+}
+
+type TaxServiceInter interface {
+	TakeTaxSettings() (*models.TaxSettings, error)
+	SwitchTaxMode(*models.TaxSettings) error
+	CreateTaxRate(*models.TaxRate) error
+	UpgradeTaxRate(*models.TaxRate) error
+	DelTaxRate(uint64) error
+}
+
+func ReturnTaxSerStruct(taxDal dal.TaxDalInter) TaxServiceInter {
+	return &taxServiceToDal{taxDal: taxDal}
+}
+
+func (ser *taxServiceToDal) TakeTaxSettings() (*models.TaxSettings, error) {
+	return ser.taxDal.SelectTaxSettings()
+}
+
+func (ser *taxServiceToDal) SwitchTaxMode(settings *models.TaxSettings) error {
+	if settings.PricesIncludeTax == nil {
+		return fmt.Errorf("%w: prices_include_tax is required", models.ErrBadInput)
+	}
+	settings.Rates = nil
+	return ser.taxDal.UpdateTaxMode(*settings.PricesIncludeTax)
+}
+
+func (ser *taxServiceToDal) CreateTaxRate(rate *models.TaxRate) error {
+	if err := ser.checkTaxRate(rate); err != nil {
+		return err
+	}
+	return ser.conflict(ser.taxDal.InsertTaxRate(rate), rate)
+}
+
+func (ser *taxServiceToDal) UpgradeTaxRate(rate *models.TaxRate) error {
+	if err := ser.checkTaxRate(rate); err != nil {
+		return err
+	}
+	return ser.conflict(ser.taxDal.UpdateTaxRate(rate), rate)
+}
+
+func (ser *taxServiceToDal) DelTaxRate(id uint64) error {
+	return ser.taxDal.DeleteTaxRate(id)
+}
+
+func (ser *taxServiceToDal) conflict(err error, rate *models.TaxRate) error {
+	if errors.Is(err, models.ErrConflict) {
+		if rate.CategoryID == nil {
+			err = fmt.Errorf("%w : tax rate %s or the default rate already exists", err, rate.Name)
+		} else {
+			err = fmt.Errorf("%w : tax rate %s or a rate of category %d already exists", err, rate.Name, *rate.CategoryID)
+		}
+	}
+	return err
+}
+
+// checkTaxRate: rate is a percent in [0, 100)
+func (ser *taxServiceToDal) checkTaxRate(rate *models.TaxRate) error {
+	rate.Name = strings.TrimSpace(rate.Name)
+	if len(rate.Name) == 0 || isInvalidName(rate.Name) {
+		return fmt.Errorf("%w: invalid tax rate name - %s", models.ErrBadInput, rate.Name)
+	}
+	if rate.Rate < 0 || rate.Rate >= 100 {
+		return fmt.Errorf("%w: rate must be in [0, 100)", models.ErrBadInput)
+	}
+	return nil
+}
@@ -7,10 +7,40 @@ CREATE TABLE inventory (
     quantity FLOAT NOT NULL CHECK (quantity >= 0),
     reorder_level FLOAT NOT NULL CHECK (reorder_level > 0),
     unit uints NOT NULL,
-    price DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (price >= 0)
+    price DECIMAL(10, 4) NOT NULL DEFAULT 0 CHECK (price >= 0) -- of 1 unit: 1 g, 1 ml or 1 pcs, not of a pack
 );
 
-CREATE TYPE reason_of_inventory_transaction AS ENUM ('restock', 'usage', 'cancelled', 'annul');
+-- prep items (vanilla syrup, cold brew concentrate) are inventory rows with a recipe.
+-- quantity is per 1 unit of the prep item. No cycles: checked before saving
+CREATE TABLE prep_ingredients (
+    prep_id INT NOT NULL REFERENCES inventory (id) ON DELETE CASCADE,
+    inventory_id INT NOT NULL REFERENCES inventory (id),
+    quantity FLOAT NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (prep_id, inventory_id),
+    CHECK (prep_id <> inventory_id)
+);
+
+-- cost of 1 unit (g, ml, pcs) of an inventory item: price is per 1 unit.
+-- A prep item costs its recipe, down to the bought items (same depth limit as deductions)
+CREATE VIEW inventory_unit_costs AS
+WITH RECURSIVE tree AS (
+    SELECT id AS root_id, id, 1::float AS factor, 0 AS depth
+        FROM inventory
+    UNION ALL
+    SELECT t.root_id, p.inventory_id, t.factor * p.quantity, t.depth + 1
+        FROM tree AS t
+        JOIN prep_ingredients AS p ON p.prep_id = t.id
+        WHERE t.depth < 8
+)
+SELECT t.root_id AS id, SUM(t.factor * i.price::float) AS unit_cost
+    FROM tree AS t
+    JOIN inventory AS i ON i.id = t.id
+    WHERE NOT EXISTS (SELECT 1 FROM prep_ingredients AS p WHERE p.prep_id = t.id)
+    GROUP BY t.root_id;
+
+-- quantity_change is signed: + comes into stock (restock, cancelled, refunded), - goes out
+-- (usage, annul). production: + for the produced prep item, - for what it was made of
+CREATE TYPE reason_of_inventory_transaction AS ENUM ('restock', 'usage', 'cancelled', 'annul', 'production', 'refunded');
 
 CREATE TABLE inventory_transactions (
     id SERIAL PRIMARY KEY,
@@ -43,7 +73,7 @@ VALUES (
         5000.0,
         200.0,
         'g',
-        1500.00
+        0.0040
     ),
     (
         'Milk',
@@ -51,7 +81,7 @@ VALUES (
         100.0,
         10.0,
         'ml',
-        50.00
+        0.0010
     ),
     (
         'Sugar',
@@ -59,7 +89,7 @@ VALUES (
         2000.0,
         500.0,
         'g',
-        100.00
+        0.0010
     ),
     (
         'Flour',
@@ -67,7 +97,7 @@ VALUES (
         10000.0,
         500.0,
         'g',
-        300.00
+        0.0008
     ),
     (
         'Butter',
@@ -75,7 +105,7 @@ VALUES (
         500.0,
         50.0,
         'g',
-        800.00
+        0.0080
     ),
     (
         'Eggs',
@@ -83,7 +113,7 @@ VALUES (
         30.0,
         10.0,
         'pcs',
-        200.00
+        0.2500
     ),
     (
         'Vanilla Extract',
@@ -91,7 +121,7 @@ VALUES (
         100.0,
         20.0,
         'ml',
-        900.00
+        0.1000
     ),
     (
         'Chocolate Chips',
@@ -99,7 +129,7 @@ VALUES (
         2000.0,
         200.0,
         'g',
-        1200.00
+        0.0120
     ),
     (
         'Honey',
@@ -107,7 +137,7 @@ VALUES (
         500.0,
         50.0,
         'ml',
-        700.00
+        0.0150
     ),
     (
         'Cinnamon Powder',
@@ -115,7 +145,7 @@ VALUES (
         300.0,
         30.0,
         'g',
-        400.00
+        0.0200
     ),
     (
         'Cocoa Powder',
@@ -123,7 +153,7 @@ VALUES (
         1000.0,
         100.0,
         'g',
-        1100.00
+        0.0120
     ),
     (
         'Baking Powder',
@@ -131,7 +161,7 @@ VALUES (
         500.0,
         50.0,
         'g',
-        250.00
+        0.0050
     ),
     (
         'Salt',
@@ -139,7 +169,7 @@ VALUES (
         2000.0,
         500.0,
         'g',
-        100.00
+        0.0005
     ),
     (
         'Lemon Juice',
@@ -147,7 +177,7 @@ VALUES (
         500.0,
         50.0,
         'ml',
-        600.00
+        0.0040
     ),
     (
         'Olive Oil',
@@ -155,7 +185,7 @@ VALUES (
         1000.0,
         100.0,
         'ml',
-        1200.00
+        0.0100
     ),
     (
         'Yeast',
@@ -163,7 +193,7 @@ VALUES (
         250.0,
         50.0,
         'g',
-        350.00
+        0.0200
     ),
     (
         'Maple Syrup',
@@ -171,7 +201,7 @@ VALUES (
         500.0,
         50.0,
         'ml',
-        1500.00
+        0.0250
     ),
     (
         'Whipping Cream',
@@ -179,7 +209,7 @@ VALUES (
         1000.0,
         100.0,
         'ml',
-        900.00
+        0.0040
     ),
     (
         'Oats',
@@ -187,7 +217,7 @@ VALUES (
         2000.0,
         500.0,
         'g',
-        500.00
+        0.0020
     ),
     (
         'Almonds',
@@ -195,9 +225,22 @@ VALUES (
         1000.0,
         200.0,
         'g',
-        1400.00
+        0.0150
+    ),
+    (
+        'Vanilla Syrup',
+        'House made syrup, prep item',
+        0.0,
+        100.0,
+        'ml',
+        0.00
     );
 
+INSERT INTO
+    prep_ingredients (prep_id, inventory_id, quantity)
+VALUES (21, 3, 0.5), -- Vanilla Syrup -> Sugar
+    (21, 7, 0.05); -- Vanilla Syrup -> Vanilla Extract
+
 INSERT INTO
     inventory_transactions (
         inventory_id,
@@ -1,13 +1,66 @@
+-- sections of the printed / on-screen menu, smaller position goes first
+CREATE TABLE categories (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    position INT NOT NULL DEFAULT 0
+);
+
+-- bundle is made of other menu items and has no recipe of its own
+CREATE TYPE menu_item_kind AS ENUM ('item', 'bundle');
+
 CREATE TABLE menu_items (
     id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    kind menu_item_kind NOT NULL DEFAULT 'item',
+    category_id INT REFERENCES categories (id), -- NULL: no section yet. A category with items is not deleted
     name VARCHAR(64) NOT NULL UNIQUE,
     description TEXT NOT NULL,
     tags TEXT [] NOT NULL, --DEFAULT '{}'::text [], --::text[] деген '{}' ді массив қалады
     -- tags VARCHAR(128)[],
     allergens VARCHAR(64) [],
-    price DECIMAL(10, 2) NOT NULL CHECK (price >= 0) --inventories TEXT[] NOT NULL CHECK (array_length(allergens, 1) > 0) --cardinality(allergens)>0
+    price DECIMAL(10, 2) NOT NULL CHECK (price >= 0), --inventories TEXT[] NOT NULL CHECK (array_length(allergens, 1) > 0) --cardinality(allergens)>0
+    available BOOLEAN NOT NULL DEFAULT TRUE -- FALSE: taken off by hand ("86"), e.g. the machine is broken
 );
 
+-- when the item is sold (breakfast, season): any of its windows, no windows - always.
+-- NULL is no limit. start_time > end_time goes over midnight. Windows are in store time:
+-- they are checked with store_time() (pickup_settings.timezone), not the database time zone
+CREATE TABLE menu_item_schedules (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
+    weekdays INT [] CHECK (weekdays <@ ARRAY[1, 2, 3, 4, 5, 6, 7]), -- ISO: 1 Monday .. 7 Sunday
+    start_time TIME,
+    end_time TIME,
+    start_date DATE,
+    end_date DATE,
+    CHECK ((start_time IS NULL) = (end_time IS NULL) AND start_time IS DISTINCT FROM end_time),
+    CHECK (start_date <= end_date)
+);
+
+-- at is in the window: NULL is no limit, start_time > end_time goes over midnight
+CREATE FUNCTION in_time_window(at TIMESTAMP, weekdays INT [], start_time TIME, end_time TIME, start_date DATE, end_date DATE)
+RETURNS BOOLEAN AS $$
+    SELECT (weekdays IS NULL OR EXTRACT(ISODOW FROM at)::int = ANY (weekdays))
+        AND (start_date IS NULL OR at::date >= start_date)
+        AND (end_date IS NULL OR at::date <= end_date)
+        AND (
+            start_time IS NULL
+            OR (start_time < end_time AND at::time >= start_time AND at::time < end_time)
+            OR (start_time > end_time AND (at::time >= start_time OR at::time < end_time))
+        );
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE FUNCTION menu_item_on_schedule(item INT, at TIMESTAMP)
+RETURNS BOOLEAN AS $$
+    SELECT NOT EXISTS (SELECT 1 FROM menu_item_schedules WHERE product_id = item)
+        OR EXISTS (
+            SELECT 1
+                FROM menu_item_schedules AS s
+                WHERE s.product_id = item
+                    AND in_time_window(at, s.weekdays, s.start_time, s.end_time, s.start_date, s.end_date)
+        );
+$$ LANGUAGE sql STABLE;
+
 CREATE TABLE menu_item_ingredients (
     product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
     inventory_id INT NOT NULL REFERENCES inventory (id),--ON DELETE NO ACTION --(default)
@@ -16,14 +69,127 @@ CREATE TABLE menu_item_ingredients (
     PRIMARY KEY (product_id, inventory_id)
 );
 
+-- parts of a bundle: "Coffee" x1, "Pastry" x1. The client chooses 1 product of the choices,
+-- a component with 1 choice is taken as is
+CREATE TABLE bundle_components (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    bundle_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    quantity INT NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    UNIQUE (bundle_id, name)
+);
+
+CREATE TABLE bundle_component_choices (
+    component_id INT NOT NULL REFERENCES bundle_components (id) ON DELETE CASCADE,
+    product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
+    PRIMARY KEY (component_id, product_id)
+);
+
+-- sizes: "small", "large". Own price, recipe quantities are multiplied by scale.
+-- without variant the menu item itself is ordered (its price, scale 1)
+CREATE TABLE menu_item_variants (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    price DECIMAL(10, 2) NOT NULL CHECK (price >= 0),
+    scale FLOAT NOT NULL DEFAULT 1 CHECK (scale > 0),
+    UNIQUE (product_id, name)
+);
+
+-- modifiers: "milk: dairy / oat", "extra shot". Options change the price and the recipe
+CREATE TABLE modifier_groups (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    min_select INT NOT NULL DEFAULT 0 CHECK (min_select >= 0), -- 1 and more means required
+    max_select INT NOT NULL DEFAULT 1 CHECK (max_select >= 1 AND max_select >= min_select),
+    UNIQUE (product_id, name)
+);
+
+CREATE TABLE modifier_options (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES modifier_groups (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    price_delta DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    add_allergens VARCHAR(64) [] NOT NULL DEFAULT '{}',
+    remove_allergens VARCHAR(64) [] NOT NULL DEFAULT '{}', -- oat milk instead of dairy removes 'dairy'
+    UNIQUE (group_id, name)
+);
+
+CREATE TABLE modifier_option_ingredients (
+    option_id INT NOT NULL REFERENCES modifier_options (id) ON DELETE CASCADE,
+    inventory_id INT NOT NULL REFERENCES inventory (id),
+    quantity FLOAT NOT NULL CHECK (quantity <> 0), -- delta to the recipe: + more, - less
+    PRIMARY KEY (option_id, inventory_id)
+);
+
+-- future price of the item or its variant, applied when effective_at comes
+-- (by apply_scheduled_prices, only the server's scheduler runs it). Orders read it with price_at
+CREATE TABLE scheduled_prices (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
+    variant_id INT REFERENCES menu_item_variants (id) ON DELETE CASCADE, -- NULL is the price of the menu item itself
+    new_price DECIMAL(10, 2) NOT NULL CHECK (new_price >= 0),
+    effective_at TIMESTAMPTZ NOT NULL,
+    applied_at TIMESTAMPTZ, -- NULL: still waiting
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_scheduled_prices_due ON scheduled_prices (effective_at) WHERE applied_at IS NULL;
+
+-- recurring discounts ("20% off pastries from 3 to 5pm") for a product, a category or the
+-- whole menu (both NULL), windows as in menu_item_schedules. The biggest discount wins
+CREATE TABLE price_rules (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    product_id INT REFERENCES menu_items (id) ON DELETE CASCADE,
+    category_id INT REFERENCES categories (id) ON DELETE CASCADE,
+    percent_off DECIMAL(5, 2) NOT NULL CHECK (percent_off > 0 AND percent_off <= 100),
+    weekdays INT [] CHECK (weekdays <@ ARRAY[1, 2, 3, 4, 5, 6, 7]),
+    start_time TIME,
+    end_time TIME,
+    start_date DATE,
+    end_date DATE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    CHECK ((start_time IS NULL) = (end_time IS NULL) AND start_time IS DISTINCT FROM end_time),
+    CHECK (start_date <= end_date)
+);
+
 CREATE TABLE price_history (
     id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
+    variant_id INT REFERENCES menu_item_variants (id) ON DELETE CASCADE, -- NULL is the price of the menu item itself
+    scheduled_id INT REFERENCES scheduled_prices (id) ON DELETE SET NULL, -- NULL: changed by PUT /menu/{id}
     old_price DECIMAL(10, 2) NOT NULL CHECK (old_price >= 0),
     new_price DECIMAL(10, 2) NOT NULL CHECK (new_price >= 0),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP --NOW()
 );
 
+-- ingredient cost of a menu item by its recipe. A bundle costs its components,
+-- a component with several choices is taken by its most expensive choice
+CREATE VIEW menu_item_costs AS
+WITH recipe_costs AS (
+    SELECT m.id AS product_id, COALESCE(SUM(mi.quantity * c.unit_cost), 0) AS cost
+        FROM menu_items AS m
+        LEFT JOIN menu_item_ingredients AS mi ON mi.product_id = m.id
+        LEFT JOIN inventory_unit_costs AS c ON c.id = mi.inventory_id
+        GROUP BY m.id
+)
+SELECT m.id AS product_id,
+    CASE WHEN m.kind = 'bundle' THEN (
+        SELECT COALESCE(SUM(bc.quantity * ch.cost), 0)
+            FROM bundle_components AS bc
+            CROSS JOIN LATERAL (
+                SELECT COALESCE(MAX(r.cost), 0) AS cost
+                    FROM bundle_component_choices AS bcc
+                    JOIN recipe_costs AS r ON r.product_id = bcc.product_id
+                    WHERE bcc.component_id = bc.id
+            ) AS ch
+            WHERE bc.bundle_id = m.id
+    ) ELSE r.cost END AS cost
+    FROM menu_items AS m
+    JOIN recipe_costs AS r ON r.product_id = m.id;
+
 --INDEXING
 CREATE INDEX idx_menu_items_name ON menu_items USING GIN (to_tsvector('english', name));
 
@@ -56,8 +222,101 @@ ON menu_items
 FOR EACH ROW
 EXECUTE FUNCTION record_price_change();
 
+CREATE FUNCTION record_variant_price_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.price <> OLD.price THEN
+        INSERT INTO price_history (product_id, variant_id, old_price, new_price)
+        VALUES (NEW.product_id, NEW.id, OLD.price, NEW.price);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER variant_price_update_trigger
+AFTER UPDATE OF price
+ON menu_item_variants
+FOR EACH ROW
+EXECUTE FUNCTION record_variant_price_change();
+
+-- the rule with the biggest discount for the item at the time, no row if none
+CREATE FUNCTION best_price_rule(item INT, at TIMESTAMP)
+RETURNS TABLE (rule_id INT, rule_name VARCHAR, percent_off DECIMAL) AS $$
+    SELECT r.id, r.name, r.percent_off
+        FROM price_rules AS r
+        JOIN menu_items AS m ON m.id = item
+        WHERE r.active
+            AND (r.product_id IS NULL OR r.product_id = m.id)
+            AND (r.category_id IS NULL OR r.category_id = m.category_id)
+            AND in_time_window(at, r.weekdays, r.start_time, r.end_time, r.start_date, r.end_date)
+        ORDER BY r.percent_off DESC, r.id
+        LIMIT 1;
+$$ LANGUAGE sql STABLE;
+
+-- applies the due scheduled prices. The triggers above write price_history now,
+-- it is moved to effective_at, when the change took effect
+CREATE FUNCTION apply_scheduled_prices()
+RETURNS INT AS $$
+DECLARE
+    due RECORD;
+    applied INT := 0;
+BEGIN
+    FOR due IN
+        SELECT *
+            FROM scheduled_prices
+            WHERE applied_at IS NULL AND effective_at <= CURRENT_TIMESTAMP
+            ORDER BY effective_at, id
+            FOR UPDATE SKIP LOCKED
+    LOOP
+        IF due.variant_id IS NULL THEN
+            UPDATE menu_items SET price = due.new_price WHERE id = due.product_id;
+        ELSE
+            UPDATE menu_item_variants SET price = due.new_price WHERE id = due.variant_id;
+        END IF;
+        UPDATE price_history
+            SET updated_at = due.effective_at, scheduled_id = due.id
+            WHERE id = (
+                SELECT MAX(id)
+                    FROM price_history
+                    WHERE product_id = due.product_id
+                        AND variant_id IS NOT DISTINCT FROM due.variant_id
+                        AND scheduled_id IS NULL
+                        AND updated_at = CURRENT_TIMESTAMP
+            );
+        UPDATE scheduled_prices SET applied_at = CURRENT_TIMESTAMP WHERE id = due.id;
+        applied := applied + 1;
+    END LOOP;
+    RETURN applied;
+END;
+$$ LANGUAGE plpgsql;
+
+-- price of the item (variant NULL) or of its variant at the time: the last scheduled price
+-- due by then and not applied yet, else the current one. It only reads, so an order
+-- does not wait for the scheduler and does not write the menu
+CREATE FUNCTION price_at(item INT, variant INT, at TIMESTAMPTZ)
+RETURNS DECIMAL AS $$
+    SELECT COALESCE(
+        (SELECT new_price
+            FROM scheduled_prices
+            WHERE product_id = item AND variant_id IS NOT DISTINCT FROM variant
+                AND applied_at IS NULL AND effective_at <= at
+            ORDER BY effective_at DESC, id DESC
+            LIMIT 1),
+        CASE WHEN variant IS NULL
+            THEN (SELECT price FROM menu_items WHERE id = item)
+            ELSE (SELECT price FROM menu_item_variants WHERE id = variant AND product_id = item)
+        END);
+$$ LANGUAGE sql STABLE;
+
 
 --MENU
+INSERT INTO
+    categories (name, description, position)
+VALUES ('Coffee', 'Espresso drinks', 1),
+    ('Breakfast', 'Served in the morning', 2),
+    ('Desserts', 'Cakes, cookies and ice cream', 3),
+    ('Combos', 'Sets at a better price', 4);
+
 INSERT INTO
     menu_items (
         name,
@@ -137,6 +396,21 @@ VALUES (
         6.00
     );
 
+UPDATE menu_items
+SET category_id = CASE
+        WHEN id IN (1, 2) THEN 1
+        WHEN id IN (4, 9, 10) THEN 2
+        ELSE 3
+    END;
+
+INSERT INTO
+    menu_item_schedules (product_id, start_time, end_time)
+VALUES (4, '07:00', '12:00'); -- Honey Oatmeal is breakfast only
+
+INSERT INTO
+    price_rules (name, category_id, percent_off, start_time, end_time)
+VALUES ('Afternoon desserts', 3, 20, '15:00', '17:00');
+
 INSERT INTO
     menu_item_ingredients (
         product_id,
@@ -179,6 +453,82 @@ VALUES (1, 1, 30), -- Espresso -> Espresso Beans
     (10, 6, 1), -- Almond Croissant -> Eggs
     (10, 20, 30);
 
+INSERT INTO
+    menu_items (
+        kind,
+        category_id,
+        name,
+        description,
+        tags,
+        allergens,
+        price
+    )
+VALUES (
+        'bundle',
+        4,
+        'Coffee and Pastry',
+        'Cappuccino with any pastry of the day',
+        ARRAY['combo', 'coffee'],
+        NULL,
+        9.00
+    );
+
+INSERT INTO
+    bundle_components (bundle_id, name, quantity)
+VALUES (11, 'Coffee', 1),
+    (11, 'Pastry', 1);
+
+INSERT INTO
+    bundle_component_choices (component_id, product_id)
+VALUES (1, 2), -- Coffee -> Cappuccino
+    (2, 3), -- Pastry -> Chocolate Chip Cookies
+    (2, 8), -- Pastry -> Chocolate Brownie
+    (2, 10); -- Pastry -> Almond Croissant
+
+INSERT INTO
+    menu_item_variants (
+        product_id,
+        name,
+        price,
+        scale
+    )
+VALUES (2, 'Small', 3.90, 0.75), -- Cappuccino
+    (2, 'Large', 5.40, 1.5);
+
+INSERT INTO
+    modifier_groups (
+        product_id,
+        name,
+        min_select,
+        max_select
+    )
+VALUES (1, 'Extra', 0, 1), -- Espresso
+    (2, 'Extra', 0, 2), -- Cappuccino
+    (2, 'Milk', 0, 1); -- Cappuccino
+
+INSERT INTO
+    modifier_options (
+        group_id,
+        name,
+        price_delta,
+        remove_allergens
+    )
+VALUES (1, 'Extra shot', 1.00, '{}'),
+    (2, 'Extra shot', 1.00, '{}'),
+    (2, 'Cinnamon', 0.30, '{}'),
+    (3, 'No milk', -0.50, ARRAY['dairy']);
+
+INSERT INTO
+    modifier_option_ingredients (
+        option_id,
+        inventory_id,
+        quantity
+    )
+VALUES (1, 1, 30), -- Extra shot -> Espresso Beans
+    (2, 1, 30), -- Extra shot -> Espresso Beans
+    (3, 10, 2), -- Cinnamon -> Cinnamon Powder
+    (4, 2, -100); -- No milk -> minus Milk of the recipe
+
 INSERT INTO
     price_history (
         product_id,
@@ -1,23 +1,342 @@
-CREATE TYPE order_status AS ENUM ('processing', 'accepted');
+CREATE TYPE order_status AS ENUM (
+    'processing',
+    'accepted',
+    'preparing',
+    'ready',
+    'picked_up',
+    'cancelled',
+    'refunded'
+);
+
+-- tax of a menu category, or the default one (category_id NULL) for the rest
+CREATE TABLE tax_rates (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    rate DECIMAL(5, 2) NOT NULL CHECK (rate >= 0 AND rate < 100), -- percent
+    category_id INT UNIQUE REFERENCES categories (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_tax_rates_default ON tax_rates ((category_id IS NULL))
+WHERE
+    category_id IS NULL;
+
+-- 1 row. TRUE: menu prices have the tax in them, FALSE: the tax is added on top
+CREATE TABLE tax_settings (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    prices_include_tax BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+INSERT INTO tax_settings DEFAULT VALUES;
+
+INSERT INTO tax_rates (name, rate) VALUES ('VAT', 12);
+
+-- opening hours by ISO weekday (1 Monday .. 7 Sunday), a day without a row is closed
+CREATE TABLE store_hours (
+    weekday INT PRIMARY KEY CHECK (weekday BETWEEN 1 AND 7),
+    opens TIME NOT NULL,
+    closes TIME NOT NULL,
+    CHECK (opens < closes)
+);
+
+INSERT INTO
+    store_hours (weekday, opens, closes)
+SELECT d, '07:00', '20:00' FROM generate_series(1, 5) AS d
+UNION ALL
+SELECT d, '09:00', '18:00' FROM generate_series(6, 7) AS d;
+
+-- 1 row. pre-orders: the day is cut into slots, a slot takes not more than slot_capacity orders
+CREATE TABLE pickup_settings (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    slot_minutes INT NOT NULL DEFAULT 15 CHECK (slot_minutes > 0 AND 1440 % slot_minutes = 0),
+    slot_capacity INT NOT NULL DEFAULT 10 CHECK (slot_capacity > 0),
+    max_days_ahead INT NOT NULL DEFAULT 7 CHECK (max_days_ahead > 0),
+    timezone TEXT NOT NULL DEFAULT current_setting('TimeZone') -- IANA name, like Asia/Almaty
+);
+
+INSERT INTO pickup_settings DEFAULT VALUES;
+
+-- places taken in a slot (start of the slot of the current settings). A pre-order takes a place
+-- by a conditional UPDATE, so 2 orders at once see each other (a COUNT of orders in a REPEATABLE
+-- READ order would read an old snapshot). A missing row is made from the COUNT; a change of the
+-- settings deletes all rows
+CREATE TABLE pickup_slots (
+    start TIMESTAMPTZ PRIMARY KEY,
+    taken INT NOT NULL CHECK (taken >= 0)
+);
+
+-- local time of the store: opening hours, pickup slots, menu schedules and price rules are in it
+CREATE FUNCTION store_time(at TIMESTAMPTZ)
+RETURNS TIMESTAMP AS $$
+    SELECT at AT TIME ZONE timezone FROM pickup_settings;
+$$ LANGUAGE sql STABLE;
+
+-- start of the slot of at, slots go from midnight
+CREATE FUNCTION pickup_slot(at TIMESTAMP, minutes INT)
+RETURNS TIMESTAMP AS $$
+    SELECT date_trunc('day', at) + FLOOR(EXTRACT(EPOCH FROM at::time) / 60 / minutes) * minutes * INTERVAL '1 minute';
+$$ LANGUAGE sql IMMUTABLE;
+
+-- regulars; an order without customer_id is a walk-in with only a name
+CREATE TABLE customers (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    phone VARCHAR(32) NOT NULL DEFAULT '',
+    email VARCHAR(128) NOT NULL DEFAULT '',
+    allergens VARCHAR(64) [] NOT NULL DEFAULT '{}', -- for orders that come without allergens
+    notes TEXT NOT NULL DEFAULT '',
+    -- loyalty balance, changed in the same statement as every loyalty_ledger row. Spending is
+    -- a conditional UPDATE, so 2 orders at once see each other's points (a SUM of the ledger
+    -- in a REPEATABLE READ order would read an old snapshot). Reversals can take it below 0
+    points INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_customers_phone ON customers (phone) WHERE phone <> '';
+CREATE UNIQUE INDEX idx_customers_email ON customers (LOWER(email)) WHERE email <> '';
+
+CREATE TYPE loyalty_rule_kind AS ENUM ('amount', 'item');
+
+-- amount: points for every per_amount of the total; item: points for every unit with one of the tags
+CREATE TABLE loyalty_rules (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    kind loyalty_rule_kind NOT NULL,
+    points INT NOT NULL CHECK (points > 0),
+    per_amount DECIMAL(10, 2) CHECK (per_amount > 0),
+    tags TEXT [] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    CHECK (
+        (kind = 'amount' AND per_amount IS NOT NULL)
+        OR (kind = 'item' AND CARDINALITY(tags) > 0)
+    )
+);
+
+-- 1 row. how much 1 point takes off an order
+CREATE TABLE loyalty_settings (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    point_value DECIMAL(10, 2) NOT NULL DEFAULT 1 CHECK (point_value > 0)
+);
+
+INSERT INTO loyalty_settings DEFAULT VALUES;
+
+INSERT INTO loyalty_rules (name, kind, points, per_amount) VALUES ('Every 100', 'amount', 1, 100);
+
+CREATE TYPE loyalty_entry_kind AS ENUM ('earn', 'redeem', 'reverse', 'adjust');
+
+-- the balance of a customer is SUM(points), kept in customers.points; rows are only added
+CREATE TABLE loyalty_ledger (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    customer_id INT NOT NULL REFERENCES customers (id),
+    order_id INT, -- no FK: the rows stay after the order is deleted
+    kind loyalty_entry_kind NOT NULL,
+    points INT NOT NULL CHECK (points <> 0), -- redeem is negative, reverse takes back the sum of an order
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_loyalty_ledger_customer_id ON loyalty_ledger (customer_id);
+CREATE INDEX idx_loyalty_ledger_order_id ON loyalty_ledger (order_id);
+
+CREATE OR REPLACE FUNCTION loyalty_ledger_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'loyalty_ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_loyalty_ledger_append_only
+BEFORE UPDATE OR DELETE ON loyalty_ledger
+FOR EACH ROW EXECUTE FUNCTION loyalty_ledger_append_only();
 
 CREATE TABLE orders (
     id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    customer_id INT REFERENCES customers (id), -- a customer with orders is not deleted
     customer_name VARCHAR(64) NOT NULL,
     status order_status NOT NULL DEFAULT 'processing',
     allergens VARCHAR(64) [],
+    subtotal DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (subtotal >= 0), -- gross: sum of lines
+    promo_code VARCHAR(32) NOT NULL DEFAULT '',
+    discount DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (discount >= 0), -- by the promo code and points
+    redeem_points INT NOT NULL DEFAULT 0 CHECK (redeem_points >= 0),
+    points_off DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (points_off >= 0), -- the part of discount paid by points
+    tax DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (tax >= 0),
+    tax_inclusive BOOLEAN NOT NULL DEFAULT TRUE, -- tax_settings at the moment of the order
+    -- to pay: subtotal - discount, + tax if it is not inclusive
     total DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (total >= 0),
+    reason TEXT NOT NULL DEFAULT '', -- why the order was cancelled
+    pickup_at TIMESTAMPTZ, -- pre-order: requested pickup time, NULL is now
     created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP --NOW()
 );
 
 CREATE TABLE order_items (
-    -- id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY, -- 1 product can be in 2 lines with other modifiers
     order_id INT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
     product_id INT NOT NULL REFERENCES menu_items (id) ON DELETE CASCADE,
     quantity INT NOT NULL CHECK (quantity > 0),
-    PRIMARY KEY (order_id, product_id)
+    -- snapshot of the menu item at the moment of the order, menu changes don't touch old orders
+    name VARCHAR(64) NOT NULL,
+    variant_id INT, -- no FK, like option_id
+    variant_name VARCHAR(64) NOT NULL DEFAULT '',
+    unit_price DECIMAL(10, 2) NOT NULL CHECK (unit_price >= 0),
+    price_rule VARCHAR(64) NOT NULL DEFAULT '', -- discount applied to the menu price (happy hour)
+    line_total DECIMAL(10, 2) GENERATED ALWAYS AS (unit_price * quantity) STORED,
+    discount DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (discount >= 0), -- share of the promo and points discount
+    tax_rate DECIMAL(5, 2) NOT NULL DEFAULT 0, -- snapshot of tax_rates
+    tax DECIMAL(10, 2) NOT NULL DEFAULT 0, -- of line_total - discount
+    allergens VARCHAR(64) [] NOT NULL DEFAULT '{}' -- of the menu item with modifiers, for the kitchen
+);
+
+CREATE INDEX idx_order_items_order_id ON order_items (order_id);
+
+-- chosen modifier options, copied like the line itself
+CREATE TABLE order_item_modifiers (
+    item_id INT NOT NULL REFERENCES order_items (id) ON DELETE CASCADE,
+    option_id INT NOT NULL, -- no FK: the menu can be edited, the order stays
+    name VARCHAR(64) NOT NULL,
+    price_delta DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (item_id, option_id)
+);
+
+-- what a bundle line was made of. share is the part of unit_price credited to the
+-- component in reports (by its menu price), so bundle sales count for real products
+CREATE TABLE order_item_components (
+    item_id INT NOT NULL REFERENCES order_items (id) ON DELETE CASCADE,
+    component_id INT NOT NULL, -- no FK, like option_id
+    product_id INT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    share DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (item_id, component_id)
+);
+
+-- what the line took from inventory (recipe with modifiers * quantity).
+-- cancel and update return exactly this, even if the recipe was changed after
+CREATE TABLE order_item_ingredients (
+    item_id INT NOT NULL REFERENCES order_items (id) ON DELETE CASCADE,
+    inventory_id INT NOT NULL REFERENCES inventory (id) ON DELETE CASCADE,
+    quantity FLOAT NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (item_id, inventory_id)
+);
+
+CREATE TYPE payment_method AS ENUM ('cash', 'card', 'voucher');
+
+-- tenders of an order, a split bill is several payments. Only cash has change.
+-- money records are never deleted with their order (RESTRICT)
+CREATE TABLE payments (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES orders (id) ON DELETE RESTRICT,
+    method payment_method NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL CHECK (amount > 0), -- part of orders.total it pays
+    tendered DECIMAL(10, 2) NOT NULL, -- what was given
+    change_due DECIMAL(10, 2) GENERATED ALWAYS AS (tendered - amount) STORED,
+    reference VARCHAR(64) NOT NULL DEFAULT '', -- card slip or voucher number
+    voided_at TIMESTAMPTZ, -- taken back before the order was closed, not counted
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (tendered >= amount),
+    CHECK (method = 'cash' OR tendered = amount)
+);
+
+CREATE INDEX idx_payments_order_id ON payments (order_id);
+
+CREATE INDEX idx_payments_created_at ON payments (created_at);
+
+-- money given back for an accepted order: all of it or some units of lines.
+-- amount is what was paid for them: line_total - discount (+ tax if it was on top)
+CREATE TABLE refunds (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES orders (id) ON DELETE RESTRICT,
+    method payment_method NOT NULL,
+    reason TEXT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL CHECK (amount >= 0),
+    tax DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (tax >= 0), -- tax in amount, it is not collected
+    restock BOOLEAN NOT NULL DEFAULT FALSE, -- ingredients of the units went back to inventory
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refunds_order_id ON refunds (order_id);
+
+CREATE INDEX idx_refunds_created_at ON refunds (created_at);
+
+CREATE TABLE refund_items (
+    refund_id INT NOT NULL REFERENCES refunds (id) ON DELETE RESTRICT,
+    item_id INT NOT NULL REFERENCES order_items (id) ON DELETE RESTRICT,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    amount DECIMAL(10, 2) NOT NULL,
+    tax DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (refund_id, item_id)
+);
+
+CREATE TYPE promo_kind AS ENUM ('percent', 'fixed');
+
+-- promo code: percent or fixed amount off the eligible lines of an order
+CREATE TABLE promo_codes (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    code VARCHAR(32) NOT NULL UNIQUE, -- upper case
+    description TEXT NOT NULL DEFAULT '',
+    kind promo_kind NOT NULL,
+    value DECIMAL(10, 2) NOT NULL CHECK (value > 0), -- percent or money
+    min_spend DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (min_spend >= 0), -- subtotal of the order
+    -- eligible lines: these products or menu items with one of these tags, both empty is every line
+    product_ids INT [] NOT NULL DEFAULT '{}',
+    tags TEXT [] NOT NULL DEFAULT '{}',
+    starts_at TIMESTAMPTZ, -- NULL: no limit
+    ends_at TIMESTAMPTZ,
+    max_uses INT CHECK (max_uses > 0), -- NULL: no limit
+    max_uses_per_customer INT CHECK (max_uses_per_customer > 0),
+    -- redemptions of orders not cancelled; taken by a conditional UPDATE, so 2 orders at once
+    -- see each other's use (a COUNT in a REPEATABLE READ order would read an old snapshot)
+    uses INT NOT NULL DEFAULT 0 CHECK (uses >= 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (kind = 'fixed' OR value <= 100),
+    CHECK (starts_at IS NULL OR ends_at IS NULL OR starts_at < ends_at)
+);
+
+-- 1 code for 1 order. Uses of cancelled orders are given back (not counted).
+-- A used code is not deleted, it is turned off by active
+CREATE TABLE promo_redemptions (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    promo_id INT NOT NULL REFERENCES promo_codes (id) ON DELETE RESTRICT,
+    order_id INT NOT NULL UNIQUE REFERENCES orders (id) ON DELETE CASCADE,
+    customer_id INT REFERENCES customers (id), -- NULL: a walk-in
+    customer_name VARCHAR(64) NOT NULL,
+    discount DECIMAL(10, 2) NOT NULL CHECK (discount >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_promo_redemptions_promo_id ON promo_redemptions (promo_id);
+
+-- uses of a code by a customer for max_uses_per_customer, taken like promo_codes.uses
+CREATE TABLE promo_customer_uses (
+    promo_id INT NOT NULL REFERENCES promo_codes (id) ON DELETE CASCADE,
+    customer_id INT NOT NULL REFERENCES customers (id),
+    uses INT NOT NULL DEFAULT 0 CHECK (uses >= 0),
+    PRIMARY KEY (promo_id, customer_id)
 );
 
+INSERT INTO
+    promo_codes (code, description, kind, value, min_spend, tags, max_uses_per_customer)
+VALUES (
+        'WELCOME10',
+        '10% off the first order',
+        'percent',
+        10,
+        0,
+        '{}',
+        1
+    ),
+    (
+        'COFFEE2',
+        '2.00 off coffee from 10.00',
+        'fixed',
+        2,
+        10,
+        ARRAY['coffee'],
+        NULL
+    );
+
 CREATE TABLE order_status_history (
     id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     order_id INT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
@@ -25,19 +344,54 @@ CREATE TABLE order_status_history (
     updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP --NOW()
 );
 
+-- saved responses of POST /orders and POST /orders/batch-process for the Idempotency-Key header
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) NOT NULL,
+    endpoint VARCHAR(64) NOT NULL,
+    request_hash CHAR(64) NOT NULL, -- sha256 of the method, path, query and body
+    status_code INT NOT NULL DEFAULT 0, -- 0 while the first request is in progress
+    response BYTEA NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (key, endpoint)
+);
+
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys (created_at);
+
+CREATE TYPE batch_job_status AS ENUM ('queued', 'running', 'done', 'failed');
+
+-- async POST /orders/batch-process?async=true, state is here so jobs survive a restart
+CREATE TABLE batch_jobs (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    status batch_job_status NOT NULL DEFAULT 'queued',
+    atomic BOOLEAN NOT NULL DEFAULT FALSE,
+    total INT NOT NULL CHECK (total >= 0),
+    processed INT NOT NULL DEFAULT 0 CHECK (processed >= 0),
+    request JSONB NOT NULL, -- input orders
+    result JSONB, -- OutputBatches, filled while processing
+    error TEXT NOT NULL DEFAULT '',
+    locked_by TEXT NOT NULL DEFAULT '', -- worker which runs the job
+    heartbeat_at TIMESTAMPTZ, -- a running job without heartbeats for the lease is taken by another worker
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_batch_jobs_status ON batch_jobs (status);
+
 --orders
 CREATE INDEX idx_orders_customer_name ON orders USING GIN (
     to_tsvector('english', customer_name)
 );
 
 CREATE INDEX idx_orders_allergens ON orders USING GIN (allergens);
+CREATE INDEX idx_orders_customer_id ON orders (customer_id);
+CREATE INDEX idx_orders_pickup_at ON orders (pickup_at) WHERE pickup_at IS NOT NULL;
 
 -- 1. Функция-триггер
 CREATE OR REPLACE FUNCTION log_order_status_change()
 RETURNS TRIGGER AS $$
 BEGIN
-  -- Проверяем, изменился ли статус
-  IF NEW.status IS DISTINCT FROM OLD.status THEN
+  -- Новый заказ тоже пишем, чтобы история начиналась с 'processing'
+  IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status THEN
     INSERT INTO order_status_history (order_id, status)
     VALUES (NEW.id, NEW.status);
   END IF;
@@ -45,12 +399,6 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
--- 2. Сам триггер
-CREATE TRIGGER trg_log_order_status_change
-AFTER UPDATE ON orders
-FOR EACH ROW
-EXECUTE FUNCTION log_order_status_change();
-
 INSERT INTO
     orders (
         customer_name,
@@ -306,8 +654,13 @@ INSERT INTO
     order_items (
         order_id,
         product_id,
-        quantity
+        quantity,
+        name,
+        unit_price,
+        allergens
     )
+SELECT v.order_id, v.product_id, v.quantity, m.name, m.price, COALESCE(m.allergens, '{}')
+FROM (
 VALUES (1, 2, 1),
     (2, 3, 3),
     (3, 8, 2),
@@ -337,7 +690,16 @@ VALUES (1, 2, 1),
     (27, 8, 2),
     (28, 3, 1),
     (29, 7, 3),
-    (30, 10, 1);
+    (30, 10, 1)
+) AS v (order_id, product_id, quantity)
+JOIN menu_items AS m ON m.id = v.product_id
+ORDER BY v.order_id;
+
+INSERT INTO
+    order_item_ingredients (item_id, inventory_id, quantity)
+SELECT oi.id, mi.inventory_id, mi.quantity * oi.quantity
+FROM order_items AS oi
+JOIN menu_item_ingredients AS mi ON mi.product_id = oi.product_id;
 
 -- Вставка данных в таблицу order_status_history
 INSERT INTO
@@ -364,4 +726,99 @@ VALUES (1, 'processing', '2024-01-05'),
         'processing',
         '2024-03-15'
     ),
-    (15, 'accepted', '2024-03-21');
\ No newline at end of file
+    (15, 'accepted', '2024-03-21');
+
+-- seed orders are before taxes and promo codes
+UPDATE orders SET subtotal = total;
+
+INSERT INTO
+    customers (name, phone, email, allergens)
+VALUES ('Alice Smith', '+77010000001', 'alice@example.com', ARRAY['dairy']),
+    ('Bob Johnson', '+77010000002', 'bob@example.com', ARRAY['gluten']),
+    ('Urystem Qabdolla', '+77010000003', '', ARRAY['nuts']);
+
+UPDATE orders AS o
+SET customer_id = c.id
+FROM customers AS c
+WHERE c.name = o.customer_name;
+
+-- points of the seed orders by the default rule
+INSERT INTO
+    loyalty_ledger (customer_id, order_id, kind, points, created_at)
+SELECT o.customer_id, o.id, 'earn', FLOOR(o.total / 100)::INT, o.updated_at
+FROM orders AS o
+WHERE
+    o.customer_id IS NOT NULL
+    AND o.status IN ('accepted', 'preparing', 'ready', 'picked_up')
+    AND o.total >= 100;
+
+UPDATE customers AS c
+SET points = l.points
+FROM (
+        SELECT customer_id, SUM(points) AS points
+        FROM loyalty_ledger
+        GROUP BY customer_id
+    ) AS l
+WHERE c.id = l.customer_id;
+
+-- 2. Сам триггер (created after the seed data, its history is inserted above by hand)
+CREATE TRIGGER trg_log_order_status_change
+AFTER INSERT OR UPDATE ON orders
+FOR EACH ROW
+EXECUTE FUNCTION log_order_status_change();
+
+-- события заказов для GET /orders/stream, id события идёт клиенту как Last-Event-ID
+CREATE TYPE order_event_kind AS ENUM ('created', 'updated', 'status_changed', 'deleted');
+
+CREATE TABLE order_events (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    order_id INT NOT NULL, -- no FK: a deleted order has its event too
+    kind order_event_kind NOT NULL,
+    status order_status NOT NULL,
+    xact BIGINT NOT NULL DEFAULT txid_current(), -- transaction of the change
+    -- ids are taken before commit: a smaller id can commit later, but only of a transaction
+    -- which was running when this event was written, its xact is not less than this
+    xact_xmin BIGINT NOT NULL DEFAULT txid_snapshot_xmin(txid_current_snapshot()),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_order_events_order_id ON order_events (order_id);
+CREATE INDEX idx_order_events_created_at ON order_events (created_at);
+
+-- 3. Событие в order_events и NOTIFY после commit. Новый заказ и PUT меняют строку
+-- заказа несколько раз (итоги, налог), клиент получает одно событие на транзакцию
+CREATE OR REPLACE FUNCTION notify_order_event()
+RETURNS TRIGGER AS $$
+DECLARE
+  ev order_events;
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    INSERT INTO order_events (order_id, kind, status)
+    VALUES (OLD.id, 'deleted', OLD.status)
+    RETURNING * INTO ev;
+  ELSIF TG_OP = 'INSERT' THEN
+    INSERT INTO order_events (order_id, kind, status)
+    VALUES (NEW.id, 'created', NEW.status)
+    RETURNING * INTO ev;
+  ELSIF NEW.status IS DISTINCT FROM OLD.status THEN
+    INSERT INTO order_events (order_id, kind, status)
+    VALUES (NEW.id, 'status_changed', NEW.status)
+    RETURNING * INTO ev;
+  ELSIF EXISTS (SELECT 1 FROM order_events WHERE order_id = NEW.id AND xact = txid_current()) THEN
+    RETURN NULL;
+  ELSE
+    INSERT INTO order_events (order_id, kind, status)
+    VALUES (NEW.id, 'updated', NEW.status)
+    RETURNING * INTO ev;
+  END IF;
+  PERFORM pg_notify('order_events', json_build_object(
+    'event_id', ev.id, 'order_id', ev.order_id, 'type', ev.kind,
+    'status', ev.status, 'created_at', ev.created_at)::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_notify_order_event
+AFTER INSERT OR UPDATE OR DELETE ON orders
+FOR EACH ROW
+EXECUTE FUNCTION notify_order_event();
@@ -0,0 +1,16 @@
+package models
+
+// section of the menu, smaller position goes first
+type Category struct {
+	ID          uint64 `json:"category_id" db:"id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	Position    int64  `json:"position" db:"position"`
+}
+
+// output of GET /menu?view=sections: categories in order with their items,
+// items without a category go to the last section (category_id 0)
+type MenuSection struct {
+	Category
+	Items []MenuItem `json:"items"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// regular customer, allergens are for the orders that come without them
+type Customer struct {
+	ID        uint64         `json:"customer_id" db:"id"`
+	Name      string         `json:"name" db:"name"`
+	Phone     string         `json:"phone,omitempty" db:"phone"`
+	Email     string         `json:"email,omitempty" db:"email"`
+	Allergens pq.StringArray `json:"allergens" db:"allergens"`
+	Notes     string         `json:"notes,omitempty" db:"notes"`
+	Points    int64          `json:"points" db:"points"` // loyalty balance, filled by server
+	CreatedAt time.Time      `json:"created_at,omitzero" db:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at,omitzero" db:"updated_at"`
+}
+
+// lifetime value of a customer: sold and refunded orders, what is paid minus refunds
+type CustomerValue struct {
+	CustomerID    uint64     `json:"customer_id" db:"id"`
+	Name          string     `json:"name" db:"name"`
+	Orders        uint64     `json:"orders" db:"orders"`
+	Spent         float64    `json:"spent" db:"spent"`
+	Refunded      float64    `json:"refunded" db:"refunded"`
+	LifetimeValue float64    `json:"lifetime_value" db:"lifetime_value"`
+	AverageOrder  float64    `json:"average_order" db:"average_order"`
+	FirstOrder    *time.Time `json:"first_order,omitempty" db:"first_order"`
+	LastOrder     *time.Time `json:"last_order,omitempty" db:"last_order"`
+}
@@ -11,9 +11,19 @@ var (
 	ErrBadInputItems       = errors.Join(ErrBadInput, errors.New("items invalid"))   // 400
 	ErrNotFoundItems       = errors.Join(ErrNotFound, errors.New("items not found")) // 404 //for menu ings and product items
 	ErrOrderNotEnoughItems = errors.New("items not enough")                          // 500 used for not enough invents for order
+	ErrUnavailableItems    = errors.New("items unavailable")                         // 409 taken off (86) or out of schedule
+	ErrPromoCode           = errors.Join(ErrBadInput, errors.New("bad promo code"))  // 400 unknown, expired, used up or no eligible items
+	ErrCustomer            = errors.Join(ErrBadInput, errors.New("bad customer"))    // 400 unknown customer_id of an order
+	ErrLoyalty             = errors.Join(ErrBadInput, errors.New("bad points"))      // 400 points without a customer, over the balance or the total
+	ErrPickup              = errors.Join(ErrBadInput, errors.New("bad pickup time")) // 400 in the past, too far, store closed or the slot is full
 	ErrOrderStatusClosed   = errors.New("order is already closed")                   // 400
+	ErrOrderNotPaid        = errors.New("order is not fully paid")                   // 409 close before payments
+	ErrOrderStatusMove     = errors.New("order status transition not allowed")       // 409
 	ErrOrdersMultiStatus   = errors.New("orders multi accepted")                     // 207
 	ErrAllergen            = errors.New("found allergen")                            // 418 (unused)
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with another body")  // 422
+	ErrIdempotencyBusy     = errors.New("idempotency key request in progress")       // 409
+	ErrBatchLease          = errors.New("batch job is leased by another worker")     // the worker stops, the job goes on there
 )
 
 // 200 OK
@@ -10,6 +10,24 @@ type Inventory struct {
 	ReorderLvl float64 `json:"reorder_level" db:"reorder_level"`
 	Unit       string  `json:"unit" db:"unit"`
 	Price      float64 `json:"price" db:"price"`
+
+	Recipe []PrepIngredient `json:"recipe,omitempty"` // only prep items have it
+}
+
+// what 1 unit of a prep item is made of, it can be another prep item
+type PrepIngredient struct {
+	Status      string  `json:"status,omitempty"`
+	PrepID      uint64  `json:"-" db:"prep_id"`
+	InventoryID uint64  `json:"inventory_id" db:"inventory_id"`
+	Quantity    float64 `json:"quantity" db:"quantity"`
+}
+
+// POST /inventory/{id}/produce
+type Production struct {
+	PrepID    uint64            `json:"ingredient_id"`
+	Quantity  float64           `json:"quantity"`
+	Used      []InventoryUpdate `json:"used,omitempty"`
+	NotEnough []NotEnoughIng    `json:"not_enough,omitempty"`
 }
 
 // бұған json тегі қатты керек емес)
@@ -27,4 +45,8 @@ type InventoryDepend struct {
 		ProductID uint64 `json:"product_id" db:"id"`
 		Name      string `json:"name" db:"name"`
 	} `json:"menu_items"`
+	Preps []struct {
+		PrepID uint64 `json:"ingredient_id" db:"id"`
+		Name   string `json:"name" db:"name"`
+	} `json:"prep_items,omitempty"`
 }
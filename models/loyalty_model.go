@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// amount: Points for every PerAmount of the order total; item: Points for every unit with one of Tags
+type LoyaltyRule struct {
+	ID        uint64         `json:"rule_id" db:"id"`
+	Name      string         `json:"name" db:"name"`
+	Kind      string         `json:"kind" db:"kind"` // amount or item
+	Points    uint64         `json:"points" db:"points"`
+	PerAmount *float64       `json:"per_amount,omitempty" db:"per_amount"`
+	Tags      pq.StringArray `json:"tags" db:"tags"`
+	Active    *bool          `json:"active" db:"active"`
+}
+
+// output of GET /loyalty, PUT /loyalty changes only point_value
+type LoyaltySettings struct {
+	PointValue *float64      `json:"point_value" db:"point_value"` // money off for 1 point
+	Rules      []LoyaltyRule `json:"rules,omitempty"`
+}
+
+// a row of the ledger: earn and adjust are positive, redeem is negative, reverse takes back an order
+type LoyaltyEntry struct {
+	ID         uint64    `json:"entry_id" db:"id"`
+	CustomerID uint64    `json:"customer_id" db:"customer_id"`
+	OrderID    *uint64   `json:"order_id,omitempty" db:"order_id"`
+	Kind       string    `json:"kind" db:"kind"`
+	Points     int64     `json:"points" db:"points"`
+	Note       string    `json:"note,omitempty" db:"note"`
+	CreatedAt  time.Time `json:"created_at,omitzero" db:"created_at"`
+}
+
+// output of GET /customers/{id}/loyalty, the newest entries first
+type LoyaltyAccount struct {
+	CustomerID uint64         `json:"customer_id" db:"customer_id"`
+	Balance    int64          `json:"balance" db:"balance"`
+	Value      float64        `json:"value" db:"value"` // balance * point_value
+	Entries    []LoyaltyEntry `json:"entries"`
+}
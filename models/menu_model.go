@@ -7,13 +7,123 @@ import (
 )
 
 type MenuItem struct {
-	ID          uint64            `json:"product_id" db:"id"`
-	Name        string            `json:"name" db:"name"`
-	Description string            `json:"description" db:"description"`
-	Tags        pq.StringArray    `json:"tags" db:"tags"`           /*pgtype.Array[string]*/
-	Allergens   pq.StringArray    `json:"allergens" db:"allergens"` /*pgtype.Array[string]*/
-	Price       float64           `json:"price" db:"price"`
-	Ingredients []MenuIngredients `json:"ingredients,omitempty"`
+	ID           uint64            `json:"product_id" db:"id"`
+	Kind         string            `json:"kind" db:"kind"` // "item" (default) or "bundle"
+	CategoryID   *uint64           `json:"category_id,omitempty" db:"category_id"`
+	Name         string            `json:"name" db:"name"`
+	Description  string            `json:"description" db:"description"`
+	Tags         pq.StringArray    `json:"tags" db:"tags"`           /*pgtype.Array[string]*/
+	Allergens    pq.StringArray    `json:"allergens" db:"allergens"` /*pgtype.Array[string]*/
+	Price        float64           `json:"price" db:"price"`
+	Available    bool              `json:"available" db:"available"` // FALSE is taken off by hand (86)
+	Schedules    []MenuSchedule    `json:"schedules,omitempty"`      // when it is sold, none is always
+	Ingredients  []MenuIngredients `json:"ingredients,omitempty"`
+	Components   []BundleComponent `json:"components,omitempty"` // only for bundle, it has no ingredients
+	Variants     []MenuVariant     `json:"variants,omitempty"`
+	Modifiers    []ModifierGroup   `json:"modifier_groups,omitempty"`
+	Margin       *MenuMargin       `json:"margin,omitempty"`       // filled by server
+	Availability *MenuAvailability `json:"availability,omitempty"` // filled by server
+}
+
+// nil is no limit. Times are "15:04", dates "2006-01-02"
+type TimeWindow struct {
+	Weekdays  pq.Int64Array `json:"weekdays,omitempty" db:"weekdays"` // 1 Monday .. 7 Sunday
+	StartTime *string       `json:"start_time,omitempty" db:"start_time"`
+	EndTime   *string       `json:"end_time,omitempty" db:"end_time"` // before start_time: over midnight
+	StartDate *string       `json:"start_date,omitempty" db:"start_date"`
+	EndDate   *string       `json:"end_date,omitempty" db:"end_date"`
+}
+
+// window when the item is sold
+type MenuSchedule struct {
+	ID        uint64 `json:"schedule_id,omitempty" db:"id"`
+	ProductID uint64 `json:"-" db:"product_id"`
+	TimeWindow
+}
+
+// how many can be made from inventory.quantity now (prep items made on the spot)
+type MenuAvailability struct {
+	Available    *uint64 `json:"available"` // nil: no recipe, nothing limits it
+	LimitingID   uint64  `json:"limiting_inventory_id,omitempty"`
+	LimitingName string  `json:"limiting_ingredient,omitempty"`
+	SoldOut      bool    `json:"sold_out"`    // available is 0
+	OnSchedule   bool    `json:"on_schedule"` // in one of the schedules now
+}
+
+// recipe cost of the price and what is left of it. Percents are nil for a free item
+type MenuMargin struct {
+	Cost        float64  `json:"cost" db:"cost"`
+	Margin      float64  `json:"margin" db:"margin"` // price - cost
+	MarginPct   *float64 `json:"margin_percent" db:"margin_percent"`
+	FoodCostPct *float64 `json:"food_cost_percent" db:"food_cost_percent"`
+}
+
+// output of GET /menu/{id}/cost, also a line of the low-margin report
+type MenuCost struct {
+	ProductID uint64  `json:"product_id" db:"product_id"`
+	VariantID *uint64 `json:"variant_id,omitempty" db:"variant_id"`
+	Name      string  `json:"name" db:"name"`
+	Price     float64 `json:"price" db:"price"`
+	MenuMargin
+	Ingredients []IngredientCost `json:"ingredients,omitempty"`
+	Components  []ComponentCost  `json:"components,omitempty"` // bundle
+	Variants    []MenuCost       `json:"variants,omitempty"`   // recipe cost * scale
+}
+
+type IngredientCost struct {
+	InventoryID uint64  `json:"inventory_id" db:"inventory_id"`
+	Name        string  `json:"name" db:"name"`
+	Unit        string  `json:"unit" db:"unit"`
+	Quantity    float64 `json:"quantity" db:"quantity"`
+	UnitCost    float64 `json:"unit_cost" db:"unit_cost"` // price of 1 unit, a prep item by its recipe
+	Cost        float64 `json:"cost" db:"cost"`
+}
+
+// component of a bundle by its most expensive choice
+type ComponentCost struct {
+	ComponentID uint64  `json:"component_id" db:"component_id"`
+	Name        string  `json:"name" db:"name"`
+	Quantity    uint64  `json:"quantity" db:"quantity"`
+	Cost        float64 `json:"cost" db:"cost"`
+}
+
+// part of a bundle: "Pastry" is one of choices (product ids), 1 choice is fixed
+type BundleComponent struct {
+	ID       uint64        `json:"component_id,omitempty" db:"id"` // PUT: with id it is updated, without it is added
+	BundleID uint64        `json:"-" db:"bundle_id"`
+	Name     string        `json:"name" db:"name"`
+	Quantity uint64        `json:"quantity" db:"quantity"`
+	Choices  pq.Int64Array `json:"choices" db:"choices"`
+}
+
+// "small", "large": own price, recipe quantities are multiplied by scale
+type MenuVariant struct {
+	ID        uint64  `json:"variant_id,omitempty" db:"id"` // PUT: with id it is updated, without it is added
+	ProductID uint64  `json:"-" db:"product_id"`
+	Name      string  `json:"name" db:"name"`
+	Price     float64 `json:"price" db:"price"`
+	Scale     float64 `json:"scale" db:"scale"`
+}
+
+// "milk", "extra shot"
+type ModifierGroup struct {
+	ID        uint64           `json:"group_id,omitempty" db:"id"` // PUT: with id it is updated, without it is added
+	ProductID uint64           `json:"-" db:"product_id"`
+	Name      string           `json:"name" db:"name"`
+	MinSelect uint64           `json:"min_select" db:"min_select"`
+	MaxSelect uint64           `json:"max_select" db:"max_select"`
+	Options   []ModifierOption `json:"options"`
+}
+
+// "oat milk": price delta, allergens and ingredient deltas against the recipe
+type ModifierOption struct {
+	ID              uint64            `json:"option_id,omitempty" db:"id"` // PUT: as group_id
+	GroupID         uint64            `json:"-" db:"group_id"`
+	Name            string            `json:"name" db:"name"`
+	PriceDelta      float64           `json:"price_delta" db:"price_delta"`
+	AddAllergens    pq.StringArray    `json:"add_allergens,omitempty" db:"add_allergens"`
+	RemoveAllergens pq.StringArray    `json:"remove_allergens,omitempty" db:"remove_allergens"`
+	Ingredients     []MenuIngredients `json:"ingredients,omitempty"` // quantity < 0 takes away from the recipe
 }
 
 type MenuIngredients struct {
@@ -29,9 +139,34 @@ type MenuDepend struct {
 }
 
 type PriceHistory struct {
-	ID        uint64    `json:"history_id" db:"id"`
-	ProductID uint64    `json:"product_id" db:"product_id"`
-	OldPrice  float64   `json:"old_price" db:"old_price"`
-	NewPrice  float64   `json:"new_price" db:"new_price"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID          uint64    `json:"history_id" db:"id"`
+	ProductID   uint64    `json:"product_id" db:"product_id"`
+	VariantID   *uint64   `json:"variant_id,omitempty" db:"variant_id"`
+	ScheduledID *uint64   `json:"scheduled_id,omitempty" db:"scheduled_id"` // changed by a scheduled price
+	OldPrice    float64   `json:"old_price" db:"old_price"`
+	NewPrice    float64   `json:"new_price" db:"new_price"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// future price of a menu item or its variant, applied when effective_at comes
+type ScheduledPrice struct {
+	ID          uint64     `json:"change_id" db:"id"`
+	ProductID   uint64     `json:"product_id" db:"product_id"`
+	VariantID   *uint64    `json:"variant_id,omitempty" db:"variant_id"`
+	NewPrice    float64    `json:"price" db:"new_price"`
+	EffectiveAt time.Time  `json:"effective_at" db:"effective_at"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty" db:"applied_at"` // nil: still waiting
+	CreatedAt   time.Time  `json:"created_at,omitzero" db:"created_at"`
+}
+
+// recurring discount, e.g. 20% off desserts from 15:00 to 17:00.
+// For a product, a category or the whole menu (both nil); the biggest discount wins
+type PriceRule struct {
+	ID         uint64  `json:"rule_id" db:"id"`
+	Name       string  `json:"name" db:"name"`
+	ProductID  *uint64 `json:"product_id,omitempty" db:"product_id"`
+	CategoryID *uint64 `json:"category_id,omitempty" db:"category_id"`
+	PercentOff float64 `json:"percent_off" db:"percent_off"`
+	Active     *bool   `json:"active" db:"active"` // nil on input is true
+	TimeWindow
 }
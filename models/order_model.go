@@ -1,34 +1,73 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/lib/pq"
 )
 
 type Order struct {
-	ID           uint64         `json:"order_id" db:"id"`                   // Идентификатор заказа
-	CustomerName string         `json:"customer_name" db:"customer_name"`   // Имя клиента
-	Status       string         `json:"status,omitempty" db:"status"`       // Статус заказа
-	Allergens    pq.StringArray `json:"allergens,omitempty" db:"allergens"` // Список аллергенов
-	Reason       string         `json:"reason,omitempty"`
-	Total        *float64       `json:"total,omitempty" db:"total"`          // Общая стоимость
-	Items        []OrderItem    `json:"items,omitempty"`                     // Заказанные товары (не маппируется на базу)
-	CreatedAt    time.Time      `json:"created_at,omitzero" db:"created_at"` // Дата и время создания
-	UpdatedAt    time.Time      `json:"updated_at,omitzero" db:"updated_at"` // Дата и время обновления
+	ID           uint64         `json:"order_id" db:"id"`                     // Идентификатор заказа
+	CustomerID   *uint64        `json:"customer_id" db:"customer_id"`         // customers, nil for a walk-in
+	CustomerName string         `json:"customer_name" db:"customer_name"`     // Имя клиента
+	Status       string         `json:"status,omitempty" db:"status"`         // Статус заказа
+	Allergens    pq.StringArray `json:"allergens,omitempty" db:"allergens"`   // Список аллергенов
+	Reason       string         `json:"reason,omitempty" db:"reason"`         // Причина отмены (или отказа в batch)
+	PickupAt     *time.Time     `json:"pickup_at,omitempty" db:"pickup_at"`   // pre-order: when the customer comes, nil is now
+	PromoCode    string         `json:"promo_code,omitempty" db:"promo_code"` // input, upper case
+	Subtotal     *float64       `json:"subtotal,omitempty" db:"subtotal"`     // сумма строк (gross)
+	Discount     *float64       `json:"discount,omitempty" db:"discount"`     // скидка по promo_code и баллам
+	RedeemPoints uint64         `json:"redeem_points" db:"redeem_points"`     // input: loyalty points to spend
+	PointsOff    *float64       `json:"points_off,omitempty" db:"points_off"` // the part of discount paid by points
+	Tax          *float64       `json:"tax,omitempty" db:"tax"`               // налог строк
+	TaxInclusive bool           `json:"tax_inclusive" db:"tax_inclusive"`     // tax is in the prices, not on top
+	Total        *float64       `json:"total,omitempty" db:"total"`           // к оплате: subtotal - discount (+ tax, если не inclusive)
+	Items        []OrderItem    `json:"items,omitempty"`                      // Заказанные товары (не маппируется на базу)
+	CreatedAt    time.Time      `json:"created_at,omitzero" db:"created_at"`  // Дата и время создания
+	UpdatedAt    time.Time      `json:"updated_at,omitzero" db:"updated_at"`  // Дата и время обновления
 }
 
 type OrderItem struct {
-	Warning       string         `json:"error,omitempty"`
-	// OrderId       uint64         `json:"-" db:"order_id"`
-	ProductID     uint64         `json:"product_id" db:"product_id"`
-	Quantity      uint64         `json:"quantity,omitempty" db:"quantity"`
-	Allergens     pq.StringArray `json:"allergens,omitempty" db:"allergens"`
-	NotEnoungIngs []struct {     // қарау керек: егер 2 orderItem де бірдей Inventory болса жетіспейтіндері NotEnough әртүрлі болады
-		Inventory_id   uint64  `json:"ingredient_id" db:"id"`
-		Inventory_name string  `json:"inventory_name" db:"name"`
-		NotEnough      float64 `json:"not_enough" db:"not_enough"`
-	} `json:"not_enough,omitempty"`
+	Warning       string               `json:"error,omitempty"`
+	ID            uint64               `json:"item_id,omitempty" db:"id"`
+	ProductID     uint64               `json:"product_id" db:"product_id"`
+	Name          string               `json:"name,omitempty" db:"name"` // snapshot from menu_items, filled by server
+	VariantID     *uint64              `json:"variant_id,omitempty" db:"variant_id"`
+	VariantName   string               `json:"variant,omitempty" db:"variant_name"` // snapshot too
+	Quantity      uint64               `json:"quantity,omitempty" db:"quantity"`
+	UnitPrice     *float64             `json:"unit_price,omitempty" db:"unit_price"` // snapshot from menu_items with modifiers
+	LineTotal     *float64             `json:"line_total,omitempty" db:"line_total"` // unit_price * quantity
+	PriceRule     string               `json:"price_rule,omitempty" db:"price_rule"` // discount in unit_price (happy hour)
+	Discount      *float64             `json:"discount,omitempty" db:"discount"`     // share of the promo and points discount
+	TaxRate       *float64             `json:"tax_rate,omitempty" db:"tax_rate"`     // percent
+	Tax           *float64             `json:"tax,omitempty" db:"tax"`
+	Modifiers     []OrderItemModifier  `json:"modifiers,omitempty"`
+	Components    []OrderItemComponent `json:"components,omitempty"` // choices of a bundle
+	Allergens     pq.StringArray       `json:"allergens,omitempty" db:"allergens"`
+	NotEnoungIngs []NotEnoughIng       `json:"not_enough,omitempty"` // қарау керек: егер 2 orderItem де бірдей Inventory болса жетіспейтіндері NotEnough әртүрлі болады
+}
+
+// input: only option_id, the rest is copied from the menu
+type OrderItemModifier struct {
+	OptionID   uint64   `json:"option_id" db:"option_id"`
+	Name       string   `json:"name,omitempty" db:"name"`
+	PriceDelta *float64 `json:"price_delta,omitempty" db:"price_delta"`
+}
+
+// input: component_id and product_id (only for components with several choices)
+type OrderItemComponent struct {
+	ComponentID uint64   `json:"component_id" db:"component_id"`
+	ProductID   uint64   `json:"product_id" db:"product_id"`
+	Name        string   `json:"name,omitempty" db:"name"`
+	Quantity    uint64   `json:"quantity,omitempty" db:"quantity"`
+	Share       *float64 `json:"share,omitempty" db:"share"` // part of unit_price credited to the product
+}
+
+type NotEnoughIng struct {
+	Inventory_id   uint64  `json:"ingredient_id" db:"id"`
+	Inventory_name string  `json:"inventory_name" db:"name"`
+	NotEnough      float64 `json:"not_enough" db:"not_enough"`
 }
 
 // input
@@ -48,17 +87,110 @@ type OutputBatches struct {
 	Processed []Order `json:"processed_orders"`
 
 	Summary struct {
+		Atomic           bool              `json:"atomic,omitempty"`
 		TotalOrders      uint64            `json:"total_orders"`
 		Accepted         uint64            `json:"accepted"`
 		Rejected         uint64            `json:"rejected"`
+		Failed           uint64            `json:"failed,omitempty"` // atomic: rejected by its own error, not rolled back with others
 		TotalRevenue     float64           `json:"total_revenue"`
 		InventoryUpdates []InventoryUpdate `json:"inventory_updates"`
 	} `json:"summary"`
 }
 
+// input: query parameters of GET /orders as they come
+type OrderQuery struct {
+	Status     string // comma separated statuses
+	Customer   string // part of customer_name
+	CustomerID string // customers.id
+	StartDate  string // 02.01.2006
+	EndDate    string
+	MinTotal   string
+	MaxTotal   string
+	Product    string // menu item id
+	SortBy     string // id, created_at, total
+	Order      string // asc, desc
+	PageSize   string
+	Cursor     string
+}
+
+// parsed OrderQuery for dal
+type OrderFilter struct {
+	Statuses   pq.StringArray
+	Customer   string
+	CustomerID *uint64
+	Start      *time.Time
+	End        *time.Time
+	MinTotal   *float64
+	MaxTotal   *float64
+	ProductID  *uint64
+	SortBy     string
+	Desc       bool
+	Limit      uint64
+	After      *OrderCursor
+}
+
+// OrderCursor is the last order of a page, it goes to the client as opaque base64 json
+type OrderCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"` // value of SortBy column of the last order
+	ID     uint64 `json:"i"`
+}
+
+// output
+type OrdersPage struct {
+	Orders      []Order `json:"orders"`
+	PageSize    uint64  `json:"pageSize"`
+	HasNextPage bool    `json:"hasNextPage"`
+	NextCursor  string  `json:"nextCursor,omitempty"`
+}
+
 type StatusHistory struct {
 	ID      uint64    `json:"history_id" db:"id"`
 	OrderID uint64    `json:"order_id" db:"order_id"`
 	Status  string    `json:"status" db:"status"`
 	Updated time.Time `json:"updated_at" db:"updated_at"`
 }
+
+// saved response for the Idempotency-Key header
+type Idempotency struct {
+	Key         string    `db:"key"`
+	Endpoint    string    `db:"endpoint"`
+	RequestHash string    `db:"request_hash"`
+	StatusCode  int       `db:"status_code"` // 0 - still in progress
+	Response    []byte    `db:"response"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// async batch-process job
+type BatchJob struct {
+	ID        uint64          `json:"job_id" db:"id"`
+	Status    string          `json:"status" db:"status"` // queued, running, done, failed
+	Atomic    bool            `json:"atomic" db:"atomic"`
+	Total     uint64          `json:"total" db:"total"`
+	Processed uint64          `json:"processed" db:"processed"`
+	Request   json.RawMessage `json:"-" db:"request"`               // []Order
+	Result    json.RawMessage `json:"result,omitempty" db:"result"` // OutputBatches
+	Error     string          `json:"error,omitempty" db:"error"`
+	LockedBy  string          `json:"-" db:"locked_by"`    // worker of the running job
+	Heartbeat *time.Time      `json:"-" db:"heartbeat_at"` // the lease ends BATCH_LEASE_SECONDS after it
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// event of GET /orders/stream, written by the trigger of orders
+type OrderEvent struct {
+	ID        uint64    `json:"event_id" db:"id"`
+	OrderID   uint64    `json:"order_id" db:"order_id"`
+	Kind      string    `json:"type" db:"kind"` // created, updated, status_changed, deleted
+	Status    string    `json:"status" db:"status"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	Order     *Order    `json:"order,omitempty"` // as it is when the event is sent, nil if deleted
+}
+
+// client of GET /orders/stream
+type OrderSubscription struct {
+	Missed []OrderEvent      // after Last-Event-ID, sent first
+	Events <-chan OrderEvent // live ones, closed if the client is dropped
+	Cancel func()
+}
@@ -0,0 +1,70 @@
+package models
+
+import "time"
+
+// payment of an order. Input: method, amount (default what is due),
+// tendered only for cash (default amount), reference for card and voucher
+type Payment struct {
+	ID        uint64     `json:"payment_id" db:"id"`
+	OrderID   uint64     `json:"order_id" db:"order_id"`
+	Method    string     `json:"method" db:"method"` // cash, card, voucher
+	Amount    float64    `json:"amount" db:"amount"` // part of the order total
+	Tendered  float64    `json:"tendered" db:"tendered"`
+	Change    float64    `json:"change" db:"change_due"` // tendered - amount, filled by server
+	Reference string     `json:"reference,omitempty" db:"reference"`
+	VoidedAt  *time.Time `json:"voided_at,omitempty" db:"voided_at"`
+	CreatedAt time.Time  `json:"created_at,omitzero" db:"created_at"`
+}
+
+// output of GET /orders/{id}/payments, paid and due are without voided payments
+type OrderPayments struct {
+	OrderID  uint64    `json:"order_id" db:"id"`
+	Status   string    `json:"status" db:"status"`
+	Total    float64   `json:"total" db:"total"`
+	Paid     float64   `json:"paid" db:"paid"`
+	Due      float64   `json:"due" db:"due"`
+	Payments []Payment `json:"payments"`
+}
+
+// end-of-day report of payments by method
+type TenderSummary struct {
+	Date     string   `json:"date"` // 02.01.2006
+	Tenders  []Tender `json:"tenders"`
+	Payments uint64   `json:"payments"`
+	Orders   uint64   `json:"orders"`
+	Amount   float64  `json:"amount"`
+	Change   float64  `json:"change"`
+	Refunded float64  `json:"refunded"`
+}
+
+type Tender struct {
+	Method   string  `json:"method" db:"method"`
+	Payments uint64  `json:"payments" db:"payments"`
+	Orders   uint64  `json:"orders" db:"orders"`
+	Amount   float64 `json:"amount" db:"amount"`
+	Tendered float64 `json:"tendered" db:"tendered"`
+	Change   float64 `json:"change" db:"change_due"`
+	Refunded float64 `json:"refunded" db:"refunded"` // refunds of the day by this method
+}
+
+// input of POST /orders/{id}/refund: reason, items to refund (none is all that is left),
+// restock to give their ingredients back and method (default: of the biggest payment, or cash)
+type Refund struct {
+	ID        uint64       `json:"refund_id" db:"id"`
+	OrderID   uint64       `json:"order_id" db:"order_id"`
+	Method    string       `json:"method" db:"method"`
+	Reason    string       `json:"reason" db:"reason"`
+	Restock   bool         `json:"restock" db:"restock"`
+	Amount    float64      `json:"amount" db:"amount"` // filled by server, with the tax
+	Tax       float64      `json:"tax" db:"tax"`
+	Items     []RefundItem `json:"items,omitempty"`
+	Status    string       `json:"order_status,omitempty"` // after the refund: the same or refunded
+	CreatedAt time.Time    `json:"created_at,omitzero" db:"created_at"`
+}
+
+type RefundItem struct {
+	ItemID   uint64  `json:"item_id" db:"item_id"` // order line
+	Quantity uint64  `json:"quantity" db:"quantity"`
+	Amount   float64 `json:"amount" db:"amount"` // filled by server
+	Tax      float64 `json:"tax" db:"tax"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// opening hours of an ISO weekday (1 Monday .. 7 Sunday), "HH:MM"
+type StoreHours struct {
+	Weekday uint64 `json:"weekday" db:"weekday"`
+	Opens   string `json:"opens" db:"opens"`
+	Closes  string `json:"closes" db:"closes"`
+}
+
+// output of GET /store-hours, PUT /store-hours changes only the slots
+type PickupSettings struct {
+	SlotMinutes  *uint64      `json:"slot_minutes" db:"slot_minutes"`
+	SlotCapacity *uint64      `json:"slot_capacity" db:"slot_capacity"`   // orders in 1 slot
+	MaxDaysAhead *uint64      `json:"max_days_ahead" db:"max_days_ahead"` // how far a pre-order can be
+	Timezone     *string      `json:"timezone" db:"timezone"`             // of the hours and slots, Asia/Almaty
+	Hours        []StoreHours `json:"hours,omitempty"`                    // days without hours are closed
+}
+
+// a pre-order in the queue
+type PickupOrder struct {
+	OrderID      uint64    `json:"order_id" db:"id"`
+	CustomerName string    `json:"customer_name" db:"customer_name"`
+	Status       string    `json:"status" db:"status"`
+	Total        float64   `json:"total" db:"total"`
+	PickupAt     time.Time `json:"pickup_at" db:"pickup_at"`
+}
+
+// Taken counts the picked up orders too, Orders are only the ones still to come
+type PickupSlot struct {
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Taken  uint64        `json:"taken"`
+	Free   uint64        `json:"free"`
+	Orders []PickupOrder `json:"orders"`
+}
+
+// output of GET /orders/pickups: slots with pre-orders, the first first
+type PickupQueue struct {
+	SlotMinutes  uint64       `json:"slot_minutes"`
+	SlotCapacity uint64       `json:"slot_capacity"`
+	Timezone     string       `json:"timezone"`
+	Slots        []PickupSlot `json:"slots"`
+}
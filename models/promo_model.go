@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// promo code of an order: percent or fixed amount off the eligible lines.
+// Lines are eligible by ProductIDs or Tags, both empty is every line
+type PromoCode struct {
+	ID                 uint64         `json:"promo_id" db:"id"`
+	Code               string         `json:"code" db:"code"`
+	Description        string         `json:"description" db:"description"`
+	Kind               string         `json:"kind" db:"kind"` // "percent" or "fixed"
+	Value              float64        `json:"value" db:"value"`
+	MinSpend           float64        `json:"min_spend" db:"min_spend"` // subtotal of the order
+	ProductIDs         pq.Int64Array  `json:"product_ids" db:"product_ids"`
+	Tags               pq.StringArray `json:"tags" db:"tags"`
+	StartsAt           *time.Time     `json:"starts_at,omitempty" db:"starts_at"` // nil: no limit
+	EndsAt             *time.Time     `json:"ends_at,omitempty" db:"ends_at"`
+	MaxUses            *uint64        `json:"max_uses,omitempty" db:"max_uses"` // nil: no limit
+	MaxUsesPerCustomer *uint64        `json:"max_uses_per_customer,omitempty" db:"max_uses_per_customer"`
+	Active             *bool          `json:"active" db:"active"`                 // nil on input is true
+	Uses               uint64         `json:"uses" db:"uses"`                     // filled by server, without cancelled orders
+	DiscountTotal      float64        `json:"discount_total" db:"discount_total"` // filled by server
+	CreatedAt          time.Time      `json:"created_at,omitzero" db:"created_at"`
+}
+
+// order that used the code
+type PromoRedemption struct {
+	ID           uint64    `json:"redemption_id" db:"id"`
+	PromoID      uint64    `json:"promo_id" db:"promo_id"`
+	OrderID      uint64    `json:"order_id" db:"order_id"`
+	CustomerID   *uint64   `json:"customer_id,omitempty" db:"customer_id"`
+	CustomerName string    `json:"customer_name" db:"customer_name"`
+	Status       string    `json:"status" db:"status"` // of the order
+	Subtotal     float64   `json:"subtotal" db:"subtotal"`
+	Discount     float64   `json:"discount" db:"discount"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
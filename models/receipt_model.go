@@ -0,0 +1,22 @@
+package models
+
+// printing settings, from the environment (RECEIPT_*)
+type ReceiptStore struct {
+	Name     string
+	Footer   string
+	Currency string
+	Width    int // characters in a line of the printer
+}
+
+// category of an order line, the kitchen ticket is grouped by it
+type ItemCategory struct {
+	ItemID   uint64 `db:"item_id"`
+	Category string `db:"category"` // '' - without category
+}
+
+// rendered receipt or kitchen ticket
+type Printout struct {
+	ContentType string
+	FileName    string
+	Body        []byte
+}
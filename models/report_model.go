@@ -2,11 +2,26 @@ package models
 
 import "github.com/lib/pq"
 
+// gross is the sum of lines, total_sales is what was paid and not refunded,
+// net is total_sales without tax. Tax is without the tax of refunds
+type TotalSales struct {
+	GrossSales      float64 `json:"gross_sales" db:"gross_sales"`
+	Discounts       float64 `json:"discounts" db:"discounts"`
+	Tax             float64 `json:"tax" db:"tax"`
+	Refunds         float64 `json:"refunds" db:"refunds"`
+	NetSales        float64 `json:"net_sales" db:"net_sales"`
+	TotalSales      float64 `json:"total_sales" db:"total_sales"`
+	CancelledOrders uint64  `json:"cancelled_orders" db:"cancelled_orders"`
+	CancelledTotal  float64 `json:"cancelled_total" db:"cancelled_total"`
+}
+
 type PopularItems struct {
 	Items []struct {
-		ID    uint64 `json:"item_id" db:"product_id"`
-		Name  string `json:"name" db:"name"`
-		Count uint64 `json:"count" db:"sum"`
+		ID        uint64  `json:"item_id" db:"product_id"`
+		Name      string  `json:"name" db:"name"`
+		VariantID *uint64 `json:"variant_id,omitempty" db:"variant_id"`
+		Count     uint64  `json:"count" db:"sum"`
+		Revenue   float64 `json:"revenue" db:"revenue"`
 	} `json:"popular_items"`
 }
 
@@ -20,6 +35,7 @@ type SearchThings struct {
 	Menus          []struct {
 		MenuItem
 		InventoryItems pq.StringArray `json:"inventories" db:"inventories"`
+		VariantNames   pq.StringArray `json:"variant_names,omitempty" db:"variant_names"`
 		Relevance      float64        `json:"relevance" db:"relevance"`
 	} `json:"menu_items,omitempty"`
 
@@ -35,10 +51,11 @@ type SearchThings struct {
 }
 
 type OrderStats struct {
-	Period     string              `json:"period"`
-	Month      string              `json:"month,omitempty"`
-	Year       int                 `json:"year,omitempty"`
-	OrderItems []map[string]uint64 `json:"ordered_items"`
+	Period     string               `json:"period"`
+	Month      string               `json:"month,omitempty"`
+	Year       int                  `json:"year,omitempty"`
+	OrderItems []map[string]uint64  `json:"ordered_items"`
+	NetRevenue []map[string]float64 `json:"net_revenue"` // without tax, minus refunds
 }
 
 type GetLeftOvers struct {
@@ -54,3 +71,9 @@ type GetLeftOvers struct {
 		Price    float64 `json:"price" db:"price"`
 	} `json:"data"`
 }
+
+// menu items and variants with margin_percent under the threshold, the lowest first
+type LowMargins struct {
+	Threshold float64    `json:"threshold"`
+	Items     []MenuCost `json:"items"`
+}
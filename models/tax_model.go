@@ -0,0 +1,15 @@
+package models
+
+// tax of a menu category, CategoryID nil is the default for the rest
+type TaxRate struct {
+	ID         uint64  `json:"tax_id" db:"id"`
+	Name       string  `json:"name" db:"name"`
+	Rate       float64 `json:"rate" db:"rate"` // percent
+	CategoryID *uint64 `json:"category_id,omitempty" db:"category_id"`
+}
+
+// output of GET /taxes, PUT /taxes changes only the mode
+type TaxSettings struct {
+	PricesIncludeTax *bool     `json:"prices_include_tax" db:"prices_include_tax"`
+	Rates            []TaxRate `json:"rates,omitempty"`
+}